@@ -0,0 +1,215 @@
+// Package bounds implements run time array bounds checking for Alan: every array element access is
+// given the size of the array, for the generated code to check the index against.
+package bounds
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: The size of local arrays (and string literals) is known statically, but array parameters
+// have no size: they accept arrays of any size. So every array parameter gets an extra parameter
+// holding the actual size of the array passed, of type int and passed by value, and every call
+// passes these along (after the declared arguments, in the order of the array parameters). The
+// size parameter of "a" is named "a_size" (or a variation of it, to keep names unique). Each array
+// element access then gets the size of its array as its bound (see semantic.ArrayElem), either a
+// constant or a reference to the size parameter, unless it's a constant index into a local array
+// (which the checker has already found within bounds). Standard library functions are left alone.
+//
+// The instrumented AST stays checked, like a lifted one (see package lift).
+
+// inserter holds the state of the insertion of bounds checks.
+type inserter struct {
+	// params are the parameters replacing the original ones (which are moved, as parameters are
+	// added), by original declaration.
+	params map[semantic.Node]*semantic.ParDef
+	// sizes are the size parameters of the array parameters.
+	sizes map[*semantic.ParDef]*semantic.ParDef
+}
+
+// Insert gives every array element access in ast the size of its array as its bound, adding size
+// parameters to the functions with array parameters. ast must have passed the semantic checks. It
+// is modified in place.
+func Insert(ast *semantic.Ast) {
+	in := &inserter{
+		params: map[semantic.Node]*semantic.ParDef{},
+		sizes:  map[*semantic.ParDef]*semantic.ParDef{},
+	}
+	// Add all parameters first, as functions may be called before they are defined (e.g. from
+	// nested functions).
+	in.addParams(ast.Program)
+	in.funcDef(ast.Program)
+}
+
+// addParams adds the size parameters of def and of the functions nested in it.
+func (in *inserter) addParams(def *semantic.FuncDef) {
+	taken := map[semantic.ID]bool{}
+	for _, p := range def.Parameters {
+		taken[p.ID] = true
+	}
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.FuncDef:
+			in.addParams(ld)
+		case *semantic.PrimVarDef:
+			taken[ld.ID] = true
+		case *semantic.ArrayDef:
+			taken[ld.ID] = true
+		}
+	}
+
+	params := make([]semantic.ParDef, len(def.Parameters), 2*len(def.Parameters))
+	copy(params, def.Parameters)
+	arrays := []int{}
+	for i, p := range def.Parameters {
+		if _, ok := p.Type.DType.(semantic.ArrayType); !ok {
+			continue
+		}
+		id := p.ID + "_size"
+		name := id
+		for j := 1; taken[name]; j++ {
+			name = id + semantic.ID(strconv.Itoa(j))
+		}
+		taken[name] = true
+		params = append(params, semantic.ParDef{
+			ID:   name,
+			Type: semantic.ParameterType{DType: semantic.PrimitiveTypeInt},
+			Span: p.Span,
+		})
+		arrays = append(arrays, i)
+	}
+	for i := range def.Parameters {
+		in.params[&def.Parameters[i]] = &params[i]
+	}
+	for j, i := range arrays {
+		in.sizes[&params[i]] = &params[len(def.Parameters)+j]
+	}
+	def.Parameters = params
+}
+
+// decl returns the declaration of variable decl, after adding the size parameters.
+func (in *inserter) decl(decl semantic.Node) semantic.Node {
+	if p, ok := in.params[decl]; ok {
+		return p
+	}
+	return decl
+}
+
+// size returns the size of the array expression e (whose declaration is already up to date).
+func (in *inserter) size(e semantic.Expr) semantic.Expr {
+	switch e := e.(type) {
+	case *semantic.VarRef:
+		switch decl := e.Decl.(type) {
+		case *semantic.ArrayDef:
+			return &semantic.IntConstExpr{Val: int64(decl.Type.Size), Span: e.Span}
+		case *semantic.ParDef:
+			s := in.sizes[decl]
+			return &semantic.VarRef{
+				ID:   s.ID,
+				Decl: s,
+				Type: semantic.PrimitiveTypeInt,
+				Span: e.Span,
+			}
+		default:
+			panic(fmt.Sprintf("array declaration of invalid type %T", decl))
+		}
+	case *semantic.StrLitExpr:
+		// String literals are terminated with '\0'.
+		return &semantic.IntConstExpr{Val: int64(len(e.Val) + 1), Span: e.Span}
+	default:
+		panic(fmt.Sprintf("array expression of invalid type %T", e))
+	}
+}
+
+// funcDef rewrites the body of def and of the functions nested in it.
+func (in *inserter) funcDef(def *semantic.FuncDef) {
+	for _, ld := range def.LDefs {
+		if fd, ok := ld.(*semantic.FuncDef); ok {
+			in.funcDef(fd)
+		}
+	}
+	in.stmt(&def.CompStmt)
+}
+
+// stmt rewrites the variable references, the array element accesses and the function calls in s.
+func (in *inserter) stmt(s semantic.Stmt) {
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		for _, s := range s.Stmts {
+			in.stmt(s)
+		}
+	case *semantic.AssignStmt:
+		in.expr(s.Left)
+		in.expr(s.Right)
+	case *semantic.FuncCallStmt:
+		in.call(&s.FuncCall)
+	case *semantic.IfStmt:
+		in.expr(s.Cond)
+		in.stmt(s.Stmt)
+	case *semantic.IfElseStmt:
+		in.expr(s.Cond)
+		in.stmt(s.Stmt1)
+		in.stmt(s.Stmt2)
+	case *semantic.WhileStmt:
+		in.expr(s.Cond)
+		in.stmt(s.Stmt)
+	case *semantic.ReturnStmt:
+		if s.Expr != nil {
+			in.expr(s.Expr)
+		}
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// expr rewrites the variable references, the array element accesses and the function calls in e.
+func (in *inserter) expr(e semantic.Expr) {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr, *semantic.CharConstExpr, *semantic.StrLitExpr,
+		*semantic.ConstCond:
+	case *semantic.VarRef:
+		e.Decl = in.decl(e.Decl)
+	case *semantic.ArrayElem:
+		e.Decl = in.decl(e.Decl)
+		in.expr(e.Index)
+		_, isLocal := e.Decl.(*semantic.ArrayDef)
+		if _, ok := e.Index.(*semantic.IntConstExpr); ok && isLocal {
+			break
+		}
+		e.Bound = in.size(&semantic.VarRef{ID: e.ID, Decl: e.Decl, Span: e.Span})
+	case *semantic.FuncCallExpr:
+		in.call(&e.FuncCall)
+	case *semantic.UnArithExpr:
+		in.expr(e.Expr)
+	case *semantic.BinArithExpr:
+		in.expr(e.Left)
+		in.expr(e.Right)
+	case *semantic.UnCond:
+		in.expr(e.Cond)
+	case *semantic.CompCond:
+		in.expr(e.Left)
+		in.expr(e.Right)
+	case *semantic.BinCond:
+		in.expr(e.Left)
+		in.expr(e.Right)
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// call rewrites function call c to pass the sizes of its array arguments.
+func (in *inserter) call(c *semantic.FuncCall) {
+	for _, a := range c.Args {
+		in.expr(a)
+	}
+	if semantic.IsStdlib(c.Decl) {
+		return
+	}
+	for i, a := range c.Args {
+		if _, ok := c.Decl.Parameters[i].Type.DType.(semantic.ArrayType); ok {
+			c.Args = append(c.Args, in.size(a))
+		}
+	}
+}
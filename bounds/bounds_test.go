@@ -0,0 +1,171 @@
+package bounds_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/bounds"
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/internal/irexec"
+	"github.com/foxeng/alanc/interp"
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+	"github.com/foxeng/alanc/ssa"
+)
+
+const boundsSrc = `main() : proc
+	a : int [5];
+	s : byte [4];
+	fill(x : reference int [], n : int, x_size : int) : proc
+		k : int;
+		peek(j : int) : int
+		{
+			return x[j];
+		}
+	{
+		k = 0;
+		while (k < n) {
+			x[k] = k * k;
+			k = k + 1;
+		}
+		writeInteger(peek(n - 1 + x_size - x_size));
+	}
+	first(t : reference byte [], u : reference byte []) : byte
+	{
+		return t[0] + u[1];
+	}
+{
+	fill(a, 5, 0);
+	writeByte(first("ab", s));
+}
+`
+
+const boundsWant = `main() : proc
+	a : int [5];
+	s : byte [4];
+	fill(x : reference int [], n : int, x_size : int, x_size1 : int) : proc
+		k : int;
+		peek(j : int) : int
+		{
+			return x[j];
+		}
+	{
+		k = 0;
+		while (k < n) {
+			x[k] = k * k;
+			k = k + 1;
+		}
+		writeInteger(peek(n - 1 + x_size - x_size));
+	}
+	first(t : reference byte [], u : reference byte [], t_size : int, u_size : int) : byte
+	{
+		return t[0] + u[1];
+	}
+{
+	fill(a, 5, 0, 5);
+	writeByte(first("ab", s, 3, 4));
+}
+`
+
+func TestInsert(t *testing.T) {
+	ast := alantest.Parse(t, "test.alan", []byte(boundsSrc), semantic.Target{})
+	bounds.Insert(ast)
+	var b strings.Builder
+	if err := semantic.Fprint(&b, ast); err != nil {
+		t.Fatalf("Fprint() failed: %v", err)
+	}
+	if got := b.String(); got != boundsWant {
+		t.Errorf("instrumented program =\n%s\nwant\n%s", got, boundsWant)
+	}
+	// The instrumented program is valid Alan in its own right.
+	alantest.Parse(t, "instrumented.alan", []byte(b.String()), semantic.Target{})
+}
+
+// TestInsertRun checks that inserting bounds checks doesn't change the behavior of (correct)
+// programs, interpreted or compiled to IR.
+func TestInsertRun(t *testing.T) {
+	tests := []struct {
+		file  string
+		src   []byte
+		input string
+	}{
+		{file: "test.alan", src: []byte(boundsSrc)},
+		{file: "hello.alan"},
+		{file: "hanoi.alan", input: "3\n"},
+		{file: "primes.alan", input: "50\n"},
+		{file: "bubblesort.alan"},
+		{file: "reverse.alan"},
+		{file: "cryptography.alan", input: "abc xyz\n"},
+	}
+	for _, test := range tests {
+		src := test.src
+		if src == nil {
+			src = alantest.Example(t, test.file)
+		}
+		var want, got strings.Builder
+		if err := interp.Run(alantest.Parse(t, test.file, src, semantic.Target{}),
+			strings.NewReader(test.input), &want); err != nil {
+			t.Fatalf("Run(%q) failed: %v", test.file, err)
+		}
+		ast := alantest.Parse(t, test.file, src, semantic.Target{})
+		bounds.Insert(ast)
+		if err := interp.Run(ast, strings.NewReader(test.input), &got); err != nil {
+			t.Fatalf("Run(%q) with bounds checks failed: %v", test.file, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("Run(%q) with bounds checks output = %q, want %q", test.file, got.String(),
+				want.String())
+		}
+		for level := 0; level <= 2; level++ {
+			got.Reset()
+			p := ir.Generate(ast)
+			ssa.OptimizeProgram(ast, p, level)
+			if err := irexec.Run(p, strings.NewReader(test.input), &got); err != nil {
+				t.Fatalf("executing the IR of %q with bounds checks at level %d failed: %v",
+					test.file, level, err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("executing the IR of %q with bounds checks at level %d output = %q, "+
+					"want %q", test.file, level, got.String(), want.String())
+			}
+		}
+	}
+}
+
+func TestInsertOutOfBounds(t *testing.T) {
+	src := `main() : proc
+	a : int [5];
+	s : byte [3];
+	get(x : reference int [], i : int) : int
+	{
+		return x[i];
+	}
+{
+	writeInteger(get(a, 4));
+	writeInteger(get(a, readInteger()));
+	s[readInteger() + 3] = 'a';
+}
+`
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "5\n", want: `index 5 out of bounds for array "x" of size 5`},
+		{input: "-1\n", want: `index -1 out of bounds for array "x" of size 5`},
+		{input: "0\n0\n", want: `index 3 out of bounds for array "s" of size 3`},
+	}
+	for _, test := range tests {
+		ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+		bounds.Insert(ast)
+		for level := 0; level <= 2; level++ {
+			p := ir.Generate(ast)
+			ssa.OptimizeProgram(ast, p, level)
+			var out strings.Builder
+			err := irexec.Run(p, strings.NewReader(test.input), &out)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("executing the IR at level %d with input %q = %v, want %q", level,
+					test.input, err, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,551 @@
+package codegen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: The generated assembly (GNU as, AT&T syntax) targets x86-64 Linux and is position
+// independent. Functions follow a calling convention of their own: the caller reserves a slot of 8
+// bytes per argument on the stack, stores the static link (the frame pointer of the function the
+// callee is nested in, 0 for main and the standard library) in the lowest one and the arguments
+// above it, in order. Arguments are evaluated left to right. The callee finds the static link at
+// 16(%rbp) and argument i at 24+8*i(%rbp). Parameters passed by reference (including all arrays)
+// are passed as addresses. Local variables (arrays included, laid out according to their size) are
+// allocated below the saved %rbp and zeroed on entry, as in the other backends. Results are
+// returned in %eax. Expressions are evaluated in %eax, using the stack for intermediate results.
+// Bytes are always kept zero-extended to 32 bits, so that (signed) comparisons and division work on
+// them unchanged, and ints sign-extended from the target's width.
+
+// asmFunc is a function being compiled (or a standard library function, if def is nil).
+type asmFunc struct {
+	// def is the function's definition (nil for standard library functions).
+	def *semantic.FuncDef
+	// name is the function's (mangled) global name.
+	name string
+	// parent is the function this is nested in (nil for main and the standard library).
+	parent *asmFunc
+	// frameSize is the size of the function's local variables, a multiple of 16 bytes.
+	frameSize int
+	// ret is the label of the function's epilogue.
+	ret string
+}
+
+// asmVar is a parameter or a local variable.
+type asmVar struct {
+	// fn is the function the variable belongs to.
+	fn *asmFunc
+	// offset is the offset of the variable's storage from the frame pointer of fn.
+	offset int
+	// typ is the variable's data type.
+	typ semantic.DType
+	// isRef denotes whether the storage holds the variable's address instead of its value (i.e.
+	// for parameters passed by reference, including arrays).
+	isRef bool
+}
+
+// asmGen is the assembly generator for a whole program.
+type asmGen struct {
+	// target is the target the program is compiled for.
+	target semantic.Target
+	// vars are the variables declared by each *semantic.ParDef, *semantic.PrimVarDef and
+	// *semantic.ArrayDef.
+	vars map[semantic.Node]*asmVar
+	// fns are the functions defined by each function definition (including the standard library
+	// ones, once called).
+	fns map[*semantic.FuncDef]*asmFunc
+	// strs are the string literal definitions.
+	strs strings.Builder
+	// nstr is the number of string literals defined so far.
+	nstr int
+	// nlabel is the number of labels used so far.
+	nlabel int
+	// funcs are the function definitions.
+	funcs strings.Builder
+}
+
+// EmitAsm generates x86-64 assembly (GNU as syntax) for ast and writes it to w. The result is
+// complete, including the standard library, and can be assembled and linked against the C library
+// with e.g. gcc or clang. ast must have passed the semantic checks.
+func EmitAsm(w io.Writer, ast *semantic.Ast) error {
+	g := &asmGen{
+		target: ast.Target,
+		vars:   map[semantic.Node]*asmVar{},
+		fns:    map[*semantic.FuncDef]*asmFunc{},
+	}
+	main := g.funcDef(ast.Program, nil)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("\t.text\n")
+	bw.WriteString(g.funcs.String())
+	fmt.Fprintf(bw, "\t.globl main\nmain:\n\tpushq %%rbp\n\tmovq %%rsp, %%rbp\n\tpushq $0\n"+
+		"\tcall %s\n\txorl %%eax, %%eax\n\tleave\n\tret\n\n", main.name)
+	bw.WriteString(asmRuntime)
+	bw.WriteString("\n\t.section .rodata\n")
+	bw.WriteString(g.strs.String())
+	bw.WriteString("\n\t.section .note.GNU-stack,\"\",@progbits\n")
+	return bw.Flush()
+}
+
+// declare records v as the variable declared by decl.
+func (g *asmGen) declare(decl semantic.Node, v *asmVar) {
+	g.vars[decl] = v
+}
+
+// label returns a new label.
+func (g *asmGen) label() string {
+	g.nlabel++
+	return fmt.Sprintf(".L%d", g.nlabel)
+}
+
+// function returns the function defined by def.
+func (g *asmGen) function(def *semantic.FuncDef) *asmFunc {
+	if f, ok := g.fns[def]; ok {
+		return f
+	}
+	if !semantic.IsStdlib(def) {
+		// NOTE: This should never happen, the AST has been checked.
+		panic(fmt.Sprintf("%q not defined", def.ID))
+	}
+	f := &asmFunc{name: "rt." + string(def.ID)}
+	g.fns[def] = f
+	return f
+}
+
+// strLit defines a new string literal and returns its label.
+func (g *asmGen) strLit(s string) string {
+	g.nstr++
+	name := fmt.Sprintf(".Lstr.%d", g.nstr)
+	bs := make([]string, len(s)+1)
+	for i := 0; i < len(s); i++ {
+		bs[i] = fmt.Sprint(s[i])
+	}
+	bs[len(s)] = "0"
+	fmt.Fprintf(&g.strs, "%s:\n\t.byte %s\n", name, strings.Join(bs, ", "))
+	return name
+}
+
+// funcDef generates code for the function defined by def (and any functions nested in it). parent
+// is the function def is nested in (nil for main).
+func (g *asmGen) funcDef(def *semantic.FuncDef, parent *asmFunc) *asmFunc {
+	f := &asmFunc{
+		def:    def,
+		name:   "fn." + string(def.ID),
+		parent: parent,
+		ret:    g.label(),
+	}
+	if parent != nil {
+		f.name = parent.name + "." + string(def.ID)
+	}
+	// Record the function before descending, to allow recursion.
+	g.fns[def] = f
+
+	for i := range def.Parameters {
+		p := &def.Parameters[i]
+		_, isArray := p.Type.DType.(semantic.ArrayType)
+		g.declare(p, &asmVar{
+			fn:     f,
+			offset: 24 + 8*i,
+			typ:    p.Type.DType,
+			isRef:  p.Type.IsRef || isArray,
+		})
+	}
+	// Nested functions are generated as they are encountered, so that they only see what precedes
+	// them.
+	offset := 0
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.FuncDef:
+			g.funcDef(ld, f)
+		case *semantic.PrimVarDef:
+			offset = asmAlloc(offset, ld.Type, 1)
+			g.declare(ld, &asmVar{
+				fn:     f,
+				offset: offset,
+				typ:    ld.Type,
+			})
+		case *semantic.ArrayDef:
+			offset = asmAlloc(offset, ld.Type.PrimitiveType, ld.Type.Size)
+			g.declare(ld, &asmVar{
+				fn:     f,
+				offset: offset,
+				typ:    ld.Type,
+			})
+		default:
+			panic(fmt.Sprintf("local definition of invalid type %T", ld))
+		}
+	}
+	f.frameSize = (-offset + 15) &^ 15
+
+	fg := &asmFuncGen{
+		g:       g,
+		asmFunc: f,
+	}
+	fg.body.WriteString(f.name + ":\n")
+	fg.inst("pushq %%rbp")
+	fg.inst("movq %%rsp, %%rbp")
+	if f.frameSize > 0 {
+		fg.inst("subq $%d, %%rsp", f.frameSize)
+		fg.inst("movq %%rsp, %%rdi")
+		fg.inst("movl $%d, %%ecx", f.frameSize/8)
+		fg.inst("xorl %%eax, %%eax")
+		fg.inst("rep stosq")
+	}
+	fg.compStmt(&def.CompStmt)
+	fg.body.WriteString(f.ret + ":\n")
+	fg.inst("leave")
+	fg.inst("ret")
+	g.funcs.WriteString(fg.body.String())
+	g.funcs.WriteString("\n")
+
+	return f
+}
+
+// asmAlloc allocates n elements of type t below offset (a negative offset from the frame
+// pointer), aligned to the size of t, and returns the offset of the first one.
+func asmAlloc(offset int, t semantic.PrimitiveType, n int) int {
+	size := asmSize(t)
+	offset -= size * n
+	return offset &^ (size - 1)
+}
+
+// asmFuncGen is the state of the generator for a single function body.
+type asmFuncGen struct {
+	g *asmGen
+	*asmFunc
+	// body is the code generated so far.
+	body strings.Builder
+}
+
+// inst emits an instruction.
+func (f *asmFuncGen) inst(format string, a ...interface{}) {
+	f.body.WriteString("\t")
+	fmt.Fprintf(&f.body, format, a...)
+	f.body.WriteString("\n")
+}
+
+// label emits label l.
+func (f *asmFuncGen) label(l string) {
+	f.body.WriteString(l + ":\n")
+}
+
+// frame loads the frame pointer of owner, which must be the current function or one of the
+// functions enclosing it, to %rax, by following static links.
+func (f *asmFuncGen) frame(owner *asmFunc) {
+	f.inst("movq %%rbp, %%rax")
+	for cur := f.asmFunc; cur != owner; cur = cur.parent {
+		f.inst("movq 16(%%rax), %%rax")
+	}
+}
+
+// lookupVar returns the variable declared by decl.
+func (f *asmFuncGen) lookupVar(decl semantic.Node) *asmVar {
+	v, ok := f.g.vars[decl]
+	if !ok {
+		// NOTE: This should never happen, the AST has been checked.
+		panic(fmt.Sprintf("variable declaration %T not found", decl))
+	}
+	return v
+}
+
+// varAddr loads the address of v (of its first element, for arrays) to %rax.
+func (f *asmFuncGen) varAddr(v *asmVar) {
+	f.frame(v.fn)
+	if v.isRef {
+		f.inst("movq %d(%%rax), %%rax", v.offset)
+	} else {
+		f.inst("leaq %d(%%rax), %%rax", v.offset)
+	}
+}
+
+// lvalAddr loads the address of the (primitive) l-value lv to %rax and returns its type.
+func (f *asmFuncGen) lvalAddr(lv semantic.LVal) semantic.PrimitiveType {
+	switch lv := lv.(type) {
+	case *semantic.VarRef:
+		v := f.lookupVar(lv.Decl)
+		f.varAddr(v)
+		return v.typ.(semantic.PrimitiveType)
+	case *semantic.ArrayElem:
+		v := f.lookupVar(lv.Decl)
+		f.expr(lv.Index)
+		if lv.Bound != nil {
+			f.bound(lv)
+		}
+		f.inst("pushq %%rax")
+		f.varAddr(v)
+		f.inst("popq %%rcx")
+		f.inst("movslq %%ecx, %%rcx")
+		t := v.typ.(semantic.ArrayType).PrimitiveType
+		f.inst("leaq (%%rax,%%rcx,%d), %%rax", asmSize(t))
+		return t
+	default:
+		panic(fmt.Sprintf("l-value of invalid type %T", lv))
+	}
+}
+
+// bound generates code checking the index of array element lv, in %eax, against its bound. The
+// index is left in %eax.
+func (f *asmFuncGen) bound(lv *semantic.ArrayElem) {
+	f.inst("pushq %%rax")
+	f.expr(lv.Bound)
+	f.inst("popq %%rcx")
+	ok := f.g.label()
+	// A negative index is out of bounds too, when compared unsigned.
+	f.inst("cmpl %%eax, %%ecx")
+	f.inst("jb %s", ok)
+	f.inst("subq $32, %%rsp")
+	f.inst("movq $0, (%%rsp)")
+	f.inst("movq %%rcx, 8(%%rsp)")
+	f.inst("movq %%rax, 16(%%rsp)")
+	f.inst("leaq %s(%%rip), %%rax", f.g.strLit(string(lv.ID)))
+	f.inst("movq %%rax, 24(%%rsp)")
+	f.inst("call rt.bound")
+	f.label(ok)
+	f.inst("movl %%ecx, %%eax")
+}
+
+// arrayArg loads the address of the first element of the array expression e to %rax.
+func (f *asmFuncGen) arrayArg(e semantic.Expr) {
+	switch e := e.(type) {
+	case *semantic.VarRef:
+		f.varAddr(f.lookupVar(e.Decl))
+	case *semantic.StrLitExpr:
+		f.inst("leaq %s(%%rip), %%rax", f.g.strLit(e.Val))
+	default:
+		panic(fmt.Sprintf("array expression of invalid type %T", e))
+	}
+}
+
+// call generates a function call, leaving its result (if any) in %eax.
+func (f *asmFuncGen) call(c *semantic.FuncCall) {
+	callee := f.g.function(c.Decl)
+	n := 8 * (len(c.Args) + 1)
+	f.inst("subq $%d, %%rsp", n)
+	for i, a := range c.Args {
+		pt := c.Decl.Parameters[i].Type
+		if _, ok := pt.DType.(semantic.ArrayType); ok {
+			f.arrayArg(a)
+		} else if pt.IsRef {
+			f.lvalAddr(a.(semantic.LVal))
+		} else {
+			f.expr(a)
+		}
+		f.inst("movq %%rax, %d(%%rsp)", 8*(i+1))
+	}
+	if callee.parent != nil {
+		f.frame(callee.parent)
+		f.inst("movq %%rax, (%%rsp)")
+	} else {
+		f.inst("movq $0, (%%rsp)")
+	}
+	f.inst("call %s", callee.name)
+	f.inst("addq $%d, %%rsp", n)
+}
+
+// stmt generates code for a statement.
+func (f *asmFuncGen) stmt(s semantic.Stmt) {
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		f.compStmt(s)
+	case *semantic.AssignStmt:
+		f.expr(s.Right)
+		f.inst("pushq %%rax")
+		t := f.lvalAddr(s.Left)
+		f.inst("popq %%rcx")
+		if t == semantic.PrimitiveTypeByte {
+			f.inst("movb %%cl, (%%rax)")
+		} else {
+			f.inst("movl %%ecx, (%%rax)")
+		}
+	case *semantic.FuncCallStmt:
+		f.call(&s.FuncCall)
+	case *semantic.IfStmt:
+		end := f.g.label()
+		f.cond(s.Cond, end, false)
+		f.stmt(s.Stmt)
+		f.label(end)
+	case *semantic.IfElseStmt:
+		els, end := f.g.label(), f.g.label()
+		f.cond(s.Cond, els, false)
+		f.stmt(s.Stmt1)
+		f.inst("jmp %s", end)
+		f.label(els)
+		f.stmt(s.Stmt2)
+		f.label(end)
+	case *semantic.WhileStmt:
+		head, end := f.g.label(), f.g.label()
+		f.label(head)
+		f.cond(s.Cond, end, false)
+		f.stmt(s.Stmt)
+		f.inst("jmp %s", head)
+		f.label(end)
+	case *semantic.ReturnStmt:
+		if s.Expr != nil {
+			f.expr(s.Expr)
+		}
+		f.inst("jmp %s", f.ret)
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// compStmt generates code for a compound statement.
+func (f *asmFuncGen) compStmt(s *semantic.CompStmt) {
+	for _, s := range s.Stmts {
+		f.stmt(s)
+	}
+}
+
+// binOperands evaluates left and right, leaving them in %eax and %ecx respectively.
+func (f *asmFuncGen) binOperands(left, right semantic.Expr) {
+	f.expr(left)
+	f.inst("pushq %%rax")
+	f.expr(right)
+	f.inst("movl %%eax, %%ecx")
+	f.inst("popq %%rax")
+}
+
+// expr generates code for a (primitive) expression, leaving its result in %eax.
+func (f *asmFuncGen) expr(e semantic.Expr) {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr:
+		f.inst("movl $%d, %%eax", int32(e.Val))
+	case *semantic.CharConstExpr:
+		f.inst("movl $%d, %%eax", uint8(e.Val))
+	case *semantic.VarRef, *semantic.ArrayElem:
+		if f.lvalAddr(e.(semantic.LVal)) == semantic.PrimitiveTypeByte {
+			f.inst("movzbl (%%rax), %%eax")
+		} else {
+			f.inst("movl (%%rax), %%eax")
+		}
+	case *semantic.FuncCallExpr:
+		f.call(&e.FuncCall)
+		if semantic.IsStdlib(e.Decl) {
+			// The standard library works with 32-bit ints.
+			f.truncate(e.DataType())
+		}
+	case *semantic.UnArithExpr:
+		f.expr(e.Expr)
+		if e.Sign == semantic.SignMinus {
+			f.inst("negl %%eax")
+			f.truncate(e.DataType())
+		}
+	case *semantic.BinArithExpr:
+		f.binOperands(e.Left, e.Right)
+		switch e.Op {
+		case semantic.ArithOpPlus:
+			f.inst("addl %%ecx, %%eax")
+		case semantic.ArithOpMinus:
+			f.inst("subl %%ecx, %%eax")
+		case semantic.ArithOpMult:
+			f.inst("imull %%ecx, %%eax")
+		case semantic.ArithOpDiv, semantic.ArithOpMod:
+			if e.DataType() == semantic.PrimitiveTypeByte {
+				f.inst("xorl %%edx, %%edx")
+				f.inst("divl %%ecx")
+				if e.Op == semantic.ArithOpMod {
+					f.inst("movl %%edx, %%eax")
+				}
+				break
+			}
+			// idivl faults on the most negative int divided by -1, so dividing by -1 is
+			// special-cased (x / -1 is -x and x % -1 is 0).
+			div, end := f.g.label(), f.g.label()
+			f.inst("cmpl $-1, %%ecx")
+			f.inst("jne %s", div)
+			if e.Op == semantic.ArithOpDiv {
+				f.inst("negl %%eax")
+			} else {
+				f.inst("xorl %%eax, %%eax")
+			}
+			f.inst("jmp %s", end)
+			f.label(div)
+			f.inst("cltd")
+			f.inst("idivl %%ecx")
+			if e.Op == semantic.ArithOpMod {
+				f.inst("movl %%edx, %%eax")
+			}
+			f.label(end)
+		default:
+			panic(fmt.Sprintf("invalid arithmetic operator %q", e.Op))
+		}
+		f.truncate(e.DataType())
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// truncate truncates the value in %eax to a byte (zero-extended), if t is byte, or to the target's
+// int width (sign-extended), if t is int.
+func (f *asmFuncGen) truncate(t semantic.DType) {
+	if t == semantic.PrimitiveTypeByte {
+		f.inst("movzbl %%al, %%eax")
+	} else if t == semantic.PrimitiveTypeInt && f.g.target.IntWidth() == 16 {
+		f.inst("movswl %%ax, %%eax")
+	}
+}
+
+// asmJumps are the conditional jumps for each comparison operator, along with those for its
+// negation.
+var asmJumps = map[semantic.CompOp][2]string{
+	semantic.CompOpEQ: {"je", "jne"},
+	semantic.CompOpNE: {"jne", "je"},
+	semantic.CompOpLT: {"jl", "jge"},
+	semantic.CompOpGT: {"jg", "jle"},
+	semantic.CompOpLE: {"jle", "jg"},
+	semantic.CompOpGE: {"jge", "jl"},
+}
+
+// cond generates code for a condition, jumping to target if its value equals when (and falling
+// through otherwise). Logical operators short-circuit.
+func (f *asmFuncGen) cond(c semantic.Cond, target string, when bool) {
+	switch c := c.(type) {
+	case *semantic.ConstCond:
+		if c.Val == when {
+			f.inst("jmp %s", target)
+		}
+	case *semantic.UnCond:
+		f.cond(c.Cond, target, !when)
+	case *semantic.CompCond:
+		f.binOperands(c.Left, c.Right)
+		f.inst("cmpl %%ecx, %%eax")
+		jumps := asmJumps[c.Op]
+		if when {
+			f.inst("%s %s", jumps[0], target)
+		} else {
+			f.inst("%s %s", jumps[1], target)
+		}
+	case *semantic.BinCond:
+		// The operator decides when the right operand has no effect on the result. If that's
+		// when the jump is taken, both operands can jump to target, otherwise the left one skips
+		// the right one.
+		short := c.Op == semantic.LogOpOr
+		if short == when {
+			f.cond(c.Left, target, when)
+			f.cond(c.Right, target, when)
+		} else {
+			skip := f.g.label()
+			f.cond(c.Left, skip, short)
+			f.cond(c.Right, target, when)
+			f.label(skip)
+		}
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+}
+
+// asmSize returns the size in bytes of a primitive type.
+func asmSize(t semantic.PrimitiveType) int {
+	switch t {
+	case semantic.PrimitiveTypeInt:
+		return 4
+	case semantic.PrimitiveTypeByte, semantic.PrimitiveTypeBool:
+		return 1
+	default:
+		panic(fmt.Sprintf("invalid primitive type %d", t))
+	}
+}
@@ -0,0 +1,250 @@
+package codegen
+
+// asmRuntime is the implementation of the standard library in x86-64 assembly, on top of the C
+// standard library. Each function is named after the corresponding Alan one, prefixed with "rt.",
+// and follows the calling convention of the generated code (see asm.go). Since that doesn't keep
+// the stack aligned, functions calling into the C library align it first. Output is flushed before
+// reading, so that prompts appear even when standard output is not line buffered.
+const asmRuntime = `rt.writeInteger:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	leaq .Lrt.fmt.int(%rip), %rdi
+	movl 24(%rbp), %esi
+	xorl %eax, %eax
+	call printf@PLT
+	leave
+	ret
+
+rt.writeByte:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	leaq .Lrt.fmt.int(%rip), %rdi
+	movzbl 24(%rbp), %esi
+	xorl %eax, %eax
+	call printf@PLT
+	leave
+	ret
+
+rt.writeChar:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	movzbl 24(%rbp), %edi
+	call putchar@PLT
+	leave
+	ret
+
+rt.writeString:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	leaq .Lrt.fmt.str(%rip), %rdi
+	movq 24(%rbp), %rsi
+	xorl %eax, %eax
+	call printf@PLT
+	leave
+	ret
+
+# readInteger skips leading white space and reads an optionally signed decimal integer. A newline
+# right after the integer is consumed as well. %ebx holds the integer read so far and %r12d
+# whether it's negative.
+rt.readInteger:
+	pushq %rbp
+	movq %rsp, %rbp
+	pushq %rbx
+	pushq %r12
+	andq $-16, %rsp
+	xorl %edi, %edi
+	call fflush@PLT
+.Lrt.ri.skip:
+	call getchar@PLT
+	cmpl $32, %eax
+	je .Lrt.ri.skip
+	cmpl $9, %eax
+	jl .Lrt.ri.sign
+	cmpl $13, %eax
+	jle .Lrt.ri.skip
+.Lrt.ri.sign:
+	xorl %r12d, %r12d
+	cmpl $43, %eax
+	je .Lrt.ri.signed
+	cmpl $45, %eax
+	jne .Lrt.ri.digits
+	movl $1, %r12d
+.Lrt.ri.signed:
+	call getchar@PLT
+.Lrt.ri.digits:
+	xorl %ebx, %ebx
+.Lrt.ri.loop:
+	cmpl $48, %eax
+	jl .Lrt.ri.done
+	cmpl $57, %eax
+	jg .Lrt.ri.done
+	imull $10, %ebx, %ebx
+	subl $48, %eax
+	addl %eax, %ebx
+	call getchar@PLT
+	jmp .Lrt.ri.loop
+.Lrt.ri.done:
+	cmpl $10, %eax
+	je .Lrt.ri.exit
+	cmpl $-1, %eax
+	je .Lrt.ri.exit
+	movl %eax, %edi
+	movq stdin@GOTPCREL(%rip), %rsi
+	movq (%rsi), %rsi
+	call ungetc@PLT
+.Lrt.ri.exit:
+	movl %ebx, %eax
+	testl %r12d, %r12d
+	jz .Lrt.ri.ret
+	negl %eax
+.Lrt.ri.ret:
+	leaq -16(%rbp), %rsp
+	popq %r12
+	popq %rbx
+	popq %rbp
+	ret
+
+rt.readByte:
+	call rt.readInteger
+	movzbl %al, %eax
+	ret
+
+rt.readChar:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	xorl %edi, %edi
+	call fflush@PLT
+	call getchar@PLT
+	cmpl $-1, %eax
+	jne .Lrt.rc.ret
+	xorl %eax, %eax
+.Lrt.rc.ret:
+	movzbl %al, %eax
+	leave
+	ret
+
+# readString reads at most n - 1 characters, up to the end of the line, into s. The newline is
+# consumed but not stored. s is always terminated with '\0'. %ebx holds the number of characters
+# read so far, %r12d the limit and %r13 s.
+rt.readString:
+	pushq %rbp
+	movq %rsp, %rbp
+	pushq %rbx
+	pushq %r12
+	pushq %r13
+	andq $-16, %rsp
+	movl 24(%rbp), %r12d
+	subl $1, %r12d
+	jl .Lrt.rs.ret
+	movq 32(%rbp), %r13
+	xorl %edi, %edi
+	call fflush@PLT
+	xorl %ebx, %ebx
+.Lrt.rs.loop:
+	cmpl %r12d, %ebx
+	jge .Lrt.rs.done
+	call getchar@PLT
+	cmpl $10, %eax
+	je .Lrt.rs.done
+	cmpl $-1, %eax
+	je .Lrt.rs.done
+	movb %al, (%r13,%rbx)
+	incl %ebx
+	jmp .Lrt.rs.loop
+.Lrt.rs.done:
+	movb $0, (%r13,%rbx)
+.Lrt.rs.ret:
+	leaq -24(%rbp), %rsp
+	popq %r13
+	popq %r12
+	popq %rbx
+	popq %rbp
+	ret
+
+rt.extend:
+	movzbl 16(%rsp), %eax
+	ret
+
+rt.shrink:
+	movzbl 16(%rsp), %eax
+	ret
+
+# bound reports an index out of the bounds of an array (given the index, the size and the name of
+# the array) and exits.
+rt.bound:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	xorl %edi, %edi
+	call fflush@PLT
+	movq stderr@GOTPCREL(%rip), %rax
+	movq (%rax), %rdi
+	leaq .Lrt.fmt.bound(%rip), %rsi
+	movl 24(%rbp), %edx
+	movq 40(%rbp), %rcx
+	movl 32(%rbp), %r8d
+	xorl %eax, %eax
+	call fprintf@PLT
+	movl $1, %edi
+	call exit@PLT
+
+rt.strlen:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	movq 24(%rbp), %rdi
+	call strlen@PLT
+	leave
+	ret
+
+rt.strcmp:
+	movq 16(%rsp), %rsi
+	movq 24(%rsp), %rdi
+.Lrt.sc.loop:
+	movzbl (%rsi), %eax
+	movzbl (%rdi), %ecx
+	cmpl %ecx, %eax
+	jne .Lrt.sc.ret
+	testl %eax, %eax
+	jz .Lrt.sc.ret
+	incq %rsi
+	incq %rdi
+	jmp .Lrt.sc.loop
+.Lrt.sc.ret:
+	subl %ecx, %eax
+	ret
+
+rt.strcpy:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	movq 24(%rbp), %rdi
+	movq 32(%rbp), %rsi
+	call strcpy@PLT
+	leave
+	ret
+
+rt.strcat:
+	pushq %rbp
+	movq %rsp, %rbp
+	andq $-16, %rsp
+	movq 24(%rbp), %rdi
+	movq 32(%rbp), %rsi
+	call strcat@PLT
+	leave
+	ret
+
+	.section .rodata
+.Lrt.fmt.int:
+	.asciz "%d"
+.Lrt.fmt.str:
+	.asciz "%s"
+.Lrt.fmt.bound:
+	.asciz "runtime error: index %d out of bounds for array \"%s\" of size %d\n"
+	.text
+`
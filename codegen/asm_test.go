@@ -0,0 +1,47 @@
+package codegen
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestEmitAsm(t *testing.T) {
+	if runtime.GOARCH != "amd64" || runtime.GOOS != "linux" {
+		t.Skip("not on x86-64 Linux")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+	for _, test := range runTests {
+		ast := alantest.ParseExample(t, test.file, semantic.Targets[test.target])
+		var src bytes.Buffer
+		if err := EmitAsm(&src, ast); err != nil {
+			t.Errorf("EmitAsm(%q) failed: %v", test.file, err)
+			continue
+		}
+		s := writeTemp(t, "out.s", src.Bytes())
+		exe := filepath.Join(filepath.Dir(s), "out")
+		if out, err := exec.Command(cc, "-o", exe, s).CombinedOutput(); err != nil {
+			t.Errorf("assembling %q failed: %v\n%s", test.file, err, out)
+			continue
+		}
+		cmd := exec.Command(exe)
+		cmd.Stdin = strings.NewReader(test.input)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("running %q failed: %v", test.file, err)
+			continue
+		}
+		if got := string(out); got != test.output {
+			t.Errorf("running %q = %q, want %q", test.file, got, test.output)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package codegen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/foxeng/alanc/lift"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: C has no nested functions, so the program is first lambda lifted (see package lift): every
+// function becomes a top-level C function and every variable it used to capture a pointer
+// parameter. Alan identifiers are prefixed ("f_" for functions, "v_" for variables), so that they
+// can't clash with C keywords or the runtime, whose functions are prefixed with "rt_". Arithmetic
+// on int is carried out on uint32_t, as signed overflow is undefined in C but wraps around in Alan,
+// and the result converted to rt_int, the signed type of the target's int width (so that it wraps
+// around to that width).
+
+// cGen is the C generator for a whole program.
+type cGen struct {
+	// protos are the function prototypes.
+	protos strings.Builder
+	// funcs are the function definitions.
+	funcs strings.Builder
+}
+
+// EmitC generates C99 source for ast and writes it to w. The result is a complete translation unit,
+// including the standard library, that can be compiled with any C99 compiler. ast must have passed
+// the semantic checks. It is lambda lifted in place.
+func EmitC(w io.Writer, ast *semantic.Ast) error {
+	lift.Lift(ast)
+	g := &cGen{}
+	main := ast.Program
+	for _, ld := range main.LDefs {
+		if fd, ok := ld.(*semantic.FuncDef); ok {
+			g.funcDef(fd)
+		}
+	}
+	g.funcDef(main)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(cHeader)
+	fmt.Fprintf(bw, "\ntypedef int%d_t rt_int;\n\n", ast.Target.IntWidth())
+	bw.WriteString(cRuntime)
+	bw.WriteString("\n")
+	bw.WriteString(g.protos.String())
+	bw.WriteString("\n")
+	bw.WriteString(g.funcs.String())
+	fmt.Fprintf(bw, "int main(void)\n{\n\t%s();\n\treturn 0;\n}\n", cFuncName(main))
+	return bw.Flush()
+}
+
+// funcDef generates code for the function defined by def, which must not contain nested functions
+// (other than the lifted ones, if it's main).
+func (g *cGen) funcDef(def *semantic.FuncDef) {
+	params := make([]string, len(def.Parameters))
+	for i, p := range def.Parameters {
+		params[i] = cParDecl(p)
+	}
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+	head := fmt.Sprintf("static %s %s(%s)", cRetType(def.RType), cFuncName(def),
+		strings.Join(params, ", "))
+	fmt.Fprintf(&g.protos, "%s;\n", head)
+
+	fg := &cFunc{}
+	fmt.Fprintf(&fg.body, "%s\n{\n", head)
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.FuncDef:
+			// Lifted, see EmitC.
+		case *semantic.PrimVarDef:
+			fg.line(1, "%s %s = 0;", cPrimType(ld.Type), cVarName(ld.ID))
+		case *semantic.ArrayDef:
+			fg.line(1, "%s %s[%d] = {0};", cPrimType(ld.Type.PrimitiveType), cVarName(ld.ID),
+				ld.Type.Size)
+		default:
+			panic(fmt.Sprintf("local definition of invalid type %T", ld))
+		}
+	}
+	for _, s := range def.CompStmt.Stmts {
+		fg.stmt(s, 1)
+	}
+	fg.body.WriteString("}\n\n")
+	g.funcs.WriteString(fg.body.String())
+}
+
+// cFunc is the state of the generator for a single function body.
+type cFunc struct {
+	// body is the code generated so far.
+	body strings.Builder
+}
+
+// line emits a line of code, indented by indent tabs.
+func (f *cFunc) line(indent int, format string, a ...interface{}) {
+	f.body.WriteString(strings.Repeat("\t", indent))
+	fmt.Fprintf(&f.body, format, a...)
+	f.body.WriteString("\n")
+}
+
+// stmt generates code for statement s, at indentation level indent.
+func (f *cFunc) stmt(s semantic.Stmt, indent int) {
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		f.line(indent, "{")
+		for _, s := range s.Stmts {
+			f.stmt(s, indent+1)
+		}
+		f.line(indent, "}")
+	case *semantic.AssignStmt:
+		f.line(indent, "%s = %s;", cLVal(s.Left), cExpr(s.Right))
+	case *semantic.FuncCallStmt:
+		f.line(indent, "%s;", cCall(&s.FuncCall))
+	case *semantic.IfStmt:
+		f.line(indent, "if (%s)", cCond(s.Cond))
+		f.block(s.Stmt, indent)
+	case *semantic.IfElseStmt:
+		f.line(indent, "if (%s)", cCond(s.Cond))
+		f.block(s.Stmt1, indent)
+		f.line(indent, "else")
+		f.block(s.Stmt2, indent)
+	case *semantic.WhileStmt:
+		f.line(indent, "while (%s)", cCond(s.Cond))
+		f.block(s.Stmt, indent)
+	case *semantic.ReturnStmt:
+		if s.Expr == nil {
+			f.line(indent, "return;")
+		} else {
+			f.line(indent, "return %s;", cExpr(s.Expr))
+		}
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// block generates code for s, the body of a control statement at indentation level indent, always
+// in braces (so that a nested if can't capture an else).
+func (f *cFunc) block(s semantic.Stmt, indent int) {
+	if _, ok := s.(*semantic.CompStmt); !ok {
+		s = &semantic.CompStmt{Stmts: []semantic.Stmt{s}}
+	}
+	f.stmt(s, indent)
+}
+
+// cVar returns the C expression for (the storage of) the variable declared by decl: arrays decay
+// to a pointer to their first element, parameters passed by reference are dereferenced.
+func cVar(decl semantic.Node) string {
+	switch decl := decl.(type) {
+	case *semantic.ParDef:
+		if _, ok := decl.Type.DType.(semantic.PrimitiveType); ok && decl.Type.IsRef {
+			return "(*" + cVarName(decl.ID) + ")"
+		}
+		return cVarName(decl.ID)
+	case *semantic.PrimVarDef:
+		return cVarName(decl.ID)
+	case *semantic.ArrayDef:
+		return cVarName(decl.ID)
+	default:
+		panic(fmt.Sprintf("variable declaration of invalid type %T", decl))
+	}
+}
+
+// cLVal returns the C expression for l-value lv.
+func cLVal(lv semantic.LVal) string {
+	switch lv := lv.(type) {
+	case *semantic.VarRef:
+		return cVar(lv.Decl)
+	case *semantic.ArrayElem:
+		if lv.Bound != nil {
+			return fmt.Sprintf("%s[rt_bound(%s, %s, \"%s\")]", cVar(lv.Decl), cExpr(lv.Index),
+				cExpr(lv.Bound), lv.ID)
+		}
+		return fmt.Sprintf("%s[%s]", cVar(lv.Decl), cExpr(lv.Index))
+	case *semantic.StrLitExpr:
+		return cStrLit(lv.Val)
+	default:
+		panic(fmt.Sprintf("l-value of invalid type %T", lv))
+	}
+}
+
+// cCall returns the C expression for function call c.
+func cCall(c *semantic.FuncCall) string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		pt := c.Decl.Parameters[i].Type
+		if _, ok := pt.DType.(semantic.ArrayType); ok {
+			args[i] = cLVal(a.(semantic.LVal))
+		} else if pt.IsRef {
+			args[i] = "&" + cLVal(a.(semantic.LVal))
+		} else {
+			args[i] = cExpr(a)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", cFuncName(c.Decl), strings.Join(args, ", "))
+}
+
+// cExpr returns the C expression for (primitive) expression e, parenthesized unless it's primary.
+func cExpr(e semantic.Expr) string {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr:
+		v := int32(e.Val)
+		if v == math.MinInt32 {
+			return "INT32_MIN"
+		} else if v < 0 {
+			return "(" + strconv.Itoa(int(v)) + ")"
+		}
+		return strconv.Itoa(int(v))
+	case *semantic.CharConstExpr:
+		return strconv.Itoa(int(uint8(e.Val)))
+	case *semantic.VarRef, *semantic.ArrayElem:
+		return cLVal(e.(semantic.LVal))
+	case *semantic.FuncCallExpr:
+		return cCall(&e.FuncCall)
+	case *semantic.UnArithExpr:
+		v := cExpr(e.Expr)
+		if e.Sign == semantic.SignPlus {
+			return v
+		}
+		if e.DataType() == semantic.PrimitiveTypeByte {
+			return fmt.Sprintf("((uint8_t)-%s)", v)
+		}
+		return fmt.Sprintf("((rt_int)-(uint32_t)%s)", v)
+	case *semantic.BinArithExpr:
+		l, r := cExpr(e.Left), cExpr(e.Right)
+		if e.DataType() == semantic.PrimitiveTypeByte {
+			// Operands are promoted to int, so nothing can overflow before truncating back.
+			return fmt.Sprintf("((uint8_t)(%s %c %s))", l, e.Op, r)
+		}
+		if e.Op == semantic.ArithOpDiv {
+			return fmt.Sprintf("rt_div(%s, %s)", l, r)
+		} else if e.Op == semantic.ArithOpMod {
+			return fmt.Sprintf("rt_mod(%s, %s)", l, r)
+		}
+		return fmt.Sprintf("((rt_int)((uint32_t)%s %c (uint32_t)%s))", l, e.Op, r)
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// cCond returns the C expression for condition c, parenthesized unless it's primary.
+func cCond(c semantic.Cond) string {
+	switch c := c.(type) {
+	case *semantic.ConstCond:
+		return strconv.FormatBool(c.Val)
+	case *semantic.UnCond:
+		return fmt.Sprintf("(!%s)", cCond(c.Cond))
+	case *semantic.CompCond:
+		// Bytes are promoted to (non-negative) ints, so they compare as unsigned.
+		return fmt.Sprintf("(%s %s %s)", cExpr(c.Left), c.Op, cExpr(c.Right))
+	case *semantic.BinCond:
+		op := "||"
+		if c.Op == semantic.LogOpAnd {
+			op = "&&"
+		}
+		return fmt.Sprintf("(%s %s %s)", cCond(c.Left), op, cCond(c.Right))
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+}
+
+// cFuncName returns the C name of the function defined by def.
+func cFuncName(def *semantic.FuncDef) string {
+	if semantic.IsStdlib(def) {
+		return "rt_" + string(def.ID)
+	}
+	return "f_" + string(def.ID)
+}
+
+// cVarName returns the C name of variable id.
+func cVarName(id semantic.ID) string {
+	return "v_" + string(id)
+}
+
+// cPrimType returns the C type of a primitive type.
+func cPrimType(t semantic.PrimitiveType) string {
+	switch t {
+	case semantic.PrimitiveTypeInt:
+		return "int32_t"
+	case semantic.PrimitiveTypeByte:
+		return "uint8_t"
+	case semantic.PrimitiveTypeBool:
+		return "bool"
+	default:
+		panic(fmt.Sprintf("invalid primitive type %d", t))
+	}
+}
+
+// cParDecl returns the C declaration of function parameter p. Parameters passed by reference
+// (including all arrays) are passed as pointers (to the first element, for arrays).
+func cParDecl(p semantic.ParDef) string {
+	switch dt := p.Type.DType.(type) {
+	case semantic.PrimitiveType:
+		if p.Type.IsRef {
+			return cPrimType(dt) + " *" + cVarName(p.ID)
+		}
+		return cPrimType(dt) + " " + cVarName(p.ID)
+	case semantic.ArrayType:
+		return cPrimType(dt.PrimitiveType) + " *" + cVarName(p.ID)
+	default:
+		panic(fmt.Sprintf("function parameter of invalid data type %T", dt))
+	}
+}
+
+// cRetType returns the C return type for a function with return type t.
+func cRetType(t *semantic.PrimitiveType) string {
+	if t == nil {
+		return "void"
+	}
+	return cPrimType(*t)
+}
+
+// cStrLit returns string s as a C expression of type uint8_t *.
+func cStrLit(s string) string {
+	var b strings.Builder
+	b.WriteString("((uint8_t *)\"")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		// NOTE: Octal escapes are always 3 digits long, so a following digit can't extend them. '?'
+		// is escaped to avoid trigraphs.
+		if c < ' ' || c > '~' || c == '"' || c == '\\' || c == '?' {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("\")")
+	return b.String()
+}
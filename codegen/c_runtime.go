@@ -0,0 +1,147 @@
+package codegen
+
+// cHeader includes the C standard library headers needed by the runtime and the generated code.
+const cHeader = `#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+`
+
+// cRuntime is the implementation of the standard library in C, on top of the C standard library.
+// Each function is named after the corresponding Alan one, prefixed with "rt_". Output is flushed
+// before reading, so that prompts appear even when standard output is not line buffered. It relies
+// on rt_int, the signed integer type of the target's int width, to wrap ints around.
+const cRuntime = `static void rt_writeInteger(int32_t n)
+{
+	printf("%ld", (long)n);
+}
+
+static void rt_writeByte(uint8_t b)
+{
+	printf("%u", (unsigned)b);
+}
+
+static void rt_writeChar(uint8_t b)
+{
+	putchar(b);
+}
+
+static void rt_writeString(uint8_t *s)
+{
+	fputs((char *)s, stdout);
+}
+
+/* readInteger skips leading white space and reads an optionally signed decimal integer. A newline
+ * right after the integer is consumed as well. */
+static int32_t rt_readInteger(void)
+{
+	uint32_t n = 0;
+	bool neg = false;
+	int c;
+
+	fflush(stdout);
+	do {
+		c = getchar();
+	} while (c == ' ' || (c >= '\t' && c <= '\r'));
+	if (c == '-' || c == '+') {
+		neg = c == '-';
+		c = getchar();
+	}
+	for (; c >= '0' && c <= '9'; c = getchar())
+		n = 10 * n + (uint32_t)(c - '0');
+	if (c != '\n' && c != EOF)
+		ungetc(c, stdin);
+	return (rt_int)(neg ? -n : n);
+}
+
+static uint8_t rt_readByte(void)
+{
+	return (uint8_t)rt_readInteger();
+}
+
+static uint8_t rt_readChar(void)
+{
+	int c;
+
+	fflush(stdout);
+	c = getchar();
+	return c == EOF ? 0 : (uint8_t)c;
+}
+
+/* readString reads at most n - 1 characters, up to the end of the line, into s. The newline is
+ * consumed but not stored. s is always terminated with '\0'. */
+static void rt_readString(int32_t n, uint8_t *s)
+{
+	int32_t i;
+	int c;
+
+	if (n <= 0)
+		return;
+	fflush(stdout);
+	for (i = 0; i < n - 1; i++) {
+		c = getchar();
+		if (c == '\n' || c == EOF)
+			break;
+		s[i] = (uint8_t)c;
+	}
+	s[i] = 0;
+}
+
+static int32_t rt_extend(uint8_t b)
+{
+	return b;
+}
+
+static uint8_t rt_shrink(int32_t n)
+{
+	return (uint8_t)n;
+}
+
+/* div and mod divide ints like / and %, except that x / -1 is -x (wrapping around) and x % -1 is
+ * 0, whatever x (both overflow in C for the most negative int). */
+static int32_t rt_div(int32_t x, int32_t y)
+{
+	return y == -1 ? (rt_int)-(uint32_t)x : x / y;
+}
+
+static int32_t rt_mod(int32_t x, int32_t y)
+{
+	return y == -1 ? 0 : x % y;
+}
+
+/* bound returns index i of array name, of size n, after checking it's within bounds (otherwise it
+ * reports the error and exits). */
+static int32_t rt_bound(int32_t i, int32_t n, const char *name)
+{
+	if (i < 0 || i >= n) {
+		fflush(stdout);
+		fprintf(stderr, "runtime error: index %ld out of bounds for array \"%s\" of size %ld\n",
+			(long)i, name, (long)n);
+		exit(1);
+	}
+	return i;
+}
+
+static int32_t rt_strlen(uint8_t *s)
+{
+	return (rt_int)strlen((char *)s);
+}
+
+static int32_t rt_strcmp(uint8_t *s1, uint8_t *s2)
+{
+	for (; *s1 == *s2 && *s1 != 0; s1++, s2++)
+		;
+	return *s1 - *s2;
+}
+
+static void rt_strcpy(uint8_t *trg, uint8_t *src)
+{
+	strcpy((char *)trg, (char *)src);
+}
+
+static void rt_strcat(uint8_t *trg, uint8_t *src)
+{
+	strcat((char *)trg, (char *)src);
+}
+`
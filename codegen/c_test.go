@@ -0,0 +1,92 @@
+package codegen
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/bounds"
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestEmitC(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+	for _, test := range runTests {
+		ast := alantest.ParseExample(t, test.file, semantic.Targets[test.target])
+		var src bytes.Buffer
+		if err := EmitC(&src, ast); err != nil {
+			t.Errorf("EmitC(%q) failed: %v", test.file, err)
+			continue
+		}
+		c := writeTemp(t, "out.c", src.Bytes())
+		exe := filepath.Join(filepath.Dir(c), "out")
+		if out, err := exec.Command(cc, "-std=c99", "-o", exe, c).CombinedOutput(); err != nil {
+			t.Errorf("compiling %q failed: %v\n%s", test.file, err, out)
+			continue
+		}
+		cmd := exec.Command(exe)
+		cmd.Stdin = strings.NewReader(test.input)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("running %q failed: %v", test.file, err)
+			continue
+		}
+		if got := string(out); got != test.output {
+			t.Errorf("running %q = %q, want %q", test.file, got, test.output)
+		}
+	}
+}
+
+func TestEmitCBounds(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+	src := `main() : proc
+	a : int [5];
+	get(x : reference int [], i : int) : int
+	{
+		return x[i];
+	}
+{
+	writeInteger(get(a, readInteger()));
+}
+`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	bounds.Insert(ast)
+	var b bytes.Buffer
+	if err := EmitC(&b, ast); err != nil {
+		t.Fatalf("EmitC() failed: %v", err)
+	}
+	c := writeTemp(t, "out.c", b.Bytes())
+	exe := filepath.Join(filepath.Dir(c), "out")
+	if out, err := exec.Command(cc, "-std=c99", "-o", exe, c).CombinedOutput(); err != nil {
+		t.Fatalf("compiling failed: %v\n%s", err, out)
+	}
+	for _, test := range []struct {
+		input, stdout, stderr string
+	}{
+		{input: "4\n", stdout: "0"},
+		{input: "5\n", stderr: "runtime error: index 5 out of bounds for array \"x\" of size 5\n"},
+		{input: "-1\n", stderr: "runtime error: index -1 out of bounds for array \"x\" of size 5\n"},
+	} {
+		var stdout, stderr strings.Builder
+		cmd := exec.Command(exe)
+		cmd.Stdin = strings.NewReader(test.input)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err := cmd.Run()
+		if (err == nil) != (test.stderr == "") {
+			t.Errorf("running with input %q: error = %v", test.input, err)
+		}
+		if stdout.String() != test.stdout || stderr.String() != test.stderr {
+			t.Errorf("running with input %q = %q (stderr %q), want %q (stderr %q)", test.input,
+				stdout.String(), stderr.String(), test.stdout, test.stderr)
+		}
+	}
+}
@@ -0,0 +1,655 @@
+// Package codegen contains the code generation backends for Alan.
+package codegen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Every function keeps its parameters and local variables in a frame, a struct allocated on
+// the stack on entry. Nested functions receive a pointer to the frame of the function they are
+// defined in (the static link) as their first argument, which they store in the first slot of
+// their own frame. Accessing a variable of an enclosing function thus means following as many
+// static links as the difference in nesting depth.
+
+// function is a function being compiled (or a standard library function, if def is nil).
+type function struct {
+	// def is the function's definition (nil for standard library functions).
+	def *semantic.FuncDef
+	// name is the function's (mangled) global name.
+	name string
+	// typ is the function's type.
+	typ semantic.FunctionType
+	// parent is the function this is nested in (nil for main and the standard library).
+	parent *function
+	// depth is the function's nesting depth (0 for main).
+	depth int
+	// slots are the LLVM types of the slots of the function's frame. The first slot always holds
+	// the static link.
+	slots []string
+}
+
+// variable is a parameter or a local variable.
+type variable struct {
+	// fn is the function the variable belongs to.
+	fn *function
+	// slot is the index of the variable's slot in fn's frame.
+	slot int
+	// typ is the variable's data type.
+	typ semantic.DType
+	// isRef denotes whether the slot holds the variable's address instead of its value (i.e. for
+	// parameters passed by reference).
+	isRef bool
+}
+
+// llvmGen is the LLVM IR generator for a whole program.
+type llvmGen struct {
+	// target is the target the program is compiled for.
+	target semantic.Target
+	// vars are the variables declared by each *semantic.ParDef, *semantic.PrimVarDef and
+	// *semantic.ArrayDef.
+	vars map[semantic.Node]*variable
+	// fns are the functions defined by each function definition (including the standard library
+	// ones, once called).
+	fns map[*semantic.FuncDef]*function
+	// types are the frame type definitions.
+	types strings.Builder
+	// strs are the string literal definitions.
+	strs strings.Builder
+	// nstr is the number of string literals defined so far.
+	nstr int
+	// funcs are the function definitions.
+	funcs strings.Builder
+}
+
+// EmitLLVM generates LLVM IR (in textual form) for ast and writes it to w. The result is a complete
+// module, including the standard library, that can be compiled with llc or clang. ast must have
+// passed the semantic checks.
+func EmitLLVM(w io.Writer, ast *semantic.Ast) error {
+	g := &llvmGen{
+		target: ast.Target,
+		vars:   map[semantic.Node]*variable{},
+		fns:    map[*semantic.FuncDef]*function{},
+	}
+	main := g.funcDef(ast.Program, nil)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(llvmRuntime)
+	bw.WriteString("\n")
+	bw.WriteString(g.strs.String())
+	bw.WriteString("\n")
+	bw.WriteString(g.types.String())
+	bw.WriteString("\n")
+	bw.WriteString(g.funcs.String())
+	fmt.Fprintf(bw, "define i32 @main() {\n\tcall void @%s()\n\tret i32 0\n}\n", main.name)
+	return bw.Flush()
+}
+
+// declare records v as the variable declared by decl.
+func (g *llvmGen) declare(decl semantic.Node, v *variable) {
+	g.vars[decl] = v
+}
+
+// function returns the function defined by def.
+func (g *llvmGen) function(def *semantic.FuncDef) *function {
+	if f, ok := g.fns[def]; ok {
+		return f
+	}
+	if !semantic.IsStdlib(def) {
+		// NOTE: This should never happen, the AST has been checked.
+		panic(fmt.Sprintf("%q not defined", def.ID))
+	}
+	ft, _ := semantic.Stdlib(def.ID)
+	f := &function{
+		name: "rt." + string(def.ID),
+		typ:  ft,
+	}
+	g.fns[def] = f
+	return f
+}
+
+// strLit defines a new string literal and returns a pointer to its first character (as a constant
+// expression).
+func (g *llvmGen) strLit(s string) string {
+	g.nstr++
+	name := fmt.Sprintf("@.str.%d", g.nstr)
+	n := len(s) + 1
+	fmt.Fprintf(&g.strs, "%s = private unnamed_addr constant [%d x i8] c\"%s\\00\"\n", name, n,
+		llvmEscape(s))
+	return fmt.Sprintf("getelementptr inbounds ([%d x i8], [%d x i8]* %s, i32 0, i32 0)", n, n,
+		name)
+}
+
+// funcDef generates code for the function defined by def (and any functions nested in it). parent
+// is the function def is nested in (nil for main).
+func (g *llvmGen) funcDef(def *semantic.FuncDef, parent *function) *function {
+	f := &function{
+		def:    def,
+		name:   "fn." + string(def.ID),
+		parent: parent,
+		typ: semantic.FunctionType{
+			Parameters: make([]semantic.ParameterType, len(def.Parameters)),
+			Return:     def.RType,
+		},
+	}
+	link := "i8*"
+	if parent != nil {
+		f.name = parent.name + "." + string(def.ID)
+		f.depth = parent.depth + 1
+		link = llvmFrameType(parent) + "*"
+	}
+	f.slots = []string{link}
+	for i, p := range def.Parameters {
+		f.typ.Parameters[i] = p.Type
+	}
+	// Record the function before descending, to allow recursion.
+	g.fns[def] = f
+
+	// Allocate slots for parameters and local variables. Nested functions are generated as they
+	// are encountered, so that they only see what precedes them.
+	params := []string{}
+	if parent != nil {
+		params = append(params, link+" %link")
+	}
+	for i := range def.Parameters {
+		p := &def.Parameters[i]
+		t := llvmParType(p.Type)
+		params = append(params, fmt.Sprintf("%s %%p%d", t, i))
+		g.declare(p, &variable{
+			fn:    f,
+			slot:  len(f.slots),
+			typ:   p.Type.DType,
+			isRef: p.Type.IsRef,
+		})
+		f.slots = append(f.slots, t)
+	}
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.FuncDef:
+			g.funcDef(ld, f)
+		case *semantic.PrimVarDef:
+			g.declare(ld, &variable{
+				fn:   f,
+				slot: len(f.slots),
+				typ:  ld.Type,
+			})
+			f.slots = append(f.slots, llvmPrimType(ld.Type))
+		case *semantic.ArrayDef:
+			g.declare(ld, &variable{
+				fn:   f,
+				slot: len(f.slots),
+				typ:  ld.Type,
+			})
+			f.slots = append(f.slots, fmt.Sprintf("[%d x %s]", ld.Type.Size,
+				llvmPrimType(ld.Type.PrimitiveType)))
+		default:
+			panic(fmt.Sprintf("local definition of invalid type %T", ld))
+		}
+	}
+	fmt.Fprintf(&g.types, "%s = type { %s }\n", llvmFrameType(f), strings.Join(f.slots, ", "))
+
+	// Generate the body.
+	fg := &llvmFunc{
+		g:        g,
+		function: f,
+		block:    "entry",
+	}
+	ft := llvmFrameType(f)
+	fg.inst("%%frame = alloca %s", ft)
+	// Locals start at zero, as in the other backends (and the interpreter).
+	fg.inst("store %s zeroinitializer, %s* %%frame", ft, ft)
+	if parent != nil {
+		p := fg.slotPtr("%frame", f, 0)
+		fg.inst("store %s %%link, %s* %s", link, link, p)
+	}
+	for i := range def.Parameters {
+		p := fg.slotPtr("%frame", f, i+1)
+		fg.inst("store %s %%p%d, %s* %s", f.slots[i+1], i, f.slots[i+1], p)
+	}
+	fg.compStmt(&def.CompStmt)
+	// Make sure control does not fall off the end.
+	if !fg.terminated {
+		if def.RType == nil {
+			fg.term("ret void")
+		} else {
+			fg.term("ret %s 0", llvmPrimType(*def.RType))
+		}
+	}
+
+	fmt.Fprintf(&g.funcs, "define internal %s @%s(%s) {\nentry:\n", llvmRetType(def.RType), f.name,
+		strings.Join(params, ", "))
+	g.funcs.WriteString(fg.body.String())
+	g.funcs.WriteString("}\n\n")
+
+	return f
+}
+
+// llvmFunc is the state of the generator for a single function body.
+type llvmFunc struct {
+	g *llvmGen
+	*function
+	// body is the code generated so far.
+	body strings.Builder
+	// ntemp is the number of temporaries used so far.
+	ntemp int
+	// nlabel is the number of labels used so far.
+	nlabel int
+	// block is the label of the current basic block.
+	block string
+	// terminated denotes whether the current basic block has been terminated.
+	terminated bool
+}
+
+// temp returns a new temporary.
+func (f *llvmFunc) temp() string {
+	f.ntemp++
+	return fmt.Sprintf("%%t%d", f.ntemp)
+}
+
+// label returns a new label.
+func (f *llvmFunc) label() string {
+	f.nlabel++
+	return fmt.Sprintf("L%d", f.nlabel)
+}
+
+// startBlock starts a new basic block labeled l, falling through to it from the current one if
+// that is not terminated.
+func (f *llvmFunc) startBlock(l string) {
+	if !f.terminated {
+		fmt.Fprintf(&f.body, "\tbr label %%%s\n", l)
+	}
+	fmt.Fprintf(&f.body, "%s:\n", l)
+	f.block = l
+	f.terminated = false
+}
+
+// open makes sure there is an open basic block to emit instructions in. Code following a
+// terminator (e.g. statements after a return) is placed in a new, unreachable block.
+func (f *llvmFunc) open() {
+	if f.terminated {
+		f.startBlock(f.label())
+	}
+}
+
+// inst emits an instruction.
+func (f *llvmFunc) inst(format string, a ...interface{}) {
+	f.open()
+	f.body.WriteString("\t")
+	fmt.Fprintf(&f.body, format, a...)
+	f.body.WriteString("\n")
+}
+
+// term emits a terminator instruction.
+func (f *llvmFunc) term(format string, a ...interface{}) {
+	f.inst(format, a...)
+	f.terminated = true
+}
+
+// value emits an instruction producing a value and returns the temporary holding it.
+func (f *llvmFunc) value(format string, a ...interface{}) string {
+	t := f.temp()
+	f.inst("%s = "+format, append([]interface{}{t}, a...)...)
+	return t
+}
+
+// slotPtr returns a pointer to slot of the frame of fn, pointed to by fp.
+func (f *llvmFunc) slotPtr(fp string, fn *function, slot int) string {
+	ft := llvmFrameType(fn)
+	return f.value("getelementptr %s, %s* %s, i32 0, i32 %d", ft, ft, fp, slot)
+}
+
+// frame returns a pointer to the frame of owner, which must be the current function or one of
+// the functions enclosing it, by following static links.
+func (f *llvmFunc) frame(owner *function) string {
+	fp := "%frame"
+	for cur := f.function; cur != owner; cur = cur.parent {
+		p := f.slotPtr(fp, cur, 0)
+		fp = f.value("load %s, %s* %s", cur.slots[0], cur.slots[0], p)
+	}
+	return fp
+}
+
+// lookupVar returns the variable declared by decl.
+func (f *llvmFunc) lookupVar(decl semantic.Node) *variable {
+	v, ok := f.g.vars[decl]
+	if !ok {
+		// NOTE: This should never happen, the AST has been checked.
+		panic(fmt.Sprintf("variable declaration %T not found", decl))
+	}
+	return v
+}
+
+// varAddr returns a pointer to the storage of v. For arrays passed as parameters that is a pointer
+// to their first element, for local arrays a pointer to the whole array.
+func (f *llvmFunc) varAddr(v *variable) string {
+	p := f.slotPtr(f.frame(v.fn), v.fn, v.slot)
+	if v.isRef {
+		t := v.fn.slots[v.slot]
+		return f.value("load %s, %s* %s", t, t, p)
+	}
+	return p
+}
+
+// arrayBase returns a pointer to the first element of array v.
+func (f *llvmFunc) arrayBase(v *variable) string {
+	a := f.varAddr(v)
+	if v.isRef {
+		return a
+	}
+	at := v.fn.slots[v.slot]
+	return f.value("getelementptr %s, %s* %s, i32 0, i32 0", at, at, a)
+}
+
+// lvalAddr returns a pointer to the storage of the (primitive) l-value lv, along with its type.
+func (f *llvmFunc) lvalAddr(lv semantic.LVal) (string, semantic.PrimitiveType) {
+	switch lv := lv.(type) {
+	case *semantic.VarRef:
+		v := f.lookupVar(lv.Decl)
+		return f.varAddr(v), v.typ.(semantic.PrimitiveType)
+	case *semantic.ArrayElem:
+		v := f.lookupVar(lv.Decl)
+		base := f.arrayBase(v)
+		i, _ := f.expr(lv.Index)
+		if lv.Bound != nil {
+			f.bound(lv, i)
+		}
+		t := v.typ.(semantic.ArrayType).PrimitiveType
+		lt := llvmPrimType(t)
+		return f.value("getelementptr %s, %s* %s, i32 %s", lt, lt, base, i), t
+	default:
+		panic(fmt.Sprintf("l-value of invalid type %T", lv))
+	}
+}
+
+// bound generates code checking i, the index of array element lv, against its bound.
+func (f *llvmFunc) bound(lv *semantic.ArrayElem, i string) {
+	n, _ := f.expr(lv.Bound)
+	// A negative index is out of bounds too, when compared unsigned.
+	in := f.value("icmp ult i32 %s, %s", i, n)
+	ok, fail := f.label(), f.label()
+	f.term("br i1 %s, label %%%s, label %%%s", in, ok, fail)
+	f.startBlock(fail)
+	f.inst("call void @rt.bound(i8* %s, i32 %s, i32 %s)", f.g.strLit(string(lv.ID)), i, n)
+	f.term("unreachable")
+	f.startBlock(ok)
+}
+
+// arrayArg returns a pointer to the first element of the array expression e.
+func (f *llvmFunc) arrayArg(e semantic.Expr) string {
+	switch e := e.(type) {
+	case *semantic.VarRef:
+		return f.arrayBase(f.lookupVar(e.Decl))
+	case *semantic.StrLitExpr:
+		return f.g.strLit(e.Val)
+	default:
+		panic(fmt.Sprintf("array expression of invalid type %T", e))
+	}
+}
+
+// call generates a function call and returns the temporary holding its result (empty for
+// procedures).
+func (f *llvmFunc) call(c *semantic.FuncCall) string {
+	callee := f.g.function(c.Decl)
+	args := []string{}
+	if callee.parent != nil {
+		args = append(args, fmt.Sprintf("%s* %s", llvmFrameType(callee.parent),
+			f.frame(callee.parent)))
+	}
+	for i, a := range c.Args {
+		pt := callee.typ.Parameters[i]
+		var v string
+		if _, ok := pt.DType.(semantic.ArrayType); ok {
+			v = f.arrayArg(a)
+		} else if pt.IsRef {
+			v, _ = f.lvalAddr(a.(semantic.LVal))
+		} else {
+			v, _ = f.expr(a)
+		}
+		args = append(args, llvmParType(pt)+" "+v)
+	}
+
+	if callee.typ.Return == nil {
+		f.inst("call void @%s(%s)", callee.name, strings.Join(args, ", "))
+		return ""
+	}
+	v := f.value("call %s @%s(%s)", llvmPrimType(*callee.typ.Return), callee.name,
+		strings.Join(args, ", "))
+	if callee.def == nil {
+		// The standard library works with 32-bit ints.
+		v = f.wrap(v, *callee.typ.Return)
+	}
+	return v
+}
+
+// wrap returns the value of v, of type t, wrapped around to the range of int (if t is int).
+func (f *llvmFunc) wrap(v string, t semantic.PrimitiveType) string {
+	if t != semantic.PrimitiveTypeInt || f.g.target.IntWidth() == 32 {
+		return v
+	}
+	it := fmt.Sprintf("i%d", f.g.target.IntWidth())
+	v = f.value("trunc i32 %s to %s", v, it)
+	return f.value("sext %s %s to i32", it, v)
+}
+
+// stmt generates code for a statement.
+func (f *llvmFunc) stmt(s semantic.Stmt) {
+	f.open()
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		f.compStmt(s)
+	case *semantic.AssignStmt:
+		a, t := f.lvalAddr(s.Left)
+		v, _ := f.expr(s.Right)
+		lt := llvmPrimType(t)
+		f.inst("store %s %s, %s* %s", lt, v, lt, a)
+	case *semantic.FuncCallStmt:
+		f.call(&s.FuncCall)
+	case *semantic.IfStmt:
+		c := f.cond(s.Cond)
+		then, end := f.label(), f.label()
+		f.term("br i1 %s, label %%%s, label %%%s", c, then, end)
+		f.startBlock(then)
+		f.stmt(s.Stmt)
+		f.startBlock(end)
+	case *semantic.IfElseStmt:
+		c := f.cond(s.Cond)
+		then, els, end := f.label(), f.label(), f.label()
+		f.term("br i1 %s, label %%%s, label %%%s", c, then, els)
+		f.startBlock(then)
+		f.stmt(s.Stmt1)
+		if !f.terminated {
+			f.term("br label %%%s", end)
+		}
+		f.startBlock(els)
+		f.stmt(s.Stmt2)
+		f.startBlock(end)
+	case *semantic.WhileStmt:
+		head, body, end := f.label(), f.label(), f.label()
+		f.startBlock(head)
+		c := f.cond(s.Cond)
+		f.term("br i1 %s, label %%%s, label %%%s", c, body, end)
+		f.startBlock(body)
+		f.stmt(s.Stmt)
+		if !f.terminated {
+			f.term("br label %%%s", head)
+		}
+		f.startBlock(end)
+	case *semantic.ReturnStmt:
+		if s.Expr == nil {
+			f.term("ret void")
+		} else {
+			v, t := f.expr(s.Expr)
+			f.term("ret %s %s", llvmPrimType(t), v)
+		}
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// compStmt generates code for a compound statement.
+func (f *llvmFunc) compStmt(s *semantic.CompStmt) {
+	for _, s := range s.Stmts {
+		f.stmt(s)
+	}
+}
+
+// expr generates code for a (primitive) expression and returns the value holding its result,
+// along with its type.
+func (f *llvmFunc) expr(e semantic.Expr) (string, semantic.PrimitiveType) {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr:
+		return strconv.Itoa(int(f.g.target.WrapInt(int32(e.Val)))), semantic.PrimitiveTypeInt
+	case *semantic.CharConstExpr:
+		return strconv.Itoa(int(int8(e.Val))), semantic.PrimitiveTypeByte
+	case *semantic.VarRef, *semantic.ArrayElem:
+		a, t := f.lvalAddr(e.(semantic.LVal))
+		lt := llvmPrimType(t)
+		return f.value("load %s, %s* %s", lt, lt, a), t
+	case *semantic.FuncCallExpr:
+		v := f.call(&e.FuncCall)
+		return v, e.Type.(semantic.PrimitiveType)
+	case *semantic.UnArithExpr:
+		v, t := f.expr(e.Expr)
+		if e.Sign == semantic.SignMinus {
+			v = f.wrap(f.value("sub %s 0, %s", llvmPrimType(t), v), t)
+		}
+		return v, t
+	case *semantic.BinArithExpr:
+		l, t := f.expr(e.Left)
+		r, _ := f.expr(e.Right)
+		var op string
+		switch e.Op {
+		case semantic.ArithOpPlus:
+			op = "add"
+		case semantic.ArithOpMinus:
+			op = "sub"
+		case semantic.ArithOpMult:
+			op = "mul"
+		case semantic.ArithOpDiv:
+			if t == semantic.PrimitiveTypeInt {
+				// sdiv overflows on the most negative int divided by -1.
+				return f.wrap(f.value("call i32 @rt.div(i32 %s, i32 %s)", l, r), t), t
+			}
+			op = "udiv"
+		case semantic.ArithOpMod:
+			if t == semantic.PrimitiveTypeInt {
+				return f.value("call i32 @rt.mod(i32 %s, i32 %s)", l, r), t
+			}
+			op = "urem"
+		default:
+			panic(fmt.Sprintf("invalid arithmetic operator %q", e.Op))
+		}
+		return f.wrap(f.value("%s %s %s, %s", op, llvmPrimType(t), l, r), t), t
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// cond generates code for a condition and returns the (i1) value holding its result.
+func (f *llvmFunc) cond(c semantic.Cond) string {
+	switch c := c.(type) {
+	case *semantic.ConstCond:
+		return strconv.FormatBool(c.Val)
+	case *semantic.UnCond:
+		v := f.cond(c.Cond)
+		return f.value("xor i1 %s, true", v)
+	case *semantic.CompCond:
+		l, t := f.expr(c.Left)
+		r, _ := f.expr(c.Right)
+		preds := map[semantic.CompOp]string{
+			semantic.CompOpEQ: "eq",
+			semantic.CompOpNE: "ne",
+			semantic.CompOpLT: "slt",
+			semantic.CompOpGT: "sgt",
+			semantic.CompOpLE: "sle",
+			semantic.CompOpGE: "sge",
+		}
+		pred := preds[c.Op]
+		if t == semantic.PrimitiveTypeByte {
+			// Bytes are unsigned.
+			pred = strings.Replace(pred, "s", "u", 1)
+		}
+		return f.value("icmp %s %s %s, %s", pred, llvmPrimType(t), l, r)
+	case *semantic.BinCond:
+		// Short-circuit evaluation.
+		l := f.cond(c.Left)
+		lb := f.block
+		rhs, end := f.label(), f.label()
+		short := "false"
+		if c.Op == semantic.LogOpAnd {
+			f.term("br i1 %s, label %%%s, label %%%s", l, rhs, end)
+		} else {
+			short = "true"
+			f.term("br i1 %s, label %%%s, label %%%s", l, end, rhs)
+		}
+		f.startBlock(rhs)
+		r := f.cond(c.Right)
+		rb := f.block
+		f.startBlock(end)
+		return f.value("phi i1 [ %s, %%%s ], [ %s, %%%s ]", short, lb, r, rb)
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+}
+
+// llvmPrimType returns the LLVM type of a primitive type.
+func llvmPrimType(t semantic.PrimitiveType) string {
+	switch t {
+	case semantic.PrimitiveTypeInt:
+		return "i32"
+	case semantic.PrimitiveTypeByte:
+		return "i8"
+	case semantic.PrimitiveTypeBool:
+		return "i1"
+	default:
+		panic(fmt.Sprintf("invalid primitive type %d", t))
+	}
+}
+
+// llvmParType returns the LLVM type of a function parameter. Parameters passed by reference
+// (including all arrays) are passed as pointers (to the first element, for arrays).
+func llvmParType(t semantic.ParameterType) string {
+	switch dt := t.DType.(type) {
+	case semantic.PrimitiveType:
+		if t.IsRef {
+			return llvmPrimType(dt) + "*"
+		}
+		return llvmPrimType(dt)
+	case semantic.ArrayType:
+		return llvmPrimType(dt.PrimitiveType) + "*"
+	default:
+		panic(fmt.Sprintf("function parameter of invalid data type %T", dt))
+	}
+}
+
+// llvmRetType returns the LLVM return type for a function with return type t.
+func llvmRetType(t *semantic.PrimitiveType) string {
+	if t == nil {
+		return "void"
+	}
+	return llvmPrimType(*t)
+}
+
+// llvmFrameType returns the name of the frame type of f.
+func llvmFrameType(f *function) string {
+	return "%frame." + f.name
+}
+
+// llvmEscape escapes s for use in an LLVM string constant.
+func llvmEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package codegen
+
+// llvmRuntime is the implementation of the standard library in LLVM IR, on top of the C standard
+// library. Each function is named after the corresponding Alan one, prefixed with "rt.". The
+// standard streams of the C library are not referenced by name, as each C library declares them
+// differently: pushing back input is done here instead of with ungetc, and errors are written to
+// file descriptor 2 with dprintf (POSIX).
+const llvmRuntime = `declare i32 @printf(i8*, ...)
+declare i32 @getchar()
+declare i32 @putchar(i32)
+declare i64 @strlen(i8*)
+declare i32 @strcmp(i8*, i8*)
+declare i8* @strcpy(i8*, i8*)
+declare i8* @strcat(i8*, i8*)
+declare i32 @dprintf(i32, i8*, ...)
+declare i32 @fflush(i8*)
+declare void @exit(i32)
+
+; rt.peek is the character pushed back onto the input, or -2 if there is none.
+@rt.peek = internal global i32 -2
+
+@rt.fmt.int = private unnamed_addr constant [3 x i8] c"%d\00"
+@rt.fmt.str = private unnamed_addr constant [3 x i8] c"%s\00"
+@rt.fmt.bound = private unnamed_addr constant [65 x i8] c"runtime error: index %d out of bounds for array \22%s\22 of size %d\0A\00"
+
+define internal void @rt.writeInteger(i32 %n) {
+	%f = getelementptr inbounds [3 x i8], [3 x i8]* @rt.fmt.int, i32 0, i32 0
+	call i32 (i8*, ...) @printf(i8* %f, i32 %n)
+	ret void
+}
+
+define internal void @rt.writeByte(i8 %b) {
+	%n = zext i8 %b to i32
+	call void @rt.writeInteger(i32 %n)
+	ret void
+}
+
+define internal void @rt.writeChar(i8 %b) {
+	%c = zext i8 %b to i32
+	call i32 @putchar(i32 %c)
+	ret void
+}
+
+define internal void @rt.writeString(i8* %s) {
+	%f = getelementptr inbounds [3 x i8], [3 x i8]* @rt.fmt.str, i32 0, i32 0
+	call i32 (i8*, ...) @printf(i8* %f, i8* %s)
+	ret void
+}
+
+; getchar returns the character pushed back onto the input, if any, or else the next one read.
+define internal i32 @rt.getchar() {
+entry:
+	%p = load i32, i32* @rt.peek
+	%has.p = icmp ne i32 %p, -2
+	br i1 %has.p, label %peeked, label %read
+peeked:
+	store i32 -2, i32* @rt.peek
+	ret i32 %p
+read:
+	%c = call i32 @getchar()
+	ret i32 %c
+}
+
+; readInteger skips leading white space and reads an optionally signed decimal integer. A newline
+; right after the integer is consumed as well.
+define internal i32 @rt.readInteger() {
+entry:
+	br label %skip
+skip:
+	%c = call i32 @rt.getchar()
+	%is.sp = icmp eq i32 %c, 32
+	%ge9 = icmp sge i32 %c, 9
+	%le13 = icmp sle i32 %c, 13
+	%is.ctl = and i1 %ge9, %le13
+	%is.ws = or i1 %is.sp, %is.ctl
+	br i1 %is.ws, label %skip, label %sign
+sign:
+	%is.neg = icmp eq i32 %c, 45
+	%is.pos = icmp eq i32 %c, 43
+	%has.sign = or i1 %is.neg, %is.pos
+	br i1 %has.sign, label %signed, label %digits
+signed:
+	%c.next = call i32 @rt.getchar()
+	br label %digits
+digits:
+	%d0 = phi i32 [ %c, %sign ], [ %c.next, %signed ]
+	br label %loop
+loop:
+	%d = phi i32 [ %d0, %digits ], [ %d.next, %body ]
+	%acc = phi i32 [ 0, %digits ], [ %acc.next, %body ]
+	%ge0 = icmp sge i32 %d, 48
+	%le9 = icmp sle i32 %d, 57
+	%is.digit = and i1 %ge0, %le9
+	br i1 %is.digit, label %body, label %done
+body:
+	%acc10 = mul i32 %acc, 10
+	%dv = sub i32 %d, 48
+	%acc.next = add i32 %acc10, %dv
+	%d.next = call i32 @rt.getchar()
+	br label %loop
+done:
+	%is.nl = icmp eq i32 %d, 10
+	%is.eof = icmp eq i32 %d, -1
+	%keep = or i1 %is.nl, %is.eof
+	br i1 %keep, label %exit, label %unget
+unget:
+	store i32 %d, i32* @rt.peek
+	br label %exit
+exit:
+	%neg = sub i32 0, %acc
+	%r = select i1 %is.neg, i32 %neg, i32 %acc
+	ret i32 %r
+}
+
+define internal i8 @rt.readByte() {
+	%n = call i32 @rt.readInteger()
+	%b = trunc i32 %n to i8
+	ret i8 %b
+}
+
+define internal i8 @rt.readChar() {
+	%c = call i32 @rt.getchar()
+	%is.eof = icmp eq i32 %c, -1
+	%b = trunc i32 %c to i8
+	%r = select i1 %is.eof, i8 0, i8 %b
+	ret i8 %r
+}
+
+; readString reads at most n - 1 characters, up to the end of the line, into s. The newline is
+; consumed but not stored. s is always terminated with '\0'.
+define internal void @rt.readString(i32 %n, i8* %s) {
+entry:
+	%lim = sub i32 %n, 1
+	%empty = icmp slt i32 %lim, 0
+	br i1 %empty, label %exit, label %loop
+loop:
+	%i = phi i32 [ 0, %entry ], [ %i.next, %store ]
+	%room = icmp slt i32 %i, %lim
+	br i1 %room, label %read, label %done
+read:
+	%c = call i32 @rt.getchar()
+	%is.nl = icmp eq i32 %c, 10
+	%is.eof = icmp eq i32 %c, -1
+	%stop = or i1 %is.nl, %is.eof
+	br i1 %stop, label %done, label %store
+store:
+	%b = trunc i32 %c to i8
+	%p = getelementptr i8, i8* %s, i32 %i
+	store i8 %b, i8* %p
+	%i.next = add i32 %i, 1
+	br label %loop
+done:
+	%end = phi i32 [ %i, %loop ], [ %i, %read ]
+	%q = getelementptr i8, i8* %s, i32 %end
+	store i8 0, i8* %q
+	br label %exit
+exit:
+	ret void
+}
+
+define internal i32 @rt.extend(i8 %b) {
+	%n = zext i8 %b to i32
+	ret i32 %n
+}
+
+define internal i8 @rt.shrink(i32 %n) {
+	%b = trunc i32 %n to i8
+	ret i8 %b
+}
+
+; div and mod divide ints like sdiv and srem, except that x / -1 is -x (wrapping around) and x % -1
+; is 0, whatever x.
+define internal i32 @rt.div(i32 %x, i32 %y) {
+entry:
+	%is.m1 = icmp eq i32 %y, -1
+	br i1 %is.m1, label %neg, label %div
+neg:
+	%n = sub i32 0, %x
+	ret i32 %n
+div:
+	%q = sdiv i32 %x, %y
+	ret i32 %q
+}
+
+define internal i32 @rt.mod(i32 %x, i32 %y) {
+entry:
+	%is.m1 = icmp eq i32 %y, -1
+	br i1 %is.m1, label %zero, label %mod
+zero:
+	ret i32 0
+mod:
+	%r = srem i32 %x, %y
+	ret i32 %r
+}
+
+; bound reports index i out of the bounds of array name, of size n, and exits.
+define internal void @rt.bound(i8* %name, i32 %i, i32 %n) {
+	call i32 @fflush(i8* null)
+	%f = getelementptr inbounds [65 x i8], [65 x i8]* @rt.fmt.bound, i32 0, i32 0
+	call i32 (i32, i8*, ...) @dprintf(i32 2, i8* %f, i32 %i, i8* %name, i32 %n)
+	call void @exit(i32 1)
+	unreachable
+}
+
+define internal i32 @rt.strlen(i8* %s) {
+	%l = call i64 @strlen(i8* %s)
+	%n = trunc i64 %l to i32
+	ret i32 %n
+}
+
+define internal i32 @rt.strcmp(i8* %s1, i8* %s2) {
+	%r = call i32 @strcmp(i8* %s1, i8* %s2)
+	ret i32 %r
+}
+
+define internal void @rt.strcpy(i8* %trg, i8* %src) {
+	call i8* @strcpy(i8* %trg, i8* %src)
+	ret void
+}
+
+define internal void @rt.strcat(i8* %trg, i8* %src) {
+	call i8* @strcat(i8* %trg, i8* %src)
+	ret void
+}
+`
@@ -0,0 +1,100 @@
+package codegen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// runTests are example programs, along with their input and expected output.
+var runTests = []struct {
+	file string
+	// target is the name of the target to compile for (the default one if empty).
+	target string
+	input  string
+	output string
+}{
+	{
+		file:   "hello.alan",
+		output: "Hello world!\n",
+	},
+	{
+		file:  "hanoi.alan",
+		input: "2\n",
+		output: "Rings: Moving from left to middle.\n" +
+			"Moving from left to right.\n" +
+			"Moving from middle to right.\n",
+	},
+	{
+		file:   "primes.alan",
+		input:  "20\n",
+		output: "Limit: Primes:\n2\n3\n5\n7\n11\n13\n17\n19\n\nTotal: 8\n",
+	},
+	{
+		file: "bubblesort.alan",
+		output: "Initial array: 35, 67, 8, 6, 36, 6, 38, 80, 78, 7, 78, 9, 51, 49, 79, 49\n" +
+			"Sorted array: 6, 6, 7, 8, 9, 35, 36, 38, 49, 49, 51, 67, 78, 78, 79, 80\n",
+	},
+	{
+		file:   "reverse.alan",
+		output: "Hello world!\n",
+	},
+	{
+		file:  "wrap.alan",
+		input: "-2147483648\n70000\n",
+		output: "max + 1: 32768\ncube: 1000000000\nmin: -2147483648\nmin / -1: -2147483648\n" +
+			"min % -1: 0\n-min: -2147483648\n-7 / 2: -3\n-7 % 2: -1\nbyte: 193\nread: 70000\n",
+	},
+	{
+		file:   "uninit.alan",
+		output: "0\n0\n",
+	},
+	{
+		file:   "wrap.alan",
+		target: "int16",
+		input:  "-32768\n70000\n",
+		output: "max + 1: -32768\ncube: -13824\nmin: -32768\nmin / -1: -32768\nmin % -1: 0\n" +
+			"-min: -32768\n-7 / 2: -3\n-7 % 2: -1\nbyte: 193\nread: 4464\n",
+	},
+}
+
+func TestEmitLLVM(t *testing.T) {
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli not found")
+	}
+	for _, test := range runTests {
+		ast := alantest.ParseExample(t, test.file, semantic.Targets[test.target])
+		var ir bytes.Buffer
+		if err := EmitLLVM(&ir, ast); err != nil {
+			t.Errorf("EmitLLVM(%q) failed: %v", test.file, err)
+			continue
+		}
+		cmd := exec.Command(lli, writeTemp(t, "out.ll", ir.Bytes()))
+		cmd.Stdin = strings.NewReader(test.input)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("running %q failed: %v", test.file, err)
+			continue
+		}
+		if got := string(out); got != test.output {
+			t.Errorf("running %q = %q, want %q", test.file, got, test.output)
+		}
+	}
+}
+
+// writeTemp writes b to a new temporary file named name and returns its path.
+func writeTemp(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(f, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
@@ -0,0 +1,48 @@
+// Package alantest provides helpers for the tests of the compiler's packages.
+package alantest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/foxeng/alanc/parser"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// Parse parses and checks the Alan source src (named name in diagnostics), for target. Any error
+// fails the test.
+func Parse(t *testing.T, name string, src []byte, target semantic.Target) *semantic.Ast {
+	t.Helper()
+	l := parser.NewLexer(name, bytes.NewReader(src))
+	ast, err := parser.Parse(&l)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", name, err)
+	}
+	ast.Target = target
+	if err := semantic.Check(ast); err != nil {
+		t.Fatalf("Check(%q) failed: %v", name, err)
+	}
+	return ast
+}
+
+// Example returns the source of the example program in file (in the examples directory at the
+// root of the module). Any error fails the test.
+func Example(t *testing.T, file string) []byte {
+	t.Helper()
+	_, self, _, _ := runtime.Caller(0)
+	src, err := ioutil.ReadFile(filepath.Join(filepath.Dir(self), "..", "..", "examples", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// ParseExample parses and checks the example program in file (see Example), for target. Any error
+// fails the test.
+func ParseExample(t *testing.T, file string, target semantic.Target) *semantic.Ast {
+	t.Helper()
+	return Parse(t, file, Example(t, file), target)
+}
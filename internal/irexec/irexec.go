@@ -0,0 +1,359 @@
+// Package irexec implements an evaluator of the IR, for testing: the IR generation (package ir),
+// its optimization (package ssa) and the transformations done before it (e.g. package bounds) are
+// tested by running programs and comparing their output with the interpreter's.
+package irexec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Run is a straightforward evaluator of the IR, meant for testing the IR generation and the
+// transformations on it against the other backends (the interpreter in particular), not for speed.
+// Values are held as int32, as in the interpreter.
+
+// cell is the address of a value: element i of a.
+type cell struct {
+	a []int32
+	i int
+}
+
+func (c cell) get() int32 {
+	return c.a[c.i]
+}
+
+func (c cell) set(v int32) {
+	c.a[c.i] = v
+}
+
+// frame is an activation of a unit.
+type frame struct {
+	unit *ir.Unit
+	// link is the frame of the function the unit's is nested in (the static link).
+	link *frame
+	// vars are the function's variables, by declaration.
+	vars map[semantic.Node]cell
+	// temps are the values of the unit's temporaries.
+	temps []int32
+	// addrs are the addresses held by the unit's temporaries (those produced by OpArray).
+	addrs []cell
+	// result is where the function's result is stored.
+	result cell
+}
+
+// execError is an error occurring during execution.
+type execError struct {
+	msg string
+}
+
+func (e execError) Error() string {
+	return e.msg
+}
+
+// executor is the state of the evaluator.
+type executor struct {
+	units map[*semantic.FuncDef]*ir.Unit
+	// parents are the functions each function is nested in.
+	parents map[*semantic.FuncDef]*semantic.FuncDef
+	// owners are the functions declaring each variable.
+	owners map[semantic.Node]*semantic.FuncDef
+	in     *bufio.Reader
+	out    *bufio.Writer
+	// target is the target the program was generated for, whose arithmetic is followed.
+	target semantic.Target
+}
+
+// Run executes p, reading standard input from r and writing standard output to w, and returns any
+// error of the program at run time (e.g. an index out of bounds). It is not meant for running
+// programs otherwise (see package interp for that).
+func Run(p *ir.Program, r io.Reader, w io.Writer) (err error) {
+	e := &executor{
+		units:   map[*semantic.FuncDef]*ir.Unit{},
+		parents: map[*semantic.FuncDef]*semantic.FuncDef{},
+		owners:  map[semantic.Node]*semantic.FuncDef{},
+		in:      bufio.NewReader(r),
+		out:     bufio.NewWriter(w),
+		target:  p.Target,
+	}
+	for _, u := range p.Units {
+		e.units[u.Def] = u
+	}
+	main := p.Units[len(p.Units)-1]
+	e.scan(main.Def)
+
+	defer func() {
+		if ferr := e.out.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case execError:
+				err = r
+			default:
+				panic(r)
+			}
+		}
+	}()
+	e.invoke(main, nil, nil, cell{})
+	return nil
+}
+
+// scan records the parents of def and the functions nested in it, as well as the owners of their
+// variables.
+func (e *executor) scan(def *semantic.FuncDef) {
+	for i := range def.Parameters {
+		e.owners[&def.Parameters[i]] = def
+	}
+	for _, ld := range def.LDefs {
+		if fd, ok := ld.(*semantic.FuncDef); ok {
+			e.parents[fd] = def
+			e.scan(fd)
+		} else {
+			e.owners[ld] = def
+		}
+	}
+}
+
+// invoke executes unit u, with static link link, arguments args and result address result.
+func (e *executor) invoke(u *ir.Unit, link *frame, args []cell, result cell) {
+	fr := &frame{
+		unit:   u,
+		link:   link,
+		vars:   map[semantic.Node]cell{},
+		temps:  make([]int32, len(u.Temps)),
+		addrs:  make([]cell, len(u.Temps)),
+		result: result,
+	}
+	def := u.Def
+	for i := range def.Parameters {
+		fr.vars[&def.Parameters[i]] = args[i]
+	}
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.PrimVarDef:
+			fr.vars[ld] = cell{a: make([]int32, 1)}
+		case *semantic.ArrayDef:
+			fr.vars[ld] = cell{a: make([]int32, ld.Type.Size)}
+		}
+	}
+
+	var pars []cell
+	var ret cell
+	for pc := 0; ; pc++ {
+		q := u.Quads[pc]
+		switch q.Op {
+		case ir.OpUnit:
+		case ir.OpEndu, ir.OpRet:
+			return
+		case ir.OpAssign:
+			e.addr(fr, q.Z).set(e.val(fr, q.X))
+		case ir.OpArray:
+			a := e.addr(fr, q.X)
+			fr.addrs[q.Z.(ir.Temp)] = cell{a: a.a, i: a.i + int(e.val(fr, q.Y))}
+		case ir.OpBound:
+			if i, n := e.val(fr, q.X), e.val(fr, q.Y); i < 0 || i >= n {
+				panic(execError{msg: fmt.Sprintf("index %d out of bounds for array %q of size %d",
+					i, q.Z.(ir.Var).ID, n)})
+			}
+		case ir.OpPlus, ir.OpMinus, ir.OpMult, ir.OpDiv, ir.OpMod:
+			x, y := e.val(fr, q.X), e.val(fr, q.Y)
+			if y == 0 && (q.Op == ir.OpDiv || q.Op == ir.OpMod) {
+				panic(execError{msg: "division by zero"})
+			}
+			e.addr(fr, q.Z).set(e.target.Arith(semanticOps[q.Op], x, y, u.Temps[q.Z.(ir.Temp)]))
+		case ir.OpEQ, ir.OpNE, ir.OpLT, ir.OpGT, ir.OpLE, ir.OpGE:
+			if semantic.Compare(semanticCompOps[q.Op], e.val(fr, q.X), e.val(fr, q.Y)) {
+				pc = int(q.Z.(ir.Label)) - 1
+			}
+		case ir.OpJump:
+			pc = int(q.Z.(ir.Label)) - 1
+		case ir.OpPar:
+			switch q.Y.(ir.Mode) {
+			case ir.ModeV:
+				pars = append(pars, cell{a: []int32{e.val(fr, q.X)}})
+			case ir.ModeR:
+				pars = append(pars, e.addr(fr, q.X))
+			case ir.ModeRet:
+				ret = e.addr(fr, q.X)
+			}
+		case ir.OpCall:
+			callee := q.Z.(ir.Func).Def
+			if semantic.IsStdlib(callee) {
+				e.stdlib(callee.ID, pars, ret)
+			} else {
+				link := fr
+				for link != nil && link.unit.Def != e.parents[callee] {
+					link = link.link
+				}
+				e.invoke(e.units[callee], link, pars, ret)
+			}
+			pars, ret = nil, cell{}
+		default:
+			panic(execError{msg: fmt.Sprintf("invalid quadruple %v", q)})
+		}
+	}
+}
+
+// semanticOps are the arithmetic operators for each IR operator.
+var semanticOps = map[ir.Op]semantic.ArithOp{
+	ir.OpPlus:  semantic.ArithOpPlus,
+	ir.OpMinus: semantic.ArithOpMinus,
+	ir.OpMult:  semantic.ArithOpMult,
+	ir.OpDiv:   semantic.ArithOpDiv,
+	ir.OpMod:   semantic.ArithOpMod,
+}
+
+// semanticCompOps are the comparison operators for each IR comparison operator.
+var semanticCompOps = map[ir.Op]semantic.CompOp{
+	ir.OpEQ: semantic.CompOpEQ,
+	ir.OpNE: semantic.CompOpNE,
+	ir.OpLT: semantic.CompOpLT,
+	ir.OpGT: semantic.CompOpGT,
+	ir.OpLE: semantic.CompOpLE,
+	ir.OpGE: semantic.CompOpGE,
+}
+
+// addr returns the address of operand o in fr.
+func (e *executor) addr(fr *frame, o ir.Operand) cell {
+	switch o := o.(type) {
+	case ir.Var:
+		for fr.unit.Def != e.owners[o.Decl] {
+			fr = fr.link
+		}
+		return fr.vars[o.Decl]
+	case ir.Temp:
+		return cell{a: fr.temps, i: int(o)}
+	case ir.Deref:
+		return fr.addrs[o]
+	case ir.Result:
+		return fr.result
+	case ir.Str:
+		a := make([]int32, len(o)+1)
+		for i := 0; i < len(o); i++ {
+			a[i] = int32(o[i])
+		}
+		return cell{a: a}
+	default:
+		panic(execError{msg: fmt.Sprintf("operand %v has no address", o)})
+	}
+}
+
+// val returns the value of operand o in fr.
+func (e *executor) val(fr *frame, o ir.Operand) int32 {
+	if c, ok := o.(ir.Const); ok {
+		return c.Val
+	}
+	return e.addr(fr, o).get()
+}
+
+// str returns the string starting at c.
+func str(c cell) []int32 {
+	return c.a[c.i:]
+}
+
+// strlen returns the length of the ('\0' terminated) string s.
+func strlen(s []int32) int {
+	for i, c := range s {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// stdlib executes standard library function id with arguments args, storing its result (if any)
+// to ret.
+func (e *executor) stdlib(id semantic.ID, args []cell, ret cell) {
+	switch id {
+	case "writeInteger", "writeByte":
+		e.out.WriteString(strconv.Itoa(int(args[0].get())))
+	case "writeChar":
+		e.out.WriteByte(byte(args[0].get()))
+	case "writeString":
+		s := str(args[0])
+		for _, c := range s[:strlen(s)] {
+			e.out.WriteByte(byte(c))
+		}
+	case "readInteger":
+		ret.set(e.target.WrapInt(e.readInteger()))
+	case "readByte":
+		ret.set(int32(uint8(e.readInteger())))
+	case "readChar":
+		e.out.Flush()
+		c, err := e.in.ReadByte()
+		if err != nil {
+			c = 0
+		}
+		ret.set(int32(c))
+	case "readString":
+		e.out.Flush()
+		n, s := int(args[0].get()), str(args[1])
+		if n <= 0 {
+			return
+		}
+		i := 0
+		for ; i < n-1; i++ {
+			c, err := e.in.ReadByte()
+			if err != nil || c == '\n' {
+				break
+			}
+			s[i] = int32(c)
+		}
+		s[i] = 0
+	case "extend":
+		ret.set(args[0].get())
+	case "shrink":
+		ret.set(int32(uint8(args[0].get())))
+	case "strlen":
+		ret.set(int32(strlen(str(args[0]))))
+	case "strcmp":
+		s1, s2 := str(args[0]), str(args[1])
+		i := 0
+		for s1[i] == s2[i] && s1[i] != 0 {
+			i++
+		}
+		ret.set(s1[i] - s2[i])
+	case "strcpy":
+		trg, src := str(args[0]), str(args[1])
+		copy(trg, src[:strlen(src)+1])
+	case "strcat":
+		trg, src := str(args[0]), str(args[1])
+		copy(trg[strlen(trg):], src[:strlen(src)+1])
+	default:
+		panic(execError{msg: fmt.Sprintf("unknown standard library function %q", id)})
+	}
+}
+
+// readInteger skips leading white space and reads an optionally signed decimal integer. A newline
+// right after the integer is consumed as well.
+func (e *executor) readInteger() int32 {
+	e.out.Flush()
+	c, err := e.in.ReadByte()
+	for err == nil && (c == ' ' || c >= '\t' && c <= '\r') {
+		c, err = e.in.ReadByte()
+	}
+	neg := false
+	if err == nil && (c == '-' || c == '+') {
+		neg = c == '-'
+		c, err = e.in.ReadByte()
+	}
+	var n int32
+	for err == nil && c >= '0' && c <= '9' {
+		n = 10*n + int32(c-'0')
+		c, err = e.in.ReadByte()
+	}
+	if err == nil && c != '\n' {
+		e.in.UnreadByte()
+	}
+	if neg {
+		n = -n
+	}
+	return n
+}
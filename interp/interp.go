@@ -0,0 +1,414 @@
+// Package interp implements a tree-walking interpreter for Alan.
+package interp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Names have been resolved by the checker (each variable reference and function call links to
+// its declaration), so before execution starts the interpreter only needs to lay out the frame of
+// each function, assigning a slot to each of its variables. At run time, each function
+// activation gets a frame holding its parameters and local variables, plus a static link to the
+// frame of the function it's nested in, which nested functions follow to reach the variables of
+// enclosing ones. All primitive values are held as int32 (bytes are kept in [0, 255]).
+
+// function is a function to be executed (or a standard library function, if def is nil).
+type function struct {
+	// def is the function's definition (nil for standard library functions).
+	def *semantic.FuncDef
+	// lib is the implementation of a standard library function (nil otherwise).
+	lib libFunc
+	// typ is the function's type.
+	typ semantic.FunctionType
+	// parent is the function this is nested in (nil for main and the standard library).
+	parent *function
+	// slots are the data types of the slots of the function's frame (parameters first, then
+	// local variables).
+	slots []semantic.DType
+}
+
+// variable is a parameter or a local variable.
+type variable struct {
+	// fn is the function the variable belongs to.
+	fn *function
+	// slot is the index of the variable's slot in fn's frames.
+	slot int
+	// typ is the variable's data type.
+	typ semantic.DType
+}
+
+// slot is the storage of a single variable in a frame.
+type slot struct {
+	// p points to the value of primitive variables.
+	p *int32
+	// a holds the elements of arrays.
+	a []int32
+}
+
+// frame is a function activation record.
+type frame struct {
+	// fn is the function activated.
+	fn *function
+	// link is the frame of the function fn is nested in (the static link).
+	link *frame
+	// slots are the function's parameters and local variables.
+	slots []slot
+	// ret is the return value.
+	ret int32
+}
+
+// up returns the frame of owner, which must be the function of fr or one of the functions
+// enclosing it, by following static links.
+func (fr *frame) up(owner *function) *frame {
+	for fr.fn != owner {
+		fr = fr.link
+	}
+	return fr
+}
+
+// runtimeError is an error occurring during execution, due to the program (e.g. an index out of
+// bounds). The interpreter panics with it and Run recovers it; any other panic is a bug in the
+// interpreter itself.
+type runtimeError struct {
+	msg string
+}
+
+func (e runtimeError) Error() string {
+	return e.msg
+}
+
+// interp is the interpreter state.
+type interp struct {
+	in  *bufio.Reader
+	out *bufio.Writer
+	// target is the target the program was checked for, whose arithmetic is followed.
+	target semantic.Target
+	// vars are the variables declared by each *semantic.ParDef, *semantic.PrimVarDef and
+	// *semantic.ArrayDef.
+	vars map[semantic.Node]*variable
+	// funcs are the functions defined by each function definition (including the standard library
+	// ones, once called).
+	funcs map[*semantic.FuncDef]*function
+}
+
+// Run executes ast, reading standard input from r and writing standard output to w. ast must have
+// passed the semantic checks. Errors of the program at run time (e.g. an index out of bounds) are
+// returned.
+func Run(ast *semantic.Ast, r io.Reader, w io.Writer) (err error) {
+	in := &interp{
+		in:     bufio.NewReader(r),
+		out:    bufio.NewWriter(w),
+		target: ast.Target,
+		vars:   map[semantic.Node]*variable{},
+		funcs:  map[*semantic.FuncDef]*function{},
+	}
+	main := in.funcDef(ast.Program, nil)
+
+	defer func() {
+		if ferr := in.out.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(runtimeError)
+			if !ok {
+				panic(r)
+			}
+			err = rerr
+		}
+	}()
+	in.invoke(main, nil, nil)
+	return nil
+}
+
+// funcDef lays out the frame of the function defined by def (and of any functions nested in it).
+// parent is the function def is nested in (nil for main).
+func (in *interp) funcDef(def *semantic.FuncDef, parent *function) *function {
+	f := &function{
+		def:    def,
+		parent: parent,
+		typ: semantic.FunctionType{
+			Parameters: make([]semantic.ParameterType, len(def.Parameters)),
+			Return:     def.RType,
+		},
+	}
+	in.funcs[def] = f
+
+	for i := range def.Parameters {
+		p := &def.Parameters[i]
+		f.typ.Parameters[i] = p.Type
+		in.declare(p, &variable{
+			fn:   f,
+			slot: len(f.slots),
+			typ:  p.Type.DType,
+		})
+		f.slots = append(f.slots, p.Type.DType)
+	}
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.FuncDef:
+			in.funcDef(ld, f)
+		case *semantic.PrimVarDef:
+			in.declare(ld, &variable{
+				fn:   f,
+				slot: len(f.slots),
+				typ:  ld.Type,
+			})
+			f.slots = append(f.slots, ld.Type)
+		case *semantic.ArrayDef:
+			in.declare(ld, &variable{
+				fn:   f,
+				slot: len(f.slots),
+				typ:  ld.Type,
+			})
+			f.slots = append(f.slots, ld.Type)
+		default:
+			panic(fmt.Sprintf("local definition of invalid type %T", ld))
+		}
+	}
+
+	return f
+}
+
+// declare records v as the variable declared by decl.
+func (in *interp) declare(decl semantic.Node, v *variable) {
+	in.vars[decl] = v
+}
+
+// function returns the function defined by def.
+func (in *interp) function(def *semantic.FuncDef) *function {
+	if f, ok := in.funcs[def]; ok {
+		return f
+	}
+	if !semantic.IsStdlib(def) {
+		// NOTE: This should never happen, the AST has been checked.
+		panic(fmt.Sprintf("%q not defined", def.ID))
+	}
+	ft, _ := semantic.Stdlib(def.ID)
+	f := &function{
+		lib: stdlib[def.ID],
+		typ: ft,
+	}
+	in.funcs[def] = f
+	return f
+}
+
+// invoke activates f with the provided arguments and returns its return value (0 for procedures).
+// link is the frame of the function f is nested in.
+func (in *interp) invoke(f *function, args []slot, link *frame) int32 {
+	if f.lib != nil {
+		return f.lib(in, args)
+	}
+	fr := &frame{
+		fn:    f,
+		link:  link,
+		slots: make([]slot, len(f.slots)),
+	}
+	copy(fr.slots, args)
+	for i := len(args); i < len(f.slots); i++ {
+		switch t := f.slots[i].(type) {
+		case semantic.PrimitiveType:
+			fr.slots[i].p = new(int32)
+		case semantic.ArrayType:
+			fr.slots[i].a = make([]int32, t.Size)
+		default:
+			panic(fmt.Sprintf("local variable of invalid data type %T", t))
+		}
+	}
+	in.compStmt(&f.def.CompStmt, fr)
+	return fr.ret
+}
+
+// call executes a function call in fr and returns its result (0 for procedures).
+func (in *interp) call(c *semantic.FuncCall, fr *frame) int32 {
+	f := in.function(c.Decl)
+	args := make([]slot, len(c.Args))
+	for i, a := range c.Args {
+		pt := f.typ.Parameters[i]
+		if _, ok := pt.DType.(semantic.ArrayType); ok {
+			args[i].a = in.array(a, fr)
+		} else if pt.IsRef {
+			args[i].p = in.addr(a.(semantic.LVal), fr)
+		} else {
+			v := in.expr(a, fr)
+			args[i].p = &v
+		}
+	}
+	var link *frame
+	if f.parent != nil {
+		link = fr.up(f.parent)
+	}
+	return in.invoke(f, args, link)
+}
+
+// stmt executes a statement in fr and reports whether it returned.
+func (in *interp) stmt(s semantic.Stmt, fr *frame) bool {
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		return in.compStmt(s, fr)
+	case *semantic.AssignStmt:
+		p := in.addr(s.Left, fr)
+		*p = in.expr(s.Right, fr)
+	case *semantic.FuncCallStmt:
+		in.call(&s.FuncCall, fr)
+	case *semantic.IfStmt:
+		if in.cond(s.Cond, fr) {
+			return in.stmt(s.Stmt, fr)
+		}
+	case *semantic.IfElseStmt:
+		if in.cond(s.Cond, fr) {
+			return in.stmt(s.Stmt1, fr)
+		}
+		return in.stmt(s.Stmt2, fr)
+	case *semantic.WhileStmt:
+		for in.cond(s.Cond, fr) {
+			if in.stmt(s.Stmt, fr) {
+				return true
+			}
+		}
+	case *semantic.ReturnStmt:
+		if s.Expr != nil {
+			fr.ret = in.expr(s.Expr, fr)
+		}
+		return true
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+	return false
+}
+
+// compStmt executes a compound statement in fr and reports whether it returned.
+func (in *interp) compStmt(s *semantic.CompStmt, fr *frame) bool {
+	for _, s := range s.Stmts {
+		if in.stmt(s, fr) {
+			return true
+		}
+	}
+	return false
+}
+
+// varSlot returns the slot of the variable lv refers to, as seen from fr.
+func (in *interp) varSlot(lv semantic.LVal, fr *frame) *slot {
+	var v *variable
+	switch lv := lv.(type) {
+	case *semantic.VarRef:
+		v = in.vars[lv.Decl]
+	case *semantic.ArrayElem:
+		v = in.vars[lv.Decl]
+	default:
+		panic(fmt.Sprintf("variable l-value of invalid type %T", lv))
+	}
+	return &fr.up(v.fn).slots[v.slot]
+}
+
+// addr returns a pointer to the value of the (primitive) l-value lv.
+func (in *interp) addr(lv semantic.LVal, fr *frame) *int32 {
+	switch lv := lv.(type) {
+	case *semantic.VarRef:
+		return in.varSlot(lv, fr).p
+	case *semantic.ArrayElem:
+		a := in.varSlot(lv, fr).a
+		i := in.expr(lv.Index, fr)
+		// NOTE: Indices are always checked (whether lv has a bound or not), against the actual
+		// size of the array.
+		if i < 0 || int(i) >= len(a) {
+			panic(runtimeError{msg: fmt.Sprintf("index %d out of bounds for array %q of size %d", i,
+				lv.ID, len(a))})
+		}
+		return &a[i]
+	default:
+		panic(fmt.Sprintf("l-value of invalid type %T", lv))
+	}
+}
+
+// array returns the elements of the array expression e.
+func (in *interp) array(e semantic.Expr, fr *frame) []int32 {
+	switch e := e.(type) {
+	case *semantic.VarRef:
+		return in.varSlot(e, fr).a
+	case *semantic.StrLitExpr:
+		a := make([]int32, len(e.Val)+1)
+		for i := 0; i < len(e.Val); i++ {
+			a[i] = int32(e.Val[i])
+		}
+		return a
+	default:
+		panic(fmt.Sprintf("array expression of invalid type %T", e))
+	}
+}
+
+// expr evaluates a (primitive) expression in fr.
+func (in *interp) expr(e semantic.Expr, fr *frame) int32 {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr:
+		return in.target.WrapInt(int32(e.Val))
+	case *semantic.CharConstExpr:
+		return int32(byte(e.Val))
+	case *semantic.VarRef, *semantic.ArrayElem:
+		return *in.addr(e.(semantic.LVal), fr)
+	case *semantic.FuncCallExpr:
+		return in.call(&e.FuncCall, fr)
+	case *semantic.UnArithExpr:
+		v := in.expr(e.Expr, fr)
+		if e.Sign == semantic.SignMinus {
+			v = in.target.WrapInt(-v)
+		}
+		return v
+	case *semantic.BinArithExpr:
+		return in.binArith(e, fr)
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// binArith evaluates a binary arithmetic expression in fr.
+func (in *interp) binArith(e *semantic.BinArithExpr, fr *frame) int32 {
+	l := in.expr(e.Left, fr)
+	r := in.expr(e.Right, fr)
+	if r == 0 && (e.Op == semantic.ArithOpDiv || e.Op == semantic.ArithOpMod) {
+		panic(runtimeError{msg: "division by zero"})
+	}
+	return in.target.Arith(e.Op, l, r, e.DataType().(semantic.PrimitiveType))
+}
+
+// cond evaluates a condition in fr.
+func (in *interp) cond(c semantic.Cond, fr *frame) bool {
+	switch c := c.(type) {
+	case *semantic.ConstCond:
+		return c.Val
+	case *semantic.UnCond:
+		return !in.cond(c.Cond, fr)
+	case *semantic.CompCond:
+		l := in.expr(c.Left, fr)
+		r := in.expr(c.Right, fr)
+		switch c.Op {
+		case semantic.CompOpEQ:
+			return l == r
+		case semantic.CompOpNE:
+			return l != r
+		case semantic.CompOpLT:
+			return l < r
+		case semantic.CompOpGT:
+			return l > r
+		case semantic.CompOpLE:
+			return l <= r
+		case semantic.CompOpGE:
+			return l >= r
+		default:
+			panic(fmt.Sprintf("invalid comparison operator %q", c.Op))
+		}
+	case *semantic.BinCond:
+		// Short-circuit evaluation.
+		if c.Op == semantic.LogOpAnd {
+			return in.cond(c.Left, fr) && in.cond(c.Right, fr)
+		}
+		return in.cond(c.Left, fr) || in.cond(c.Right, fr)
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+}
@@ -0,0 +1,101 @@
+package interp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+var runTests = []struct {
+	file string
+	// target is the name of the target to compile for (the default one if empty).
+	target string
+	input  string
+	output string
+}{
+	{
+		file:   "hello.alan",
+		output: "Hello world!\n",
+	},
+	{
+		file:  "hanoi.alan",
+		input: "2\n",
+		output: "Rings: Moving from left to middle.\n" +
+			"Moving from left to right.\n" +
+			"Moving from middle to right.\n",
+	},
+	{
+		file:   "primes.alan",
+		input:  "20\n",
+		output: "Limit: Primes:\n2\n3\n5\n7\n11\n13\n17\n19\n\nTotal: 8\n",
+	},
+	{
+		file: "bubblesort.alan",
+		output: "Initial array: 35, 67, 8, 6, 36, 6, 38, 80, 78, 7, 78, 9, 51, 49, 79, 49\n" +
+			"Sorted array: 6, 6, 7, 8, 9, 35, 36, 38, 49, 49, 51, 67, 78, 78, 79, 80\n",
+	},
+	{
+		file:   "reverse.alan",
+		output: "Hello world!\n",
+	},
+	{
+		file:  "wrap.alan",
+		input: "-2147483648\n70000\n",
+		output: "max + 1: 32768\ncube: 1000000000\nmin: -2147483648\nmin / -1: -2147483648\n" +
+			"min % -1: 0\n-min: -2147483648\n-7 / 2: -3\n-7 % 2: -1\nbyte: 193\nread: 70000\n",
+	},
+	{
+		file:   "wrap.alan",
+		target: "int16",
+		input:  "-32768\n70000\n",
+		output: "max + 1: -32768\ncube: -13824\nmin: -32768\nmin / -1: -32768\nmin % -1: 0\n" +
+			"-min: -32768\n-7 / 2: -3\n-7 % 2: -1\nbyte: 193\nread: 4464\n",
+	},
+}
+
+func TestRun(t *testing.T) {
+	for _, test := range runTests {
+		ast := alantest.ParseExample(t, test.file, semantic.Targets[test.target])
+		var out strings.Builder
+		if err := Run(ast, strings.NewReader(test.input), &out); err != nil {
+			t.Errorf("Run(%q) failed: %v", test.file, err)
+			continue
+		}
+		if got := out.String(); got != test.output {
+			t.Errorf("Run(%q) output = %q, want %q", test.file, got, test.output)
+		}
+	}
+}
+
+func TestRunDivisionByZero(t *testing.T) {
+	ast := alantest.ParseExample(t, "prog8.alan", semantic.Target{})
+	var out strings.Builder
+	err := Run(ast, strings.NewReader(""), &out)
+	if err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("Run(%q) = %v, want division by zero error", "prog8.alan", err)
+	}
+}
+
+func TestRunLibraryBounds(t *testing.T) {
+	tests := []struct {
+		body  string
+		input string
+	}{
+		{`readString(10, s);`, "too long for s\n"},
+		{`strcpy(s, "too long for s");`, ""},
+		{`strcpy(s, "abc"); strcat(s, "def");`, ""},
+		{`t[0] = 'a'; t[1] = 'b'; x = strcmp(t, t);`, ""},
+	}
+	for _, test := range tests {
+		src := "main() : proc\n\ts : byte [4];\n\tt : byte [2];\n\tx : int;\n{\n\t" +
+			test.body + "\n}\n"
+		ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+		var out strings.Builder
+		err := Run(ast, strings.NewReader(test.input), &out)
+		if err == nil || !strings.Contains(err.Error(), "out of bounds") {
+			t.Errorf("Run(%q) = %v, want out of bounds error", test.body, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// libFunc is the implementation of a standard library function. It receives the call's arguments
+// and returns its result (0 for procedures).
+type libFunc func(in *interp, args []slot) int32
+
+// stdlib are the implementations of the standard library functions.
+var stdlib = map[semantic.ID]libFunc{
+	"writeInteger": func(in *interp, args []slot) int32 {
+		in.out.WriteString(strconv.Itoa(int(*args[0].p)))
+		return 0
+	},
+	"writeByte": func(in *interp, args []slot) int32 {
+		in.out.WriteString(strconv.Itoa(int(*args[0].p)))
+		return 0
+	},
+	"writeChar": func(in *interp, args []slot) int32 {
+		in.out.WriteByte(byte(*args[0].p))
+		return 0
+	},
+	"writeString": func(in *interp, args []slot) int32 {
+		for _, c := range args[0].a {
+			if c == 0 {
+				break
+			}
+			in.out.WriteByte(byte(c))
+		}
+		return 0
+	},
+	"readInteger": func(in *interp, _ []slot) int32 {
+		return in.target.WrapInt(in.readInteger())
+	},
+	"readByte": func(in *interp, _ []slot) int32 {
+		return int32(byte(in.readInteger()))
+	},
+	"readChar": func(in *interp, _ []slot) int32 {
+		in.out.Flush()
+		c, err := in.in.ReadByte()
+		if err != nil {
+			return 0
+		}
+		return int32(c)
+	},
+	"readString": func(in *interp, args []slot) int32 {
+		in.out.Flush()
+		n, s := int(*args[0].p), args[1].a
+		if n <= 0 {
+			return 0
+		}
+		i := 0
+		for ; i < n-1; i++ {
+			c, err := in.in.ReadByte()
+			if err != nil || c == '\n' {
+				break
+			}
+			*at(s, i, "readString") = int32(c)
+		}
+		*at(s, i, "readString") = 0
+		return 0
+	},
+	"extend": func(_ *interp, args []slot) int32 {
+		return *args[0].p
+	},
+	"shrink": func(_ *interp, args []slot) int32 {
+		return int32(byte(*args[0].p))
+	},
+	"strlen": func(_ *interp, args []slot) int32 {
+		return int32(strlen(args[0].a))
+	},
+	"strcmp": func(_ *interp, args []slot) int32 {
+		s1, s2 := args[0].a, args[1].a
+		for i := 0; ; i++ {
+			c1, c2 := *at(s1, i, "strcmp"), *at(s2, i, "strcmp")
+			if c1 != c2 || c1 == 0 {
+				return c1 - c2
+			}
+		}
+	},
+	"strcpy": func(_ *interp, args []slot) int32 {
+		trg, src := args[0].a, args[1].a
+		n := strlen(src)
+		at(src, n, "strcpy")
+		at(trg, n, "strcpy")
+		copy(trg, src[:n+1])
+		return 0
+	},
+	"strcat": func(_ *interp, args []slot) int32 {
+		trg, src := args[0].a, args[1].a
+		m, n := strlen(trg), strlen(src)
+		at(src, n, "strcat")
+		at(trg, m+n, "strcat")
+		copy(trg[m:], src[:n+1])
+		return 0
+	},
+}
+
+// at returns a pointer to element i of array s, an argument of standard library function fn. If i
+// is out of bounds (e.g. a string is not terminated, or doesn't fit), it reports a runtime error.
+func at(s []int32, i int, fn string) *int32 {
+	if i >= len(s) {
+		panic(runtimeError{msg: fmt.Sprintf("%s: index %d out of bounds for array of size %d", fn,
+			i, len(s))})
+	}
+	return &s[i]
+}
+
+// strlen returns the length of the ('\0' terminated) string s.
+func strlen(s []int32) int {
+	for i, c := range s {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// readInteger skips leading white space and reads an optionally signed decimal integer. A newline
+// right after the integer is consumed as well.
+func (in *interp) readInteger() int32 {
+	in.out.Flush()
+	c, err := in.in.ReadByte()
+	for err == nil && (c == ' ' || c >= '\t' && c <= '\r') {
+		c, err = in.in.ReadByte()
+	}
+	neg := false
+	if err == nil && (c == '-' || c == '+') {
+		neg = c == '-'
+		c, err = in.in.ReadByte()
+	}
+	var n int32
+	for err == nil && c >= '0' && c <= '9' {
+		n = 10*n + int32(c-'0')
+		c, err = in.in.ReadByte()
+	}
+	if err == nil && c != '\n' {
+		in.in.UnreadByte()
+	} else if err != nil && err != io.EOF {
+		panic(runtimeError{msg: err.Error()})
+	}
+	if neg {
+		n = -n
+	}
+	return n
+}
@@ -0,0 +1,294 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Conditions are translated to jumps, whose targets are filled in (backpatched) once known:
+// each condition yields the lists of its jumps taken when it's true and false, and each statement
+// the list of its jumps to the statement following it.
+
+// generator is the IR generator for a whole program.
+type generator struct {
+	prog *Program
+}
+
+// Generate generates the IR of ast, which must have passed the semantic checks.
+func Generate(ast *semantic.Ast) *Program {
+	g := &generator{prog: &Program{Target: ast.Target}}
+	g.funcDef(ast.Program)
+	return g.prog
+}
+
+// funcDef generates the unit of the function defined by def, after those of any functions nested
+// in it.
+func (g *generator) funcDef(def *semantic.FuncDef) {
+	for _, ld := range def.LDefs {
+		if fd, ok := ld.(*semantic.FuncDef); ok {
+			g.funcDef(fd)
+		}
+	}
+	u := &unitGen{Unit: &Unit{Def: def}, target: g.prog.Target}
+	u.emit(OpUnit, Func{def}, nil, nil)
+	next := u.compStmt(&def.CompStmt)
+	u.backpatch(next, u.nextQuad())
+	u.emit(OpEndu, Func{def}, nil, nil)
+	g.prog.Units = append(g.prog.Units, u.Unit)
+}
+
+// unitGen is the state of the generator for a single unit.
+type unitGen struct {
+	*Unit
+	target semantic.Target
+}
+
+// nextQuad returns the label of the next quadruple to be emitted.
+func (u *unitGen) nextQuad() Label {
+	return Label(len(u.Quads))
+}
+
+// emit emits a quadruple and returns its label.
+func (u *unitGen) emit(op Op, x, y, z Operand) Label {
+	l := u.nextQuad()
+	u.Quads = append(u.Quads, Quad{Op: op, X: x, Y: y, Z: z})
+	return l
+}
+
+// temp returns a new temporary of type t.
+func (u *unitGen) temp(t semantic.PrimitiveType) Temp {
+	u.Temps = append(u.Temps, t)
+	return Temp(len(u.Temps) - 1)
+}
+
+// backpatch sets the target of the jumps in list to target.
+func (u *unitGen) backpatch(list []Label, target Label) {
+	for _, l := range list {
+		u.Quads[l].Z = target
+	}
+}
+
+// stmt generates the quadruples of s and returns its jumps to the statement following it.
+func (u *unitGen) stmt(s semantic.Stmt) []Label {
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		return u.compStmt(s)
+	case *semantic.AssignStmt:
+		// NOTE: The right hand side is evaluated first, as in the other backends.
+		var later []semantic.Expr
+		if ae, ok := s.Left.(*semantic.ArrayElem); ok {
+			later = append(later, ae.Index)
+		}
+		r := u.value(s.Right, later...)
+		u.emit(OpAssign, r, nil, u.lval(s.Left))
+		return nil
+	case *semantic.FuncCallStmt:
+		u.call(&s.FuncCall)
+		return nil
+	case *semantic.IfStmt:
+		t, f := u.cond(s.Cond)
+		u.backpatch(t, u.nextQuad())
+		return append(f, u.stmt(s.Stmt)...)
+	case *semantic.IfElseStmt:
+		t, f := u.cond(s.Cond)
+		u.backpatch(t, u.nextQuad())
+		next := u.stmt(s.Stmt1)
+		next = append(next, u.emit(OpJump, nil, nil, nil))
+		u.backpatch(f, u.nextQuad())
+		return append(next, u.stmt(s.Stmt2)...)
+	case *semantic.WhileStmt:
+		head := u.nextQuad()
+		t, f := u.cond(s.Cond)
+		u.backpatch(t, u.nextQuad())
+		u.backpatch(u.stmt(s.Stmt), head)
+		u.emit(OpJump, nil, nil, head)
+		return f
+	case *semantic.ReturnStmt:
+		if s.Expr != nil {
+			u.emit(OpAssign, u.expr(s.Expr), nil, Result{})
+		}
+		u.emit(OpRet, nil, nil, nil)
+		return nil
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// compStmt generates the quadruples of s and returns its jumps to the statement following it.
+func (u *unitGen) compStmt(s *semantic.CompStmt) []Label {
+	var next []Label
+	for _, s := range s.Stmts {
+		u.backpatch(next, u.nextQuad())
+		next = u.stmt(s)
+	}
+	return next
+}
+
+// lval generates the quadruples computing the l-value lv and returns it as an operand.
+func (u *unitGen) lval(lv semantic.LVal) Operand {
+	switch lv := lv.(type) {
+	case *semantic.VarRef:
+		return Var{Decl: lv.Decl, ID: lv.ID}
+	case *semantic.ArrayElem:
+		i := u.expr(lv.Index)
+		if lv.Bound != nil {
+			u.emit(OpBound, i, u.expr(lv.Bound), Var{Decl: lv.Decl, ID: lv.ID})
+		}
+		t := u.temp(lv.DataType().(semantic.PrimitiveType))
+		u.emit(OpArray, Var{Decl: lv.Decl, ID: lv.ID}, i, t)
+		return Deref(t)
+	case *semantic.StrLitExpr:
+		return Str(lv.Val)
+	default:
+		panic(fmt.Sprintf("l-value of invalid type %T", lv))
+	}
+}
+
+// call generates the quadruples of function call c and returns the temporary holding its result
+// (nil for procedures).
+func (u *unitGen) call(c *semantic.FuncCall) Operand {
+	// Evaluate all arguments before passing any, so that nested calls don't interleave.
+	args := make([]Operand, len(c.Args))
+	modes := make([]Mode, len(c.Args))
+	for i, a := range c.Args {
+		pt := c.Decl.Parameters[i].Type
+		_, isArray := pt.DType.(semantic.ArrayType)
+		if isArray || pt.IsRef {
+			args[i], modes[i] = u.lval(a.(semantic.LVal)), ModeR
+		} else {
+			args[i], modes[i] = u.value(a, c.Args[i+1:]...), ModeV
+		}
+	}
+	for i, a := range args {
+		u.emit(OpPar, a, modes[i], nil)
+	}
+	var r Operand
+	if c.Decl.RType != nil {
+		t := u.temp(*c.Decl.RType)
+		u.emit(OpPar, t, ModeRet, nil)
+		r = t
+	}
+	u.emit(OpCall, nil, nil, Func{c.Decl})
+	return r
+}
+
+// arithOps are the operators for each arithmetic operator.
+var arithOps = map[semantic.ArithOp]Op{
+	semantic.ArithOpPlus:  OpPlus,
+	semantic.ArithOpMinus: OpMinus,
+	semantic.ArithOpMult:  OpMult,
+	semantic.ArithOpDiv:   OpDiv,
+	semantic.ArithOpMod:   OpMod,
+}
+
+// expr generates the quadruples computing the (primitive) expression e and returns its value as an
+// operand.
+func (u *unitGen) expr(e semantic.Expr) Operand {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr:
+		return Const{Val: u.target.WrapInt(int32(e.Val)), Type: semantic.PrimitiveTypeInt}
+	case *semantic.CharConstExpr:
+		return Const{Val: int32(uint8(e.Val)), Type: semantic.PrimitiveTypeByte}
+	case *semantic.VarRef, *semantic.ArrayElem:
+		return u.lval(e.(semantic.LVal))
+	case *semantic.FuncCallExpr:
+		return u.call(&e.FuncCall)
+	case *semantic.UnArithExpr:
+		v := u.expr(e.Expr)
+		if e.Sign == semantic.SignPlus {
+			return v
+		}
+		t := u.temp(e.DataType().(semantic.PrimitiveType))
+		u.emit(OpMinus, Const{Type: e.DataType().(semantic.PrimitiveType)}, v, t)
+		return t
+	case *semantic.BinArithExpr:
+		l := u.value(e.Left, e.Right)
+		r := u.expr(e.Right)
+		t := u.temp(e.DataType().(semantic.PrimitiveType))
+		u.emit(arithOps[e.Op], l, r, t)
+		return t
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// value generates the quadruples computing the (primitive) expression e, which is evaluated before
+// the expressions in later, and returns its value as an operand. If e is a variable and any of
+// later calls a function (which could modify it), its value is copied to a temporary.
+func (u *unitGen) value(e semantic.Expr, later ...semantic.Expr) Operand {
+	v := u.expr(e)
+	switch v.(type) {
+	case Var, Deref:
+	default:
+		return v
+	}
+	for _, l := range later {
+		if hasCall(l) {
+			t := u.temp(e.DataType().(semantic.PrimitiveType))
+			u.emit(OpAssign, v, nil, t)
+			return t
+		}
+	}
+	return v
+}
+
+// hasCall returns whether expression e contains a function call.
+func hasCall(e semantic.Expr) bool {
+	switch e := e.(type) {
+	case *semantic.FuncCallExpr:
+		return true
+	case *semantic.ArrayElem:
+		return hasCall(e.Index)
+	case *semantic.UnArithExpr:
+		return hasCall(e.Expr)
+	case *semantic.BinArithExpr:
+		return hasCall(e.Left) || hasCall(e.Right)
+	default:
+		return false
+	}
+}
+
+// compOps are the operators for each comparison operator.
+var compOps = map[semantic.CompOp]Op{
+	semantic.CompOpEQ: OpEQ,
+	semantic.CompOpNE: OpNE,
+	semantic.CompOpLT: OpLT,
+	semantic.CompOpGT: OpGT,
+	semantic.CompOpLE: OpLE,
+	semantic.CompOpGE: OpGE,
+}
+
+// cond generates the quadruples of condition c and returns its jumps taken when it's true and
+// false respectively. Logical operators short-circuit.
+func (u *unitGen) cond(c semantic.Cond) (t, f []Label) {
+	switch c := c.(type) {
+	case *semantic.ConstCond:
+		j := []Label{u.emit(OpJump, nil, nil, nil)}
+		if c.Val {
+			return j, nil
+		}
+		return nil, j
+	case *semantic.UnCond:
+		t, f := u.cond(c.Cond)
+		return f, t
+	case *semantic.CompCond:
+		l := u.value(c.Left, c.Right)
+		r := u.expr(c.Right)
+		t := []Label{u.emit(compOps[c.Op], l, r, nil)}
+		f := []Label{u.emit(OpJump, nil, nil, nil)}
+		return t, f
+	case *semantic.BinCond:
+		t1, f1 := u.cond(c.Left)
+		if c.Op == semantic.LogOpAnd {
+			u.backpatch(t1, u.nextQuad())
+			t2, f2 := u.cond(c.Right)
+			return t2, append(f1, f2...)
+		}
+		u.backpatch(f1, u.nextQuad())
+		t2, f2 := u.cond(c.Right)
+		return append(t1, t2...), f2
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+}
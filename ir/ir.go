@@ -0,0 +1,197 @@
+// Package ir implements the intermediate representation of Alan programs: quadruples (three-address
+// code), grouped in units, one per function.
+package ir
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// Op is the operator of a quadruple.
+type Op string
+
+const (
+	// OpUnit marks the beginning of unit x.
+	OpUnit Op = "unit"
+	// OpEndu marks the end of unit x.
+	OpEndu Op = "endu"
+	// OpAssign assigns x to z.
+	OpAssign Op = ":="
+	// OpArray stores the address of element y of array x to z.
+	OpArray Op = "array"
+	// OpBound fails unless 0 <= x < y, x being an index into array z, of size y.
+	OpBound Op = "bound"
+	// OpPlus stores x + y to z (OpMinus, OpMult, OpDiv and OpMod similarly).
+	OpPlus  Op = "+"
+	OpMinus Op = "-"
+	OpMult  Op = "*"
+	OpDiv   Op = "/"
+	OpMod   Op = "%"
+	// OpEQ jumps to z if x == y (OpNE, OpLT, OpGT, OpLE and OpGE similarly).
+	OpEQ Op = "=="
+	OpNE Op = "!="
+	OpLT Op = "<"
+	OpGT Op = ">"
+	OpLE Op = "<="
+	OpGE Op = ">="
+	// OpIfB jumps to z if the boolean x is true. It's never generated for Alan, which has no
+	// boolean values, but is part of the quadruple set.
+	OpIfB Op = "ifb"
+	// OpJump jumps to z.
+	OpJump Op = "jump"
+	// OpPar passes x as a parameter, in mode y, to the next call.
+	OpPar Op = "par"
+	// OpCall calls function z.
+	OpCall Op = "call"
+	// OpRet returns from the current unit.
+	OpRet Op = "ret"
+)
+
+// Quad is a quadruple. Unused operands are nil.
+type Quad struct {
+	Op      Op
+	X, Y, Z Operand
+}
+
+// String returns the quadruple in the traditional "op, x, y, z" form.
+func (q Quad) String() string {
+	return fmt.Sprintf("%s, %s, %s, %s", q.Op, operand(q.X), operand(q.Y), operand(q.Z))
+}
+
+// operand returns o as a string ("-" for unused operands).
+func operand(o Operand) string {
+	if o == nil {
+		return "-"
+	}
+	return o.String()
+}
+
+// Operand is an operand of a quadruple.
+type Operand interface {
+	fmt.Stringer
+}
+
+// Const is an integer or byte constant.
+type Const struct {
+	Val  int32
+	Type semantic.PrimitiveType
+}
+
+func (c Const) String() string {
+	if c.Type == semantic.PrimitiveTypeByte {
+		return strconv.QuoteRuneToASCII(rune(c.Val))
+	}
+	return strconv.Itoa(int(c.Val))
+}
+
+// Str is a string literal.
+type Str string
+
+func (s Str) String() string {
+	return strconv.QuoteToASCII(string(s))
+}
+
+// Var is a variable (a parameter or a local variable).
+type Var struct {
+	// Decl is the variable's declaration: *semantic.ParDef, *semantic.PrimVarDef or
+	// *semantic.ArrayDef.
+	Decl semantic.Node
+	// ID is the variable's identifier.
+	ID semantic.ID
+}
+
+func (v Var) String() string {
+	return string(v.ID)
+}
+
+// Temp is a temporary of the current unit.
+type Temp int
+
+func (t Temp) String() string {
+	return "$" + strconv.Itoa(int(t))
+}
+
+// Deref is the storage pointed to by a temporary (holding the address of an array element).
+type Deref Temp
+
+func (d Deref) String() string {
+	return "[" + Temp(d).String() + "]"
+}
+
+// Result is the result of the current unit.
+type Result struct{}
+
+func (Result) String() string {
+	return "$$"
+}
+
+// Label is the index of a quadruple in the current unit.
+type Label int
+
+func (l Label) String() string {
+	return strconv.Itoa(int(l))
+}
+
+// Func is a function (possibly from the standard library).
+type Func struct {
+	Def *semantic.FuncDef
+}
+
+func (f Func) String() string {
+	return string(f.Def.ID)
+}
+
+// Mode is a parameter passing mode.
+type Mode string
+
+const (
+	// ModeV is passing by value.
+	ModeV Mode = "V"
+	// ModeR is passing by reference.
+	ModeR Mode = "R"
+	// ModeRet is passing the address the result is to be stored in.
+	ModeRet Mode = "RET"
+)
+
+func (m Mode) String() string {
+	return string(m)
+}
+
+// Unit is the IR of a single function.
+type Unit struct {
+	// Def is the function's definition.
+	Def *semantic.FuncDef
+	// Temps are the types of the unit's temporaries, by number. Temporaries holding addresses
+	// (see OpArray) have the type of the element they point to.
+	Temps []semantic.PrimitiveType
+	// Quads are the unit's quadruples, the first being OpUnit and the last OpEndu.
+	Quads []Quad
+}
+
+// Program is the IR of a whole program.
+type Program struct {
+	// Units are the program's units, nested functions before the functions enclosing them (i.e.
+	// the main function is last).
+	Units []*Unit
+	// Target is the target the program is compiled for.
+	Target semantic.Target
+}
+
+// Fprint writes p to w, one quadruple per line preceded by its index, with units separated by
+// blank lines.
+func Fprint(w io.Writer, p *Program) error {
+	bw := bufio.NewWriter(w)
+	for i, u := range p.Units {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		for j, q := range u.Quads {
+			fmt.Fprintf(bw, "%d: %s\n", j, q)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,109 @@
+package ir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/internal/irexec"
+	"github.com/foxeng/alanc/interp"
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestGenerate(t *testing.T) {
+	src := `main() : proc
+	a : int [4];
+	f(x : int, y : reference int) : int
+	{
+		y = -x;
+		return x * 2;
+	}
+	i : int;
+{
+	i = 0;
+	while (i < 4 & !(i == 2 | a[i] > 0)) {
+		a[i] = f(i + 1, i);
+		if (a[i] != 0) writeString("odd\n"); else writeChar('x');
+	}
+}
+`
+	want := `0: unit, f, -, -
+1: -, 0, x, $0
+2: :=, $0, -, y
+3: *, x, 2, $1
+4: :=, $1, -, $$
+5: ret, -, -, -
+6: endu, f, -, -
+
+0: unit, main, -, -
+1: :=, 0, -, i
+2: <, i, 4, 4
+3: jump, -, -, 25
+4: ==, i, 2, 25
+5: jump, -, -, 6
+6: array, a, i, $0
+7: >, [$0], 0, 25
+8: jump, -, -, 9
+9: +, i, 1, $1
+10: par, $1, V, -
+11: par, i, R, -
+12: par, $2, RET, -
+13: call, -, -, f
+14: array, a, i, $3
+15: :=, $2, -, [$3]
+16: array, a, i, $4
+17: !=, [$4], 0, 19
+18: jump, -, -, 22
+19: par, "odd\n", R, -
+20: call, -, -, writeString
+21: jump, -, -, 2
+22: par, 'x', V, -
+23: call, -, -, writeChar
+24: jump, -, -, 2
+25: endu, main, -, -
+`
+	var b strings.Builder
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	if err := ir.Fprint(&b, ir.Generate(ast)); err != nil {
+		t.Fatalf("Fprint() failed: %v", err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("IR =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestGenerateRun checks that running the IR of programs behaves like interpreting them.
+func TestGenerateRun(t *testing.T) {
+	tests := []struct {
+		file   string
+		target string
+		input  string
+	}{
+		{file: "hello.alan"},
+		{file: "hanoi.alan", input: "3\n"},
+		{file: "primes.alan", input: "50\n"},
+		{file: "bubblesort.alan"},
+		{file: "reverse.alan"},
+		{file: "cryptography.alan", input: "abc xyz\n"},
+		{file: "wrap.alan", input: "-2147483648\n70000\n"},
+		{file: "wrap.alan", target: "int16", input: "-32768\n70000\n"},
+	}
+	for _, test := range tests {
+		src := alantest.Example(t, test.file)
+		target := semantic.Targets[test.target]
+		var want, got strings.Builder
+		if err := interp.Run(alantest.Parse(t, test.file, src, target), strings.NewReader(test.input),
+			&want); err != nil {
+			t.Fatalf("Run(%q) failed: %v", test.file, err)
+		}
+		ast := alantest.Parse(t, test.file, src, target)
+		if err := irexec.Run(ir.Generate(ast), strings.NewReader(test.input), &got); err != nil {
+			t.Fatalf("executing the IR of %q failed: %v", test.file, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("executing the IR of %q output = %q, want %q", test.file, got.String(),
+				want.String())
+		}
+	}
+}
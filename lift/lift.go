@@ -0,0 +1,281 @@
+// Package lift implements lambda lifting for Alan: moving nested functions to the top level, so
+// that the program can be compiled for targets without nested functions (e.g. C).
+package lift
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Alan has a single top-level function (main), so "top level" here means the local
+// definitions of main. After lifting, these are all the functions of the program (children before
+// parents, otherwise in order of definition), followed by main's local variables. Thus no function
+// can see any variables other than its own: each variable of an enclosing function that a function
+// used to capture (see semantic.AnalyzeClosures) becomes an extra parameter, passed by reference,
+// and every call passes these along. Nested functions are renamed after their enclosing ones (e.g.
+// "move" nested in "hanoi" becomes "hanoi_move"), to keep names unique. So is a function nested in
+// main and named main itself (shadowing it), the only way two top-level functions can clash.
+//
+// The lifted AST stays checked (i.e. declarations and types are kept up to date), but it may not
+// pass the checks again if printed and parsed back: a nested function calling one enclosing it
+// would now call a function defined after it.
+
+// lifter holds the state of the lambda lifting.
+type lifter struct {
+	// closures are the results of the closure analysis, by function.
+	closures map[*semantic.FuncDef]*semantic.Closure
+	// params are, for each function, the parameters replacing the variables it captures, as well
+	// as its own parameters (which are moved), by original declaration.
+	params map[*semantic.FuncDef]map[semantic.Node]*semantic.ParDef
+	// funcs are all the functions, children before parents.
+	funcs []*semantic.FuncDef
+	// names are all the identifiers in use.
+	names map[semantic.ID]bool
+}
+
+// Lift lifts all nested functions in ast to the top level. ast must have passed the semantic checks.
+// It is modified in place.
+func Lift(ast *semantic.Ast) {
+	l := &lifter{
+		closures: semantic.AnalyzeClosures(ast),
+		params:   map[*semantic.FuncDef]map[semantic.Node]*semantic.ParDef{},
+		names:    map[semantic.ID]bool{},
+	}
+	main := ast.Program
+	l.collect(main)
+	// Rename parents before children, so that names reflect the whole nesting.
+	for i := len(l.funcs) - 1; i >= 0; i-- {
+		switch f := l.funcs[i]; {
+		case l.closures[f].Depth > 1:
+			f.ID = l.unique(l.closures[f].Parent.Def.ID + "_" + f.ID)
+		case l.closures[f].Depth == 1 && f.ID == main.ID:
+			f.ID = l.unique(f.ID)
+		}
+	}
+	for _, f := range l.funcs {
+		l.addParams(f)
+	}
+	for _, f := range l.funcs {
+		l.stmt(&f.CompStmt, f)
+	}
+
+	// Gather everything in main.
+	ldefs := []semantic.LocalDef{}
+	for _, f := range l.funcs {
+		if f != main {
+			ldefs = append(ldefs, f)
+		}
+	}
+	for _, ld := range main.LDefs {
+		if _, ok := ld.(*semantic.FuncDef); !ok {
+			ldefs = append(ldefs, ld)
+		}
+	}
+	main.LDefs = ldefs
+}
+
+// collect records def and all functions nested in it (children first), as well as all the
+// identifiers they use, removing the nested functions from their local definitions.
+func (l *lifter) collect(def *semantic.FuncDef) {
+	l.names[def.ID] = true
+	for _, p := range def.Parameters {
+		l.names[p.ID] = true
+	}
+	ldefs := []semantic.LocalDef{}
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.FuncDef:
+			l.collect(ld)
+		case *semantic.PrimVarDef:
+			l.names[ld.ID] = true
+			ldefs = append(ldefs, ld)
+		case *semantic.ArrayDef:
+			l.names[ld.ID] = true
+			ldefs = append(ldefs, ld)
+		default:
+			panic(fmt.Sprintf("local definition of invalid type %T", ld))
+		}
+	}
+	l.funcs = append(l.funcs, def)
+	if l.closures[def].Depth > 0 {
+		// Main keeps its functions, as they are lifted to it.
+		def.LDefs = ldefs
+	}
+}
+
+// unique returns name, or a variation of it if it's in use, and marks the result as in use.
+func (l *lifter) unique(name semantic.ID) semantic.ID {
+	u := name
+	for i := 1; l.names[u]; i++ {
+		u = name + semantic.ID(strconv.Itoa(i))
+	}
+	l.names[u] = true
+	return u
+}
+
+// addParams adds the parameters replacing the variables captured by f to it.
+func (l *lifter) addParams(f *semantic.FuncDef) {
+	captures := l.closures[f].Captures()
+	// Names must not clash with anything f can see, i.e. its own variables and all functions.
+	taken := map[semantic.ID]bool{}
+	for _, g := range l.funcs {
+		taken[g.ID] = true
+	}
+	for _, p := range f.Parameters {
+		taken[p.ID] = true
+	}
+	for _, ld := range f.LDefs {
+		switch ld := ld.(type) {
+		case *semantic.PrimVarDef:
+			taken[ld.ID] = true
+		case *semantic.ArrayDef:
+			taken[ld.ID] = true
+		}
+	}
+
+	params := make([]semantic.ParDef, len(f.Parameters), len(f.Parameters)+len(captures))
+	copy(params, f.Parameters)
+	for _, v := range captures {
+		id, dt := variable(v)
+		if at, ok := dt.(semantic.ArrayType); ok {
+			// Array parameters have no size.
+			dt = semantic.ArrayType{PrimitiveType: at.PrimitiveType}
+		}
+		name := id
+		for i := 1; taken[name]; i++ {
+			name = id + semantic.ID("_"+strconv.Itoa(i))
+		}
+		taken[name] = true
+		params = append(params, semantic.ParDef{
+			ID: name,
+			Type: semantic.ParameterType{
+				DType: dt,
+				IsRef: true,
+			},
+		})
+	}
+
+	// Map both the original parameters (which have been moved) and the captured variables to the
+	// new parameters.
+	m := map[semantic.Node]*semantic.ParDef{}
+	for i := range f.Parameters {
+		m[&f.Parameters[i]] = &params[i]
+	}
+	for i, v := range captures {
+		m[v] = &params[len(f.Parameters)+i]
+	}
+	l.params[f] = m
+	f.Parameters = params
+}
+
+// variable returns the identifier and the data type of variable decl.
+func variable(decl semantic.Node) (semantic.ID, semantic.DType) {
+	switch decl := decl.(type) {
+	case *semantic.ParDef:
+		return decl.ID, decl.Type.DType
+	case *semantic.PrimVarDef:
+		return decl.ID, decl.Type
+	case *semantic.ArrayDef:
+		return decl.ID, decl.Type
+	default:
+		panic(fmt.Sprintf("variable declaration of invalid type %T", decl))
+	}
+}
+
+// ref returns a reference to variable decl (as it was before lifting) from the body of f.
+func (l *lifter) ref(decl semantic.Node, f *semantic.FuncDef) *semantic.VarRef {
+	if p, ok := l.params[f][decl]; ok {
+		decl = p
+	}
+	id, dt := variable(decl)
+	return &semantic.VarRef{
+		ID:   id,
+		Decl: decl,
+		Type: dt,
+	}
+}
+
+// stmt rewrites the variable references and the function calls in s, part of the body of f.
+func (l *lifter) stmt(s semantic.Stmt, f *semantic.FuncDef) {
+	switch s := s.(type) {
+	case *semantic.CompStmt:
+		for _, s := range s.Stmts {
+			l.stmt(s, f)
+		}
+	case *semantic.AssignStmt:
+		l.expr(s.Left, f)
+		l.expr(s.Right, f)
+	case *semantic.FuncCallStmt:
+		l.call(&s.FuncCall, f)
+	case *semantic.IfStmt:
+		l.expr(s.Cond, f)
+		l.stmt(s.Stmt, f)
+	case *semantic.IfElseStmt:
+		l.expr(s.Cond, f)
+		l.stmt(s.Stmt1, f)
+		l.stmt(s.Stmt2, f)
+	case *semantic.WhileStmt:
+		l.expr(s.Cond, f)
+		l.stmt(s.Stmt, f)
+	case *semantic.ReturnStmt:
+		if s.Expr != nil {
+			l.expr(s.Expr, f)
+		}
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// expr rewrites the variable references and the function calls in e, part of the body of f.
+func (l *lifter) expr(e semantic.Expr, f *semantic.FuncDef) {
+	switch e := e.(type) {
+	case *semantic.IntConstExpr, *semantic.CharConstExpr, *semantic.StrLitExpr,
+		*semantic.ConstCond:
+	case *semantic.VarRef:
+		r := l.ref(e.Decl, f)
+		e.ID = r.ID
+		e.Decl = r.Decl
+		e.Type = r.Type
+	case *semantic.ArrayElem:
+		r := l.ref(e.Decl, f)
+		e.ID = r.ID
+		e.Decl = r.Decl
+		l.expr(e.Index, f)
+		if e.Bound != nil {
+			l.expr(e.Bound, f)
+		}
+	case *semantic.FuncCallExpr:
+		l.call(&e.FuncCall, f)
+	case *semantic.UnArithExpr:
+		l.expr(e.Expr, f)
+	case *semantic.BinArithExpr:
+		l.expr(e.Left, f)
+		l.expr(e.Right, f)
+	case *semantic.UnCond:
+		l.expr(e.Cond, f)
+	case *semantic.CompCond:
+		l.expr(e.Left, f)
+		l.expr(e.Right, f)
+	case *semantic.BinCond:
+		l.expr(e.Left, f)
+		l.expr(e.Right, f)
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// call rewrites function call c, part of the body of f, to pass the variables the callee captures.
+func (l *lifter) call(c *semantic.FuncCall, f *semantic.FuncDef) {
+	for _, a := range c.Args {
+		l.expr(a, f)
+	}
+	if semantic.IsStdlib(c.Decl) {
+		return
+	}
+	c.ID = c.Decl.ID
+	for _, v := range l.closures[c.Decl].Captures() {
+		c.Args = append(c.Args, l.ref(v, f))
+	}
+}
@@ -0,0 +1,135 @@
+package lift_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/interp"
+	"github.com/foxeng/alanc/lift"
+	"github.com/foxeng/alanc/semantic"
+)
+
+const liftSrc = `main() : proc
+	n : int;
+	s : byte [8];
+	count(x : int) : int
+		c : int;
+		step() : proc
+			bump() : proc
+			{
+				c = c + 1;
+				s[c] = 'a';
+			}
+		{
+			bump();
+			n = n - 1;
+		}
+	{
+		c = x;
+		while (n > 0) step();
+		return c;
+	}
+	k : int;
+{
+	n = 3;
+	k = count(0);
+	writeInteger(k);
+	writeString(s);
+}
+`
+
+const liftWant = `main() : proc
+	count_step_bump(s : reference byte [], c : reference int) : proc
+	{
+		c = c + 1;
+		s[c] = 'a';
+	}
+	count_step(n : reference int, s : reference byte [], c : reference int) : proc
+	{
+		count_step_bump(s, c);
+		n = n - 1;
+	}
+	count(x : int, n : reference int, s : reference byte []) : int
+		c : int;
+	{
+		c = x;
+		while (n > 0)
+			count_step(n, s, c);
+		return c;
+	}
+	n : int;
+	s : byte [8];
+	k : int;
+{
+	n = 3;
+	k = count(0, n, s);
+	writeInteger(k);
+	writeString(s);
+}
+`
+
+func TestLift(t *testing.T) {
+	ast := alantest.Parse(t, "test.alan", []byte(liftSrc), semantic.Target{})
+	lift.Lift(ast)
+	var b strings.Builder
+	if err := semantic.Fprint(&b, ast); err != nil {
+		t.Fatalf("Fprint() failed: %v", err)
+	}
+	if got := b.String(); got != liftWant {
+		t.Errorf("lifted program =\n%s\nwant\n%s", got, liftWant)
+	}
+	// The lifted program is valid Alan in its own right.
+	alantest.Parse(t, "lifted.alan", []byte(b.String()), semantic.Target{})
+}
+
+// TestLiftRun checks that lifting doesn't change the behavior of programs.
+func TestLiftRun(t *testing.T) {
+	tests := []struct {
+		file  string
+		src   []byte
+		input string
+	}{
+		{file: "test.alan", src: []byte(liftSrc)},
+		{file: "hello.alan"},
+		{file: "hanoi.alan", input: "3\n"},
+		{file: "primes.alan", input: "50\n"},
+		{file: "bubblesort.alan"},
+		{file: "reverse.alan"},
+		{file: "shadow.alan", src: []byte(`main() : proc
+	main() : proc { writeString("inner\n"); }
+{
+	main();
+}
+`)},
+	}
+	for _, test := range tests {
+		src := test.src
+		if src == nil {
+			src = alantest.Example(t, test.file)
+		}
+		var want, got strings.Builder
+		if err := interp.Run(alantest.Parse(t, test.file, src, semantic.Target{}),
+			strings.NewReader(test.input), &want); err != nil {
+			t.Fatalf("Run(%q) failed: %v", test.file, err)
+		}
+		ast := alantest.Parse(t, test.file, src, semantic.Target{})
+		lift.Lift(ast)
+		names := map[semantic.ID]bool{ast.Program.ID: true}
+		for _, ld := range ast.Program.LDefs {
+			if f, ok := ld.(*semantic.FuncDef); ok {
+				if names[f.ID] {
+					t.Errorf("%q: function %q defined twice after lifting", test.file, f.ID)
+				}
+				names[f.ID] = true
+			}
+		}
+		if err := interp.Run(ast, strings.NewReader(test.input), &got); err != nil {
+			t.Fatalf("Run(%q) after lifting failed: %v", test.file, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("Run(%q) after lifting output = %q, want %q", test.file, got.String(),
+				want.String())
+		}
+	}
+}
@@ -1,36 +1,410 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/foxeng/alanc/bounds"
+	"github.com/foxeng/alanc/codegen"
+	"github.com/foxeng/alanc/interp"
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/lift"
 	"github.com/foxeng/alanc/parser"
 	"github.com/foxeng/alanc/semantic"
+	"github.com/foxeng/alanc/ssa"
 )
 
+// Exit statuses.
+const (
+	// exitOK is the exit status on success.
+	exitOK = 0
+	// exitFail is the exit status when any program has errors (or fails at run time).
+	exitFail = 1
+	// exitUsage is the exit status on invalid command lines.
+	exitUsage = 2
+)
+
+// commands are the subcommands of alanc, along with their descriptions (for usage).
+var commands = []struct {
+	name  string
+	usage string
+}{
+	{"build", "compile each program to the output selected with -emit (default)"},
+	{"check", "only check each program"},
+	{"run", "run each program directly, without compiling it"},
+	{"fmt", "print each program formatted (comments are lost)"},
+	{"ast", "print the syntax tree of each program, once checked (same as build -emit=ast)"},
+	{"tokens", "print the tokens of each program (same as build -emit=tokens)"},
+	{"lift", "print each program with its nested functions lifted to the top level"},
+}
+
+// stages are the outputs selectable with -emit, along with the extension of their output and the
+// function emitting it at an optimization level. Tokens are emitted straight from the source,
+// without parsing it (hence no emit function).
+var stages = map[string]struct {
+	ext  string
+	emit func(w io.Writer, ast *semantic.Ast, level int) error
+}{
+	"tokens": {".tokens", nil},
+	"ast":    {".ast", unoptimized(semantic.Dump)},
+	"llvm":   {".ll", unoptimized(codegen.EmitLLVM)},
+	"c":      {".c", unoptimized(codegen.EmitC)},
+	"asm":    {".s", unoptimized(codegen.EmitAsm)},
+	"ir":     {".ir", emitIR},
+	"ssa":    {".ssa", emitSSA},
+}
+
+// levelFlag is a flag selecting optimization level n (e.g. -O2), stored in level. Unsetting it
+// (e.g. -O2=false) selects level 0, if n was selected.
+type levelFlag struct {
+	level *int
+	n     int
+}
+
+func (f levelFlag) String() string {
+	// NOTE: The flag package calls this on the zero value as well.
+	return strconv.FormatBool(f.level != nil && *f.level == f.n)
+}
+
+func (f levelFlag) Set(s string) error {
+	set, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if set {
+		*f.level = f.n
+	} else if *f.level == f.n {
+		*f.level = 0
+	}
+	return nil
+}
+
+func (f levelFlag) IsBoolFlag() bool {
+	return true
+}
+
+// unoptimized adapts emit, whose output is not optimized, to stages (ignoring the level).
+func unoptimized(emit func(io.Writer, *semantic.Ast) error) func(io.Writer, *semantic.Ast,
+	int) error {
+	return func(w io.Writer, ast *semantic.Ast, _ int) error {
+		return emit(w, ast)
+	}
+}
+
+// emitIR writes the intermediate representation of ast to w, optimized at level (see
+// ssa.Optimize).
+func emitIR(w io.Writer, ast *semantic.Ast, level int) error {
+	p := ir.Generate(ast)
+	ssa.OptimizeProgram(ast, p, level)
+	return ir.Fprint(w, p)
+}
+
+// emitSSA writes the SSA form of each function of ast to w, optimized at level (see ssa.Optimize).
+func emitSSA(w io.Writer, ast *semantic.Ast, level int) error {
+	captured := ssa.Captured(ast)
+	p := ir.Generate(ast)
+	for i, u := range p.Units {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		f := ssa.Build(u, p.Target, captured)
+		ssa.Optimize(f, level)
+		if err := ssa.Fprint(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isCommand returns whether name is one of the commands.
+func isCommand(name string) bool {
+	for _, c := range commands {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// usage writes the usage of alanc, including the flags defined in fs, to w.
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: alanc [command] [flags] <source file>...\n\n"+
+		"Each source file (- for standard input) is a whole program, handled on its own.\n\n"+
+		"Commands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s%s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nThe exit status is %d on success, %d if any program has errors (or fails "+
+		"at run time)\nand %d on invalid usage.\n", exitOK, exitFail, exitUsage)
+}
+
+// compiler holds the settings of the command line, applied to each source file in turn.
+type compiler struct {
+	// cmd is the command.
+	cmd string
+	// stage is the output stage (see stages), if the command emits one.
+	stage string
+	// out is the output file ("-" for standard output, "" for the command's default).
+	out string
+	// target is the target the programs are compiled for.
+	target semantic.Target
+	// optLevel is the optimization level of ir and ssa output (see ssa.Optimize).
+	optLevel int
+	// boundsCheck denotes whether array indices are checked at run time.
+	boundsCheck bool
+	// stdin, stdout and stderr are the standard streams.
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
 func main() {
-	// TODO: Use proper command line parsing packages.
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <source file>\n", os.Args[0])
-		os.Exit(1)
+	os.Exit(alanc(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// alanc runs the command line args (without the program name), with the standard streams provided,
+// and returns the exit status.
+func alanc(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("alanc", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	stage := fs.String("emit", "llvm", "output `stage` of build (tokens, ast, llvm, c, asm, ir or "+
+		"ssa)")
+	out := fs.String("o", "", "write the output to `file` (- for standard output) instead of, for "+
+		"build, next to the source\nfile with the extension of the output stage or, for the other "+
+		"commands, to standard output")
+	targetName := fs.String("target", "int32", "target `description`, fixing the width of int "+
+		"(int16 or int32)")
+	boundsCheck := fs.Bool("bounds-check", false, "check array indices at run time")
+	optLevel := 0
+	for n, usage := range []string{
+		"don't optimize ir and ssa output",
+		"optimize ir and ssa output",
+		"optimize ir and ssa output further (common subexpressions)",
+	} {
+		fs.Var(levelFlag{level: &optLevel, n: n}, "O"+strconv.Itoa(n), usage)
+	}
+	fs.Usage = func() { usage(stderr, fs) }
+
+	// Flags may come before the command as well as after it.
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	cmd := "build"
+	if fs.NArg() > 0 && isCommand(fs.Arg(0)) {
+		cmd = fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return exitOK
+			}
+			return exitUsage
+		}
 	}
-	fin, err := os.Open(os.Args[1])
+	files := fs.Args()
+
+	// usageErr reports a usage error, formatted according to format, and returns the exit status.
+	usageErr := func(format string, a ...interface{}) int {
+		fmt.Fprintf(stderr, format+"\n", a...)
+		fmt.Fprintf(stderr, "Run 'alanc -h' for usage.\n")
+		return exitUsage
+	}
+	if len(files) == 0 {
+		return usageErr("no source files")
+	}
+	if _, ok := stages[*stage]; !ok {
+		return usageErr("unknown output stage %q", *stage)
+	}
+	target, ok := semantic.Targets[*targetName]
+	if !ok {
+		return usageErr("unknown target %q", *targetName)
+	}
+	if *out != "" && *out != "-" && len(files) > 1 {
+		return usageErr("-o %s with %d source files (only standard output can be shared)", *out,
+			len(files))
+	}
+	stdins := 0
+	for _, f := range files {
+		if f == "-" {
+			stdins++
+		}
+	}
+	if stdins > 1 {
+		return usageErr("standard input given as a source file more than once")
+	}
+	// The output stage only matters to build (and its shorthands).
+	switch cmd {
+	case "build":
+	case "ast", "tokens":
+		*stage = cmd
+	default:
+		*stage = ""
+	}
+
+	c := &compiler{
+		cmd:         cmd,
+		stage:       *stage,
+		out:         *out,
+		target:      target,
+		optLevel:    optLevel,
+		boundsCheck: *boundsCheck,
+		stdin:       stdin,
+		stdout:      stdout,
+		stderr:      stderr,
+	}
+	status := exitOK
+	for _, f := range files {
+		if !c.compile(f) {
+			status = exitFail
+		}
+	}
+	return status
+}
+
+// compile performs the command on the source file named file ("-" for standard input), reporting
+// any errors. It returns whether it succeeded.
+func (c *compiler) compile(file string) bool {
+	// NOTE: The whole source is read in memory, to be able to show it along with diagnostics.
+	name, text, err := c.read(file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "open %q: %v\n", os.Args[1], err)
-		os.Exit(1)
+		fmt.Fprintf(c.stderr, "read %q: %v\n", file, err)
+		return false
+	}
+	l := parser.NewLexer(name, bytes.NewReader(text))
+
+	if c.stage == "tokens" {
+		toks, err := parser.Tokenize(&l)
+		if err != nil {
+			c.report("lex", err, text)
+			return false
+		}
+		return c.output(file, func(w io.Writer) error {
+			for _, tok := range toks {
+				_, err := fmt.Fprintf(w, "%v: %s %s\n", tok.Span.Start, tok.Name,
+					text[tok.Span.Start.Offset:tok.Span.End.Offset])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 	}
-	defer fin.Close()
 
-	l := parser.NewLexer(bufio.NewReader(fin))
 	ast, err := parser.Parse(&l)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse: %v\n", err)
-		os.Exit(1)
+		c.report("parse", err, text)
+		return false
+	}
+	if c.cmd == "fmt" {
+		return c.output(file, func(w io.Writer) error { return semantic.Fprint(w, ast) })
 	}
 
+	ast.Target = c.target
 	if err = semantic.Check(ast); err != nil {
-		fmt.Fprintf(os.Stderr, "check: %v\n", err)
-		os.Exit(1)
+		c.report("check", err, text)
+		return false
+	}
+	if err = semantic.Fold(ast); err != nil {
+		c.report("fold", err, text)
+		return false
+	}
+	if c.boundsCheck {
+		bounds.Insert(ast)
+	}
+
+	switch c.cmd {
+	case "check":
+		return true
+	case "run":
+		if err = interp.Run(ast, c.stdin, c.stdout); err != nil {
+			fmt.Fprintf(c.stderr, "run %s: %v\n", name, err)
+			return false
+		}
+		return true
+	case "lift":
+		// Dump the program with all functions lifted to the top level, as Alan.
+		lift.Lift(ast)
+		return c.output(file, func(w io.Writer) error { return semantic.Fprint(w, ast) })
+	}
+	emit := stages[c.stage].emit
+	return c.output(file, func(w io.Writer) error { return emit(w, ast, c.optLevel) })
+}
+
+// read returns the name (for reporting positions) and the contents of the source file named file
+// ("-" for standard input).
+func (c *compiler) read(file string) (string, []byte, error) {
+	if file == "-" {
+		text, err := ioutil.ReadAll(c.stdin)
+		return "<stdin>", text, err
+	}
+	text, err := ioutil.ReadFile(file)
+	return file, text, err
+}
+
+// output writes the output for the source file named file, using emit. It goes to the file given
+// with -o or else, for build, to a file next to the source file, with the extension replaced by the
+// output stage's (to standard output if reading from standard input) and, for the other commands,
+// to standard output. It returns whether it succeeded.
+func (c *compiler) output(file string, emit func(io.Writer) error) bool {
+	what := c.stage
+	if what == "" {
+		what = c.cmd
+	}
+	out := c.out
+	if out == "" {
+		if c.cmd != "build" || file == "-" {
+			out = "-"
+		} else {
+			out = strings.TrimSuffix(file, filepath.Ext(file)) + stages[c.stage].ext
+		}
+	}
+	if out == "-" {
+		if err := emit(c.stdout); err != nil {
+			fmt.Fprintf(c.stderr, "%s: %v\n", what, err)
+			return false
+		}
+		return true
+	}
+
+	fout, err := os.Create(out)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "create %q: %v\n", out, err)
+		return false
+	}
+	if err = emit(fout); err != nil {
+		fout.Close()
+		fmt.Fprintf(c.stderr, "%s: %v\n", what, err)
+		return false
+	}
+	if err = fout.Close(); err != nil {
+		fmt.Fprintf(c.stderr, "close %q: %v\n", out, err)
+		return false
+	}
+	return true
+}
+
+// report prints err, which occurred during stage, to c.stderr. Diagnostics are rendered along with
+// the offending source, taken from text.
+func (c *compiler) report(stage string, err error, text []byte) {
+	switch d := err.(type) {
+	case *semantic.Diagnostic:
+		d.Render(c.stderr, text)
+		return
+	case semantic.Diagnostics:
+		d.Render(c.stderr, text)
+		return
 	}
+	fmt.Fprintf(c.stderr, "%s: %v\n", stage, err)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAlanc(t *testing.T) {
+	dir := t.TempDir()
+	hello := filepath.Join(dir, "hello.alan")
+	bad := filepath.Join(dir, "bad.alan")
+	for name, src := range map[string]string{
+		hello: "hello() : proc\n{\n\twriteString(\"Hello!\\n\");\n}\n",
+		bad:   "bad() : proc\n{\n\tx = 1;\n}\n",
+	} {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		status int
+		stdout string
+	}{
+		{"build", []string{hello}, "", exitOK, ""},
+		{"build flags after command", []string{"build", "-emit=c", "-o", "-", hello}, "", exitOK,
+			"#include"},
+		{"check", []string{"check", hello}, "", exitOK, ""},
+		{"check errors", []string{"check", hello, bad}, "", exitFail, ""},
+		{"run", []string{"run", hello}, "", exitOK, "Hello!\n"},
+		{"run flags before command", []string{"-O2", "run", hello}, "", exitOK, "Hello!\n"},
+		{"run stdin", []string{"run", "-"}, "main() : proc { writeInteger(42); }", exitOK, "42"},
+		{"fmt", []string{"fmt", "-"}, "main():proc x:int;{x=1;}", exitOK,
+			"main() : proc\n\tx : int;\n{\n\tx = 1;\n}\n"},
+		{"tokens", []string{"tokens", "-"}, "x = 1;", exitOK,
+			"<stdin>:1:1: IDENT x\n<stdin>:1:3: '=' =\n<stdin>:1:5: INT_CONST 1\n" +
+				"<stdin>:1:6: ';' ;\n"},
+		{"emit ast", []string{"-emit=ast", "-"}, "main() : proc { }", exitOK,
+			"FuncDef \"main\" @1:1\n"},
+		{"emit ir from stdin", []string{"-emit=ir", "-"}, "main() : proc { }", exitOK, "unit"},
+		{"optimized", []string{"-O2", "-emit=ir", "-"},
+			"main() : proc x : int; { x = 1; writeInteger(x); }", exitOK, "par, 1, V"},
+		{"optimization unset", []string{"-O2", "-O2=false", "-emit=ir", "-"},
+			"main() : proc x : int; { x = 1; writeInteger(x); }", exitOK, ":=, 1, -, x"},
+		{"missing file", []string{"check", filepath.Join(dir, "missing.alan")}, "", exitFail, ""},
+		{"no files", []string{"run"}, "", exitUsage, ""},
+		{"unknown flag", []string{"-x", hello}, "", exitUsage, ""},
+		{"unknown stage", []string{"-emit=foo", hello}, "", exitUsage, ""},
+		{"output for many", []string{"-o", "out.ll", hello, hello}, "", exitUsage, ""},
+		{"stdin twice", []string{"check", "-", "-"}, "", exitUsage, ""},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		status := alanc(test.args, strings.NewReader(test.stdin), &stdout, &stderr)
+		if status != test.status {
+			t.Errorf("%s: alanc(%q) = %d, want %d (stderr: %s)", test.name, test.args, status,
+				test.status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), test.stdout) {
+			t.Errorf("%s: alanc(%q) wrote %q, want it to contain %q", test.name, test.args,
+				stdout.String(), test.stdout)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hello.ll")); err != nil {
+		t.Errorf("build did not write the output next to the source: %v", err)
+	}
+}
@@ -4,19 +4,57 @@ package parser
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/foxeng/alanc/semantic"
 )
 
+func init() {
+	// Report the expected tokens along with syntax errors.
+	yyErrorVerbose = true
+}
+
 // Parse is a wrapper around goyacc's yyParse. When yyParse accepts, this returns the AST produced.
+// Otherwise, it returns the syntax errors found in the whole input, as semantic.Diagnostics (the
+// parser recovers from syntax errors at statement, local definition and parameter list level).
+// Parse is safe to call concurrently, as long as each call uses its own Lexer.
 func Parse(l *Lexer) (*semantic.Ast, error) {
 	r := yyParse(l)
-	if lexErr != nil {
-		return nil, fmt.Errorf("lexer: %v", lexErr)
+	if l.lexErr != nil {
+		if len(l.syntaxErrs) == 0 {
+			return nil, l.lexErr
+		}
+		return nil, append(l.syntaxErrs, l.lexErr)
+	}
+	if len(l.syntaxErrs) > 0 {
+		return nil, l.syntaxErrs
 	}
 	if r != 0 {
 		return nil, errors.New("parser rejected")
 	}
-	return ast, nil
+	return l.ast, nil
+}
+
+// Token is a lexical token.
+type Token struct {
+	// Name is the token's name in the grammar (e.g. "IDENT" or "'('").
+	Name string
+	// Span is the location in the source.
+	Span semantic.Span
+}
+
+// Tokenize returns the tokens read by l, up to the end of input. Lexing stops at the first error:
+// Tokenize then returns the tokens read so far along with the error (a *semantic.Diagnostic).
+func Tokenize(l *Lexer) ([]Token, error) {
+	var toks []Token
+	for {
+		var lval yySymType
+		char, tok := yylex1(l, &lval)
+		if l.lexErr != nil {
+			return toks, l.lexErr
+		}
+		if char == EOF {
+			return toks, nil
+		}
+		toks = append(toks, Token{Name: yyTokname(tok), Span: lval.span})
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 	"unicode"
@@ -159,13 +160,15 @@ func handleIntConst(b0 byte, bs io.ByteScanner, lval *yySymType) (int, error) {
 		}
 	}
 
-	i, err := strconv.Atoi(buf.String())
+	// NOTE: Literals too large even for int64 saturate, to be reported as out of range by the
+	// checker (which knows the width of int), along with any others.
+	i, err := strconv.ParseInt(buf.String(), 10, 64)
 	if err != nil {
-		if err == strconv.ErrSyntax {
+		if err.(*strconv.NumError).Err == strconv.ErrSyntax {
 			// This shouldn't happen because the digits are checked above
 			panic(err)
 		}
-		return -1, err
+		i = math.MaxInt64
 	}
 	lval.iconst = semantic.IntConstExpr{
 		Val: i,
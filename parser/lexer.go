@@ -3,10 +3,10 @@ package parser
 
 import (
 	"bytes"
-	"fmt"
 	"io"
-	"os"
 	"unicode"
+
+	"github.com/foxeng/alanc/semantic"
 )
 
 // EOF is the token number for the endmarker
@@ -15,42 +15,50 @@ const EOF = 0
 var operators = []byte{'=', '+', '-', '*', '/', '%', '!', '&', '|', '<', '>'}
 var separators = []byte{'(', ')', '[', ']', '{', '}', ',', ':', ';'}
 
-// lexErr communicates lexer errors to the parser driver.
-// TODO: Figure out a way to do this via the parser, not bypassing it.
-var lexErr error
-
 // Lexer is the lexer for Alan.
 type Lexer struct {
 	pbs *posByteScanner
+	// last is the location of the last token returned.
+	last semantic.Span
+	// syntaxErrs are the syntax errors reported by the parser.
+	syntaxErrs semantic.Diagnostics
+	// lexErr is the lexer error encountered, if any. Lexing stops at the first error.
+	lexErr *semantic.Diagnostic
+	// ast is the AST constructed by the parser (nil if not accepted).
+	ast *semantic.Ast
 }
 
-// NewLexer returns a new Lexer.
-func NewLexer(bs io.ByteScanner) Lexer {
+// NewLexer returns a new Lexer reading the source file named file (used only for reporting
+// positions) from bs.
+func NewLexer(file string, bs io.ByteScanner) Lexer {
 	return Lexer{
-		pbs: newPosByteScanner(bs),
+		pbs: newPosByteScanner(file, bs),
 	}
 }
 
-// Lex returns the next token identifier and places the relevant token information on lval.
-func (l Lexer) Lex(lval *yySymType) int {
+// Lex returns the next token identifier and places the relevant token information (including its
+// location) on lval.
+func (l *Lexer) Lex(lval *yySymType) int {
 	// Consume whitespace and comments
 	var b0 byte
+	var start semantic.Pos
 	var err error
 	for {
+		start = l.pbs.pos()
 		b0, err = l.pbs.ReadByte()
 		if err != nil {
 			if err == io.EOF {
-				return EOF
+				return l.eof(lval)
 			}
-			lexErr = fmt.Errorf("starting new token: %v (%v)", err, l.pbs)
+			l.lexError(start, "starting new token: %v", err)
 			return -1
 		}
 		if unicode.IsSpace(rune(b0)) {
 			if err = consumeSpace(l.pbs); err != nil {
 				if err == io.EOF {
-					return EOF
+					return l.eof(lval)
 				}
-				lexErr = fmt.Errorf("consuming white space: %v (%v)", err, l.pbs)
+				l.lexError(start, "consuming white space: %v", err)
 				return -1
 			}
 		} else if b0 == '-' || b0 == '(' {
@@ -59,17 +67,17 @@ func (l Lexer) Lex(lval *yySymType) int {
 				if err == io.EOF {
 					break // return b0 and let the parser handle the EOF in the next call
 				}
-				lexErr = fmt.Errorf("checking for comment: %v (%v)", err, l.pbs)
+				l.lexError(start, "checking for comment: %v", err)
 				return -1
 			}
 			if b0 == '-' && b1 == '-' {
 				if err = consumeLineComment(l.pbs); err != nil {
-					lexErr = fmt.Errorf("consuming line comment: %v (%v)", err, l.pbs)
+					l.lexError(start, "consuming line comment: %v", err)
 					return -1
 				}
 			} else if b0 == '(' && b1 == '*' {
 				if err = consumeBlockComment(l.pbs); err != nil {
-					lexErr = fmt.Errorf("consuming block comment: %v (%v)", err, l.pbs)
+					l.lexError(start, "consuming block comment: %v", err)
 					return -1
 				}
 			} else {
@@ -99,7 +107,7 @@ func (l Lexer) Lex(lval *yySymType) int {
 	case bytes.ContainsRune(separators, rune(b0)):
 		handler = handleSep
 	default:
-		lexErr = fmt.Errorf("unexpected character: %c (code point %d) (%v)", b0, b0, l.pbs)
+		l.lexError(start, "unexpected character: %c (code point %d)", b0, b0)
 		return -1
 	}
 
@@ -107,13 +115,39 @@ func (l Lexer) Lex(lval *yySymType) int {
 	if err != nil {
 		// TODO OPT: Report what token was being scanned (thus, specialize for each switch case
 		// above)
-		lexErr = fmt.Errorf("%v (%v)", err, l.pbs)
+		l.lexError(start, "%v", err)
 		return -1
 	}
+	lval.span = semantic.Span{
+		Start: start,
+		End:   l.pbs.pos(),
+	}
+	l.last = lval.span
 	return tok
 }
 
-// Error reports a parser error, e.
-func (l Lexer) Error(e string) {
-	fmt.Fprintf(os.Stderr, "parse: %s (around %v)\n", e, l.pbs)
+// eof returns the endmarker, placing its (empty) location on lval.
+func (l *Lexer) eof(lval *yySymType) int {
+	lval.span = semantic.Span{
+		Start: l.pbs.pos(),
+		End:   l.pbs.pos(),
+	}
+	l.last = lval.span
+	return EOF
+}
+
+// lexError reports a lexer error for the token starting at start, with the message formatted
+// according to format.
+func (l *Lexer) lexError(start semantic.Pos, format string, a ...interface{}) {
+	l.lexErr = semantic.Errorf(semantic.Span{Start: start, End: l.pbs.pos()}, semantic.CodeLex,
+		format, a...)
+}
+
+// Error reports a parser error, e, at the location of the last token returned.
+func (l *Lexer) Error(e string) {
+	if l.lexErr != nil {
+		// The parser sees the end of input after a lexer error, so any further errors are spurious.
+		return
+	}
+	l.syntaxErrs = append(l.syntaxErrs, semantic.Errorf(l.last, semantic.CodeSyntax, "%s", e))
 }
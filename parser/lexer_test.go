@@ -87,7 +87,7 @@ func TestLexer(t *testing.T) {
 	var lval yySymType
 	for test, wants := range tests {
 		wants = append(wants, EOF)
-		l := NewLexer(strings.NewReader(test))
+		l := NewLexer("", strings.NewReader(test))
 		for i, want := range wants {
 			if got := l.Lex(&lval); got != want {
 				t.Errorf("Lex(%q) [%d] = %q, want %q", test, i, tokToName(got), tokToName(want))
@@ -95,3 +95,26 @@ func TestLexer(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenize(t *testing.T) {
+	l := NewLexer("", strings.NewReader("if (x >= 'a') -- comment\n\tf(\"s\");"))
+	toks, err := Tokenize(&l)
+	if err != nil {
+		t.Fatalf("Tokenize() failed: %v", err)
+	}
+	want := []string{"IF 1:1", "'(' 1:4", "IDENT 1:5", "GE 1:7", "CHAR_LIT 1:10", "')' 1:13",
+		"IDENT 2:2", "'(' 2:3", "STR_LIT 2:4", "')' 2:7", "';' 2:8"}
+	got := make([]string, len(toks))
+	for i, tok := range toks {
+		got[i] = fmt.Sprintf("%s %v", tok.Name, tok.Span.Start)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Tokenize() = %v, want %v", got, want)
+	}
+
+	l = NewLexer("", strings.NewReader("x = 1 $"))
+	toks, err = Tokenize(&l)
+	if err == nil || len(toks) != 3 {
+		t.Errorf("Tokenize() = %d tokens, %v, want 3 tokens and an error", len(toks), err)
+	}
+}
@@ -2,11 +2,6 @@
 package parser
 
 import "github.com/foxeng/alanc/semantic"
-
-// TODO OPT: Avoid this global. How?
-
-// ast is the AST constructed by the parser.
-var ast *semantic.Ast
 %}
 
 %token BYTE
@@ -59,6 +54,7 @@ var ast *semantic.Ast
 	iconst semantic.IntConstExpr
 	cconst semantic.CharConstExpr
 	strlit semantic.StrLitExpr
+	span   semantic.Span
 }
 
 %type <yys> BYTE
@@ -101,7 +97,9 @@ var ast *semantic.Ast
 program:
 	func_def
 	{
-		ast = &semantic.Ast{
+		// The AST is handed to the driver through the lexer, the only per-parse state reachable
+		// from here.
+		yylex.(*Lexer).ast = &semantic.Ast{
 			Program: $1,
 		}
 	}
@@ -116,6 +114,7 @@ func_def:
 			RType: $6,
 			LDefs: $7,
 			CompStmt: $8,
+			Span: $<span>1.Join($8.Span),
 		}
 	}
 ;
@@ -133,6 +132,11 @@ fpar_list:
 	{
 		$$ = append($1, $3)
 	}
+|	error
+	{
+		// Recover from a syntax error in the parameter list (resynchronise on ')').
+		$$ = []semantic.ParDef{}
+	}
 ;
 
 fpar_def:
@@ -143,6 +147,20 @@ fpar_def:
 			Type: semantic.ParameterType{
 				DType: $3,
 			},
+			Span: $<span>1.Join($<span>3),
+		}
+	}
+|	IDENT ':' data_type '[' ']'
+	{
+		// Arrays can only be passed by reference, but this is left to the checker to report.
+		$$ = semantic.ParDef{
+			ID: $1,
+			Type: semantic.ParameterType{
+				DType: semantic.ArrayType{
+					PrimitiveType: $3,
+				},
+			},
+			Span: $<span>1.Join($<span>5),
 		}
 	}
 |	IDENT ':' REFERENCE data_type
@@ -153,6 +171,7 @@ fpar_def:
 				DType: $4,
 				IsRef: true,
 			},
+			Span: $<span>1.Join($<span>4),
 		}
 	}
 |	IDENT ':' REFERENCE data_type '[' ']'
@@ -165,6 +184,7 @@ fpar_def:
 				},
 				IsRef: true,
 			},
+			Span: $<span>1.Join($<span>6),
 		}
 	}
 ;
@@ -214,6 +234,7 @@ local_def:
 		$$ = &semantic.PrimVarDef{
 			ID: $1,
 			Type: $3,
+			Span: $<span>1.Join($<span>4),
 		}
 	}
 |	IDENT ':' data_type '[' INT_CONST ']' ';'
@@ -222,10 +243,17 @@ local_def:
 			ID: $1,
 			Type: semantic.ArrayType{
 				PrimitiveType: $3,
-				Size: $5.Val,
+				Size: int($5.Val),
 			},
+			Span: $<span>1.Join($<span>7),
 		}
 	}
+|	error ';'
+	{
+		// Recover from a syntax error in a local definition (resynchronise on ';'). The AST is
+		// discarded anyway, so there is no definition to return.
+		$$ = nil
+	}
 ;
 
 stmt:
@@ -233,6 +261,7 @@ stmt:
 	{
 		$$ = &semantic.CompStmt{
 			Stmts: []semantic.Stmt{},
+			Span: $<span>1,
 		}
 	}
 |	l_value '=' expr ';'
@@ -240,6 +269,7 @@ stmt:
 		$$ = &semantic.AssignStmt{
 			Left: $1,
 			Right: $3,
+			Span: $1.Loc().Join($<span>4),
 		}
 	}
 |	compound_stmt
@@ -259,6 +289,7 @@ stmt:
 		$$ = &semantic.IfStmt{
 			Cond: $3,
 			Stmt: $5,
+			Span: $<span>1.Join($5.Loc()),
 		}
 	}
 |	IF '(' cond ')' stmt ELSE stmt
@@ -267,6 +298,7 @@ stmt:
 			Cond: $3,
 			Stmt1: $5,
 			Stmt2: $7,
+			Span: $<span>1.Join($7.Loc()),
 		}
 	}
 |	WHILE '(' cond ')' stmt
@@ -274,18 +306,30 @@ stmt:
 		$$ = &semantic.WhileStmt{
 			Cond: $3,
 			Stmt: $5,
+			Span: $<span>1.Join($5.Loc()),
 		}
 	}
 |	RETURN ';'
 	{
 		$$ = &semantic.ReturnStmt{
 			Expr: nil,
+			Span: $<span>1.Join($<span>2),
 		}
 	}
 |	RETURN expr ';'
 	{
 		$$ = &semantic.ReturnStmt{
 			Expr: $2,
+			Span: $<span>1.Join($<span>3),
+		}
+	}
+|	error ';'
+	{
+		// Recover from a syntax error in a statement (resynchronise on ';'). The AST is discarded
+		// anyway, but return an (empty) statement for any enclosing actions to work with.
+		$$ = &semantic.CompStmt{
+			Stmts: []semantic.Stmt{},
+			Span: $<span>2,
 		}
 	}
 ;
@@ -295,6 +339,15 @@ compound_stmt:
 	{
 		$$ = semantic.CompStmt{
 			Stmts: $2,
+			Span: $<span>1.Join($<span>3),
+		}
+	}
+|	'{' stmt_list error '}'
+	{
+		// Recover from a syntax error at the end of a block (resynchronise on '}').
+		$$ = semantic.CompStmt{
+			Stmts: $2,
+			Span: $<span>1.Join($<span>4),
 		}
 	}
 ;
@@ -316,6 +369,7 @@ func_call:
 		$$ = semantic.FuncCall{
 			ID: $1,
 			Args: []semantic.Expr{},
+			Span: $<span>1.Join($<span>3),
 		}
 	}
 |	IDENT '(' expr_list ')'
@@ -323,6 +377,7 @@ func_call:
 		$$ = semantic.FuncCall{
 			ID: $1,
 			Args: $3,
+			Span: $<span>1.Join($<span>4),
 		}
 	}
 ;
@@ -343,12 +398,14 @@ expr:
 	{
 		// Make sure to return a pointer to a _copy_ of $1, because the underlying $1 is reused.
 		i := $1
+		i.Span = $<span>1
 		$$ = &i
 	}
 |	CHAR_LIT
 	{
 		// Make sure to return a pointer to a _copy_ of $1, because the underlying $1 is reused.
 		c := $1
+		c.Span = $<span>1
 		$$ = &c
 	}
 |	l_value
@@ -370,6 +427,7 @@ expr:
 		$$ = &semantic.UnArithExpr{
 			Sign: semantic.SignPlus,
 			Expr: $2,
+			Span: $<span>1.Join($2.Loc()),
 		}
 	}
 |	'-' expr %prec SIGN
@@ -377,6 +435,7 @@ expr:
 		$$ = &semantic.UnArithExpr{
 			Sign: semantic.SignMinus,
 			Expr: $2,
+			Span: $<span>1.Join($2.Loc()),
 		}
 	}
 |	expr '+' expr
@@ -385,6 +444,7 @@ expr:
 			Left: $1,
 			Op: semantic.ArithOpPlus,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr '-' expr
@@ -393,6 +453,7 @@ expr:
 			Left: $1,
 			Op: semantic.ArithOpMinus,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr '*' expr
@@ -401,6 +462,7 @@ expr:
 			Left: $1,
 			Op: semantic.ArithOpMult,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr '/' expr
@@ -409,6 +471,7 @@ expr:
 			Left: $1,
 			Op: semantic.ArithOpDiv,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr '%' expr
@@ -417,6 +480,7 @@ expr:
 			Left: $1,
 			Op: semantic.ArithOpMod,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 ;
@@ -426,6 +490,7 @@ l_value:
 	{
 		$$ = &semantic.VarRef{
 			ID: $1,
+			Span: $<span>1,
 		}
 	}
 |	IDENT '[' expr ']'
@@ -433,12 +498,14 @@ l_value:
 		$$ = &semantic.ArrayElem{
 			ID: $1,
 			Index: $3,
+			Span: $<span>1.Join($<span>4),
 		}
 	}
 |	STR_LIT
 	{
 		// Make sure to return a pointer to a _copy_ of $1, because the underlying $1 is reused.
 		s := $1
+		s.Span = $<span>1
 		$$ = &s
 	}
 ;
@@ -448,12 +515,14 @@ cond:
 	{
 		$$ = &semantic.ConstCond{
 			Val: true,
+			Span: $<span>1,
 		}
 	}
 |	FALSE
 	{
 		$$ = &semantic.ConstCond{
 			Val: false,
+			Span: $<span>1,
 		}
 	}
 |	'(' cond ')'
@@ -464,6 +533,7 @@ cond:
 	{
 		$$ = &semantic.UnCond{
 			Cond: $2,
+			Span: $<span>1.Join($2.Loc()),
 		}
 	}
 |	expr EQ expr
@@ -472,6 +542,7 @@ cond:
 			Left: $1,
 			Op: semantic.CompOpEQ,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr NE expr
@@ -480,6 +551,7 @@ cond:
 			Left: $1,
 			Op: semantic.CompOpNE,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr '<' expr
@@ -488,6 +560,7 @@ cond:
 			Left: $1,
 			Op: semantic.CompOpLT,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr '>' expr
@@ -496,6 +569,7 @@ cond:
 			Left: $1,
 			Op: semantic.CompOpGT,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr LE expr
@@ -504,6 +578,7 @@ cond:
 			Left: $1,
 			Op: semantic.CompOpLE,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	expr GE expr
@@ -512,6 +587,7 @@ cond:
 			Left: $1,
 			Op: semantic.CompOpGE,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	cond '&' cond
@@ -520,6 +596,7 @@ cond:
 			Left: $1,
 			Op: semantic.LogOpAnd,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 |	cond '|' cond
@@ -528,6 +605,7 @@ cond:
 			Left: $1,
 			Op: semantic.LogOpOr,
 			Right: $3,
+			Span: $1.Loc().Join($3.Loc()),
 		}
 	}
 ;
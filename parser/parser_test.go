@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestParseSpans(t *testing.T) {
+	src := `main() : proc
+	x : int;
+{
+	x = x + 1;
+}`
+	l := NewLexer("test.alan", strings.NewReader(src))
+	ast, err := Parse(&l)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	pos := func(line, col, offset int) semantic.Pos {
+		return semantic.Pos{
+			File:   "test.alan",
+			Line:   line,
+			Col:    col,
+			Offset: offset,
+		}
+	}
+	fd := ast.Program
+	as := fd.Stmts[0].(*semantic.AssignStmt)
+	be := as.Right.(*semantic.BinArithExpr)
+	tests := []struct {
+		name string
+		semantic.Node
+		want semantic.Span
+	}{
+		{"FuncDef", fd, semantic.Span{Start: pos(1, 1, 0), End: pos(5, 2, 39)}},
+		{"PrimVarDef", fd.LDefs[0], semantic.Span{Start: pos(2, 2, 15), End: pos(2, 10, 23)}},
+		{"CompStmt", &fd.CompStmt, semantic.Span{Start: pos(3, 1, 24), End: pos(5, 2, 39)}},
+		{"AssignStmt", as, semantic.Span{Start: pos(4, 2, 27), End: pos(4, 12, 37)}},
+		{"VarRef", as.Left, semantic.Span{Start: pos(4, 2, 27), End: pos(4, 3, 28)}},
+		{"BinArithExpr", be, semantic.Span{Start: pos(4, 6, 31), End: pos(4, 11, 36)}},
+		{"IntConstExpr", be.Right, semantic.Span{Start: pos(4, 10, 35), End: pos(4, 11, 36)}},
+	}
+	for _, test := range tests {
+		if got := test.Loc(); got != test.want {
+			t.Errorf("%s.Loc() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	y int;
+	f(a : int, b) : proc { }
+{
+	x = 1 +;
+	writeInteger(x)
+	x = 2;
+	if (x == ) x = 3;
+}`
+	l := NewLexer("test.alan", strings.NewReader(src))
+	_, err := Parse(&l)
+	ds, ok := err.(semantic.Diagnostics)
+	if !ok {
+		t.Fatalf("Parse() returned %T (%v), want semantic.Diagnostics", err, err)
+	}
+
+	wantLines := []int{3, 4, 6, 8, 9}
+	if len(ds) != len(wantLines) {
+		t.Fatalf("Parse() reported %d errors, want %d:\n%v", len(ds), len(wantLines), ds)
+	}
+	for i, d := range ds {
+		if d.Code != semantic.CodeSyntax {
+			t.Errorf("error #%d has code %q, want %q", i+1, d.Code, semantic.CodeSyntax)
+		}
+		if d.Span.Start.Line != wantLines[i] {
+			t.Errorf("error #%d on line %d, want %d", i+1, d.Span.Start.Line, wantLines[i])
+		}
+	}
+	if want := "expecting ';'"; !strings.Contains(ds[3].Msg, want) {
+		t.Errorf("error #4 is %q, want it to contain %q", ds[3].Msg, want)
+	}
+}
+
+func TestParseConcurrent(t *testing.T) {
+	valid := "main() : proc\n\tx : int;\n{\n\tx = %d;\n}\n"
+	invalid := "main() : proc\n{\n\t@\n}\n"
+
+	const n = 16
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 1 {
+				l := NewLexer("invalid.alan", strings.NewReader(invalid))
+				if _, err := Parse(&l); err == nil {
+					errs[i] = fmt.Errorf("Parse() of invalid program succeeded")
+				}
+				return
+			}
+			l := NewLexer("valid.alan", strings.NewReader(fmt.Sprintf(valid, i)))
+			ast, err := Parse(&l)
+			if err != nil {
+				errs[i] = fmt.Errorf("Parse() failed: %v", err)
+				return
+			}
+			as := ast.Program.Stmts[0].(*semantic.AssignStmt)
+			if got := as.Right.(*semantic.IntConstExpr).Val; got != int64(i) {
+				errs[i] = fmt.Errorf("Parse() returned the AST of another call (x = %d, want %d)",
+					got, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("goroutine %d: %v", i, err)
+		}
+	}
+}
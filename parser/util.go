@@ -1,21 +1,25 @@
 package parser
 
 import (
-	"fmt"
 	"io"
+
+	"github.com/foxeng/alanc/semantic"
 )
 
 // posByteScanner adds position handling to io.ByteScanner.
 type posByteScanner struct {
 	bs          io.ByteScanner
+	file        string
 	line, col   int
+	offset      int
 	prevCol     int  // the last column of the previous line
 	lastNewLine bool // whether the last byte read was a newline
 }
 
-func newPosByteScanner(bs io.ByteScanner) *posByteScanner {
+func newPosByteScanner(file string, bs io.ByteScanner) *posByteScanner {
 	return &posByteScanner{
 		bs:          bs,
+		file:        file,
 		line:        1,
 		col:         1,
 		lastNewLine: false,
@@ -26,6 +30,7 @@ func newPosByteScanner(bs io.ByteScanner) *posByteScanner {
 func (pbs *posByteScanner) ReadByte() (byte, error) {
 	b, err := pbs.bs.ReadByte()
 	if err == nil {
+		pbs.offset++
 		if b == '\n' {
 			pbs.line++
 			pbs.prevCol = pbs.col
@@ -41,6 +46,7 @@ func (pbs *posByteScanner) ReadByte() (byte, error) {
 
 // UnreadByte adds position handling to io.ByteScanner.UnreadByte.
 func (pbs *posByteScanner) UnreadByte() error {
+	pbs.offset--
 	if pbs.lastNewLine {
 		pbs.line--
 		pbs.col = pbs.prevCol
@@ -50,6 +56,12 @@ func (pbs *posByteScanner) UnreadByte() error {
 	return pbs.bs.UnreadByte()
 }
 
-func (pbs posByteScanner) String() string {
-	return fmt.Sprintf("line %d, column %d", pbs.line, pbs.col)
+// pos returns the position of the next byte to be read.
+func (pbs *posByteScanner) pos() semantic.Pos {
+	return semantic.Pos{
+		File:   pbs.file,
+		Line:   pbs.line,
+		Col:    pbs.col,
+		Offset: pbs.offset,
+	}
 }
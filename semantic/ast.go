@@ -62,12 +62,16 @@ type LogOp rune
 // Ast is a whole abstract syntax tree.
 type Ast struct {
 	Program *FuncDef
+	// Target is the target the program is compiled for.
+	Target Target
 }
 
 // Node is a single Node of an AST.
 type Node interface {
 	isNode()
-	check(*SymTab) (Type, error)
+	// Loc returns the node's location in the source.
+	Loc() Span
+	check(*checker) Type
 }
 
 // LocalDef is a local definition.
@@ -88,12 +92,17 @@ type FuncDef struct {
 	LDefs []LocalDef
 	// CompStmt is the function's body.
 	CompStmt
+	// Span is the location in the source.
+	Span Span
 }
 
 // TODO OPT: Define the methods on value instead of pointer receivers?
 
 func (*FuncDef) isNode() {}
 
+// Loc implements Node.
+func (n *FuncDef) Loc() Span { return n.Span }
+
 func (*FuncDef) isLocalDef() {}
 
 // ParDef is a function parameter's definition.
@@ -102,10 +111,15 @@ type ParDef struct {
 	ID
 	// Type is the parameter's type.
 	Type ParameterType
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*ParDef) isNode() {}
 
+// Loc implements Node.
+func (n *ParDef) Loc() Span { return n.Span }
+
 func (*ParDef) isLocalDef() {}
 
 // PrimVarDef is a primitive variable definition.
@@ -114,10 +128,15 @@ type PrimVarDef struct {
 	ID
 	// Type is the variable's (primitive) type.
 	Type PrimitiveType
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*PrimVarDef) isNode() {}
 
+// Loc implements Node.
+func (n *PrimVarDef) Loc() Span { return n.Span }
+
 func (*PrimVarDef) isLocalDef() {}
 
 // ArrayDef is an array definition.
@@ -126,10 +145,15 @@ type ArrayDef struct {
 	ID
 	// Type is the array's type.
 	Type ArrayType
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*ArrayDef) isNode() {}
 
+// Loc implements Node.
+func (n *ArrayDef) Loc() Span { return n.Span }
+
 func (*ArrayDef) isLocalDef() {}
 
 // Stmt is a statement.
@@ -142,10 +166,15 @@ type Stmt interface {
 type CompStmt struct {
 	// Stmts are the statement's constituents.
 	Stmts []Stmt
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*CompStmt) isNode() {}
 
+// Loc implements Node.
+func (n *CompStmt) Loc() Span { return n.Span }
+
 func (*CompStmt) isStmt() {}
 
 // AssignStmt is an assignment statement.
@@ -154,10 +183,15 @@ type AssignStmt struct {
 	Left LVal
 	// Right is the right-hand side of the assignment.
 	Right Expr
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*AssignStmt) isNode() {}
 
+// Loc implements Node.
+func (n *AssignStmt) Loc() Span { return n.Span }
+
 func (*AssignStmt) isStmt() {}
 
 // FuncCall is a function call.
@@ -166,18 +200,29 @@ type FuncCall struct {
 	ID
 	// Args are the call's arguments.
 	Args []Expr
+	// Decl is the called function's definition, resolved by the checker (see IsStdlib for standard
+	// library functions).
+	Decl *FuncDef
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*FuncCall) isNode() {}
 
+// Loc implements Node.
+func (n *FuncCall) Loc() Span { return n.Span }
+
 // FuncCallStmt is a function call statement.
 type FuncCallStmt struct {
-	// FuncCall is the underlying function call.
+	// FuncCall is the underlying function call (which holds the location in the source).
 	FuncCall
 }
 
 func (*FuncCallStmt) isNode() {}
 
+// Loc implements Node. This is the location of the call (the terminating ';' excluded).
+func (n *FuncCallStmt) Loc() Span { return n.FuncCall.Span }
+
 func (*FuncCallStmt) isStmt() {}
 
 // IfStmt is an if statement.
@@ -186,10 +231,15 @@ type IfStmt struct {
 	Cond
 	// Stmt is the if statement's body.
 	Stmt
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*IfStmt) isNode() {}
 
+// Loc implements Node.
+func (n *IfStmt) Loc() Span { return n.Span }
+
 func (*IfStmt) isStmt() {}
 
 // TODO OPT: Merge with IfStmt?
@@ -202,10 +252,15 @@ type IfElseStmt struct {
 	Stmt1 Stmt
 	// Stmt2 is the else clause's body.
 	Stmt2 Stmt
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*IfElseStmt) isNode() {}
 
+// Loc implements Node.
+func (n *IfElseStmt) Loc() Span { return n.Span }
+
 func (*IfElseStmt) isStmt() {}
 
 // WhileStmt is a while statement.
@@ -214,46 +269,76 @@ type WhileStmt struct {
 	Cond
 	// Stmt is the while statement's body.
 	Stmt
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*WhileStmt) isNode() {}
 
+// Loc implements Node.
+func (n *WhileStmt) Loc() Span { return n.Span }
+
 func (*WhileStmt) isStmt() {}
 
 // ReturnStmt is a return statement.
 type ReturnStmt struct {
 	// Expr is the return expression (nil if nothing is returned).
 	Expr
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*ReturnStmt) isNode() {}
 
+// Loc implements Node.
+func (n *ReturnStmt) Loc() Span { return n.Span }
+
 func (*ReturnStmt) isStmt() {}
 
 // Expr is an expression.
 type Expr interface {
 	Node
 	isExpr()
+	// DataType returns the expression's type, as resolved by the checker (nil if not checked).
+	DataType() DType
 }
 
 // IntConstExpr is an integer constant expression.
 type IntConstExpr struct {
-	Val int // TODO OPT: Use fixed width?
+	// Val is the constant's value. Literals may be out of the range of int (the checker reports
+	// them), but folded constants (which may be negative) are not.
+	Val int64
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*IntConstExpr) isNode() {}
 
+// Loc implements Node.
+func (n *IntConstExpr) Loc() Span { return n.Span }
+
 func (*IntConstExpr) isExpr() {}
 
+// DataType implements Expr.
+func (*IntConstExpr) DataType() DType { return PrimitiveTypeInt }
+
 // CharConstExpr is a character constant expression.
 type CharConstExpr struct {
 	Val rune
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*CharConstExpr) isNode() {}
 
+// Loc implements Node.
+func (n *CharConstExpr) Loc() Span { return n.Span }
+
 func (*CharConstExpr) isExpr() {}
 
+// DataType implements Expr.
+func (*CharConstExpr) DataType() DType { return PrimitiveTypeByte }
+
 // LVal is an l-value.
 type LVal interface {
 	Expr
@@ -264,12 +349,25 @@ type LVal interface {
 type VarRef struct {
 	// ID is the variable's identifier.
 	ID
+	// Decl is the variable's declaration (a *PrimVarDef, *ArrayDef or *ParDef), resolved by the
+	// checker.
+	Decl Node
+	// Type is the variable's type, resolved by the checker.
+	Type DType
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*VarRef) isNode() {}
 
+// Loc implements Node.
+func (n *VarRef) Loc() Span { return n.Span }
+
 func (*VarRef) isExpr() {}
 
+// DataType implements Expr.
+func (n *VarRef) DataType() DType { return n.Type }
+
 func (*VarRef) isLVal() {}
 
 // TODO OPT: Merge with VarRef?
@@ -280,48 +378,94 @@ type ArrayElem struct {
 	ID
 	// Index is the element's index.
 	Index Expr
+	// Decl is the array's declaration (an *ArrayDef or *ParDef), resolved by the checker.
+	Decl Node
+	// Type is the element's type, resolved by the checker.
+	Type DType
+	// Bound is the array's size, if the index is to be checked against it at run time (see
+	// package bounds), or nil.
+	Bound Expr
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*ArrayElem) isNode() {}
 
+// Loc implements Node.
+func (n *ArrayElem) Loc() Span { return n.Span }
+
 func (*ArrayElem) isExpr() {}
 
+// DataType implements Expr.
+func (n *ArrayElem) DataType() DType { return n.Type }
+
 func (*ArrayElem) isLVal() {}
 
 // StrLitExpr is a string literal expression.
 type StrLitExpr struct {
 	// Val is the underlying string literal.
 	Val string
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*StrLitExpr) isNode() {}
 
+// Loc implements Node.
+func (n *StrLitExpr) Loc() Span { return n.Span }
+
 func (*StrLitExpr) isExpr() {}
 
+// DataType implements Expr.
+func (n *StrLitExpr) DataType() DType {
+	return ArrayType{
+		PrimitiveType: PrimitiveTypeByte,
+		Size:          len(n.Val) + 1,
+	}
+}
+
+// NOTE: String literals are l-values in the grammar, but they are read-only: the checker rejects
+// any writes to them (see checkReadOnly).
 func (*StrLitExpr) isLVal() {}
 
 // FuncCallExpr is a function call expression.
 type FuncCallExpr struct {
-	// FuncCall is the underlying function call.
+	// FuncCall is the underlying function call (which holds the location in the source).
 	FuncCall
+	// Type is the function's return type, resolved by the checker.
+	Type DType
 }
 
 func (*FuncCallExpr) isNode() {}
 
+// Loc implements Node.
+func (n *FuncCallExpr) Loc() Span { return n.FuncCall.Span }
+
 func (*FuncCallExpr) isExpr() {}
 
+// DataType implements Expr.
+func (n *FuncCallExpr) DataType() DType { return n.Type }
+
 // UnArithExpr is an unary arithmetic expression.
 type UnArithExpr struct {
 	// Sign is the expression's sign.
 	Sign
 	// Expr is the underlying (arithmetic) expression.
 	Expr
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*UnArithExpr) isNode() {}
 
+// Loc implements Node.
+func (n *UnArithExpr) Loc() Span { return n.Span }
+
 func (*UnArithExpr) isExpr() {}
 
+// DataType implements Expr.
+func (*UnArithExpr) DataType() DType { return PrimitiveTypeInt }
+
 // BinArithExpr is a binary arithmetic expression.
 type BinArithExpr struct {
 	// Left is the left-hand side of the expression.
@@ -330,12 +474,22 @@ type BinArithExpr struct {
 	Op ArithOp
 	// Right is the right-hand side of the expression.
 	Right Expr
+	// Type is the expression's type, resolved by the checker.
+	Type DType
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*BinArithExpr) isNode() {}
 
+// Loc implements Node.
+func (n *BinArithExpr) Loc() Span { return n.Span }
+
 func (*BinArithExpr) isExpr() {}
 
+// DataType implements Expr.
+func (n *BinArithExpr) DataType() DType { return n.Type }
+
 // Cond is a condition.
 type Cond interface {
 	Expr
@@ -346,24 +500,40 @@ type Cond interface {
 type ConstCond struct {
 	// Val is the underlying constant (i.e. true or false)
 	Val bool
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*ConstCond) isNode() {}
 
+// Loc implements Node.
+func (n *ConstCond) Loc() Span { return n.Span }
+
 func (*ConstCond) isExpr() {}
 
+// DataType implements Expr.
+func (*ConstCond) DataType() DType { return PrimitiveTypeBool }
+
 func (*ConstCond) isCond() {}
 
 // UnCond is an unary condition (negation).
 type UnCond struct {
 	// Cond is the underlying condition.
 	Cond
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*UnCond) isNode() {}
 
+// Loc implements Node.
+func (n *UnCond) Loc() Span { return n.Span }
+
 func (*UnCond) isExpr() {}
 
+// DataType implements Expr.
+func (*UnCond) DataType() DType { return PrimitiveTypeBool }
+
 func (*UnCond) isCond() {}
 
 // CompCond is a comparison condition.
@@ -374,12 +544,20 @@ type CompCond struct {
 	Op CompOp
 	// Right is the right-hand side of the comparison.
 	Right Expr
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*CompCond) isNode() {}
 
+// Loc implements Node.
+func (n *CompCond) Loc() Span { return n.Span }
+
 func (*CompCond) isExpr() {}
 
+// DataType implements Expr.
+func (*CompCond) DataType() DType { return PrimitiveTypeBool }
+
 func (*CompCond) isCond() {}
 
 // BinCond is a binary logical condition.
@@ -390,10 +568,18 @@ type BinCond struct {
 	Op LogOp
 	// Right is the right-hand side of the condition.
 	Right Cond
+	// Span is the location in the source.
+	Span Span
 }
 
 func (*BinCond) isNode() {}
 
+// Loc implements Node.
+func (n *BinCond) Loc() Span { return n.Span }
+
 func (*BinCond) isExpr() {}
 
+// DataType implements Expr.
+func (*BinCond) DataType() DType { return PrimitiveTypeBool }
+
 func (*BinCond) isCond() {}
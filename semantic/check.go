@@ -1,20 +1,83 @@
 package semantic
 
 import (
-	"errors"
 	"fmt"
 )
 
-// Check performs the semantic checks on the provided AST.
+// checker holds the state of the semantic checks.
+type checker struct {
+	*SymTab
+	// target is the target the program is compiled for.
+	target Target
+	// fn is the definition of the function currently being checked.
+	fn *FuncDef
+	// strArgs are the string literals passed by reference, to be checked once the whole program
+	// has been (see checkReadOnly).
+	strArgs []strArg
+	// diags are the diagnostics reported so far.
+	diags Diagnostics
+}
+
+// Check performs the semantic checks on the provided AST. Checking does not stop at the first
+// error: all errors found are returned together, as Diagnostics.
 func Check(ast *Ast) error {
-	st := NewSymTab()
-	if _, err := ast.Program.check(st); err != nil {
-		return err
+	c := &checker{SymTab: NewSymTab(), target: ast.Target}
+	ast.Program.check(c)
+	c.checkReadOnly(ast.Program)
+	if len(c.diags) > 0 {
+		return c.diags
 	}
 	return nil
 }
 
-func (n *FuncDef) check(st *SymTab) (Type, error) {
+// errorf reports an error diagnostic of kind code for the location of n, with the message
+// formatted according to format. It returns the error type, for the caller's convenience.
+func (c *checker) errorf(n Node, code Code, format string, a ...interface{}) Type {
+	c.diags = append(c.diags, Errorf(n.Loc(), code, format, a...))
+	return errorType{}
+}
+
+// redefined reports an error diagnostic for the redefinition of id by n, noting the location of the
+// previous definition (if known).
+func (c *checker) redefined(n Node, id ID) {
+	d := Errorf(n.Loc(), CodeRedefined, "%q already defined", id)
+	if _, prev := c.LookupDecl(id); prev != nil {
+		d.Related = append(d.Related, Note(prev.Loc(), "previous definition of %q is here", id))
+	}
+	c.diags = append(c.diags, d)
+}
+
+// undefined reports an error diagnostic for the reference to the undefined id by n. To avoid
+// reporting the same error on every subsequent reference, id is then defined with the error type.
+func (c *checker) undefined(n Node, id ID) Type {
+	c.errorf(n, CodeUndefined, "%q not defined", id)
+	c.Add(id, errorType{})
+	return errorType{}
+}
+
+// checkCond descends on cond and checks it is boolean. what describes cond, for error messages.
+func (c *checker) checkCond(cond Cond, what string) {
+	t := cond.check(c)
+	if !isError(t) && t != PrimitiveTypeBool {
+		c.errorf(cond, CodeType, "%s of type %q, need %q", what, t, PrimitiveTypeBool)
+	}
+}
+
+// wholeArray reports an error diagnostic if e, of type t, is a reference to a whole array, used as
+// what (only the elements of arrays are values). It returns whether it is.
+func (c *checker) wholeArray(e Expr, t Type, what string) bool {
+	vr, ok := e.(*VarRef)
+	if !ok {
+		return false
+	}
+	if _, ok := t.(ArrayType); !ok {
+		return false
+	}
+	c.errorf(e, CodeArray, "whole array %q used as %s", vr.ID, what)
+	return true
+}
+
+func (n *FuncDef) check(c *checker) Type {
 	// NOTE: Ideally, we would add the function to the current scope, enter a new scope and proceed
 	// with the rest (parameters, locals, etc.). But, to add the function we need to know the
 	// parameter and return types. To do this, we enter a new, temporary scope just to get the
@@ -24,152 +87,198 @@ func (n *FuncDef) check(st *SymTab) (Type, error) {
 		Return:     n.RType,
 	}
 	// Enter temporary scope.
-	st.Enter("")
+	c.Enter("")
 	// Descend on parameters.
-	for i, p := range n.Parameters {
-		t, err := p.check(st)
-		if err != nil {
-			return nil, err
-		}
-		fType.Parameters[i] = t.(ParameterType)
+	for i := range n.Parameters {
+		fType.Parameters[i] = n.Parameters[i].check(c).(ParameterType)
 	}
 	// Exit temporary scope.
-	st.Exit()
+	c.Exit()
 
 	// Check that main has no parameters and has proc return type.
-	if st.CurrentID() == "" {
+	if c.CurrentID() == "" {
 		if len(fType.Parameters) > 0 {
-			return nil, errors.New("main function cannot accept parameters")
+			c.errorf(n, CodeMain, "main function cannot accept parameters")
 		}
 		if fType.Return != nil {
-			return nil, errors.New("main function must have proc return type")
+			c.errorf(n, CodeMain, "main function must have proc return type")
 		}
 	}
 
-	// Add to scope.
-	if !st.Add(n.ID, fType) {
-		return nil, fmt.Errorf("%q already defined", n.ID)
+	// Add to scope. On redefinition, carry on checking the function regardless.
+	if !c.AddDecl(n.ID, fType, n) {
+		c.redefined(n, n.ID)
 	}
 	// Enter scope.
-	st.Enter(n.ID)
-	// Descend on parameters (just to add them to the scope).
-	for _, p := range n.Parameters {
-		_, err := p.check(st)
-		if err != nil {
-			// NOTE: This should never happen, they have been checked above.
-			panic(fmt.Sprintf("error not already caught: %v", err))
-		}
+	c.Enter(n.ID)
+	parent := c.fn
+	c.fn = n
+	// Add parameters to the scope (any redefinitions have been reported above).
+	for i := range n.Parameters {
+		p := &n.Parameters[i]
+		c.AddDecl(p.ID, p.Type.DType, p)
 	}
 	// Descend on local definitions.
 	for _, ld := range n.LDefs {
-		if _, err := ld.check(st); err != nil {
-			return nil, err
-		}
+		ld.check(c)
 	}
 	// Descend on body.
-	if _, err := n.CompStmt.check(st); err != nil {
-		return nil, err
-	}
+	n.CompStmt.check(c)
+	// Check the body's control flow.
+	c.checkFlow(n)
 	// Exit scope.
-	st.Exit()
+	c.fn = parent
+	c.Exit()
 
-	return fType, nil
+	return fType
 }
 
-func (n *ParDef) check(st *SymTab) (Type, error) {
+func (n *ParDef) check(c *checker) Type {
 	// Add to scope.
-	if !st.Add(n.ID, n.Type.DType) {
-		return nil, fmt.Errorf("%q already defined", n.ID)
+	if !c.AddDecl(n.ID, n.Type.DType, n) {
+		c.redefined(n, n.ID)
+	}
+	// Check arrays are passed by reference.
+	if _, ok := n.Type.DType.(ArrayType); ok && !n.Type.IsRef {
+		c.errorf(n, CodeByRef, "array parameter %q must be passed by reference", n.ID)
 	}
 
-	return n.Type, nil
+	return n.Type
 }
 
-func (n *PrimVarDef) check(st *SymTab) (Type, error) {
+func (n *PrimVarDef) check(c *checker) Type {
 	// Add to scope.
-	if !st.Add(n.ID, n.Type) {
-		return nil, fmt.Errorf("%q already defined", n.ID)
+	if !c.AddDecl(n.ID, n.Type, n) {
+		c.redefined(n, n.ID)
 	}
 
-	return n.Type, nil
+	return n.Type
 }
 
-func (n *ArrayDef) check(st *SymTab) (Type, error) {
+func (n *ArrayDef) check(c *checker) Type {
 	// Add to scope.
-	if !st.Add(n.ID, n.Type) {
-		return nil, fmt.Errorf("%q already defined", n.ID)
+	if !c.AddDecl(n.ID, n.Type, n) {
+		c.redefined(n, n.ID)
+	}
+	// Check size is positive and fits in an int (so that every element can be indexed).
+	if n.Type.Size <= 0 {
+		c.errorf(n, CodeSize, "array %q of non-positive size %d", n.ID, n.Type.Size)
+	} else if int64(n.Type.Size) > c.target.MaxInt() {
+		c.errorf(n, CodeSize, "array %q of size %d, out of range for %d-bit int", n.ID,
+			n.Type.Size, c.target.IntWidth())
 	}
 
-	return n.Type, nil
+	return n.Type
 }
 
-func (n *CompStmt) check(st *SymTab) (Type, error) {
+func (n *CompStmt) check(c *checker) Type {
 	// Descend on each statement.
 	for _, s := range n.Stmts {
-		if _, err := s.check(st); err != nil {
-			return nil, err
-		}
+		s.check(c)
 	}
 
-	return nil, nil
+	return nil
 }
 
-func (n *AssignStmt) check(st *SymTab) (Type, error) {
-	// Descend on l-value.
-	lt, err := n.Left.check(st)
-	if err != nil {
-		return nil, err
+func (n *AssignStmt) check(c *checker) Type {
+	// Check l-value is not a string literal (these are read-only).
+	if _, ok := n.Left.(*StrLitExpr); ok {
+		c.errorf(n.Left, CodeReadOnly, "cannot assign to string literal")
+		n.Right.check(c)
+		return nil
 	}
+	// Descend on l-value.
+	lt := n.Left.check(c)
 	// Descend on r-value.
-	rt, err := n.Right.check(st)
-	if err != nil {
-		return nil, err
+	rt := n.Right.check(c)
+	if isError(lt) || isError(rt) {
+		return nil
+	}
+	// Check neither is a whole array.
+	if c.wholeArray(n.Left, lt, "assignment target") || c.wholeArray(n.Right, rt,
+		"assigned value") {
+		return nil
 	}
 
 	// Check l-value and r-value are of the same, primitive type.
 	plt, ok := lt.(PrimitiveType)
 	if !ok {
-		return nil, fmt.Errorf("cannot assign to non-primitive type %T", lt)
+		c.errorf(n.Left, CodeType, "cannot assign to non-primitive type %q", lt)
+		return nil
 	}
 	prt, ok := rt.(PrimitiveType)
 	if !ok {
-		return nil, fmt.Errorf("cannot assign non-primitive type %T", lt)
+		c.errorf(n.Right, CodeType, "cannot assign non-primitive type %q", rt)
+		return nil
 	}
 	if plt != prt {
-		return nil, fmt.Errorf("cannot assign primitive type %T to %T", prt, plt)
+		c.errorf(n, CodeType, "cannot assign primitive type %q to %q", prt, plt)
 	}
 
-	return nil, nil
+	return nil
 }
 
-func (n *FuncCall) check(st *SymTab) (Type, error) {
+func (n *FuncCall) check(c *checker) Type {
 	// Lookup ID.
-	t := st.Lookup(n.ID)
-	if t == nil {
-		return nil, fmt.Errorf("%q not defined", n.ID)
+	t, decl := c.LookupDecl(n.ID)
+	var ft FunctionType
+	switch tt := t.(type) {
+	case nil:
+		t = c.undefined(n, n.ID)
+	case errorType:
+	case FunctionType:
+		ft = tt
+		// Standard library functions have no declaration in the SymTab.
+		if fd, ok := decl.(*FuncDef); ok {
+			n.Decl = fd
+		} else {
+			n.Decl = stdlibDefs[n.ID]
+		}
+	default:
+		t = c.errorf(n, CodeKind, "%q not a function", n.ID)
 	}
-	ft, ok := t.(FunctionType)
-	if !ok {
-		return nil, fmt.Errorf("%q not a function", n.ID)
+	if isError(t) {
+		// Descend on arguments anyway, to report any errors in them.
+		for _, a := range n.Args {
+			a.check(c)
+		}
+		return t
+	}
+
+	// Check the number of arguments matches the number of parameters.
+	if len(n.Args) != len(ft.Parameters) {
+		d := Errorf(n.Loc(), CodeArity, "%q called with %d argument(s), want %d", n.ID,
+			len(n.Args), len(ft.Parameters))
+		if decl != nil {
+			d.Related = append(d.Related, Note(decl.Loc(), "%q defined here", n.ID))
+		}
+		c.diags = append(c.diags, d)
 	}
 
 	// Descend on arguments.
 	for i, a := range n.Args {
-		t, err := a.check(st)
-		if err != nil {
-			return nil, err
+		t := a.check(c)
+		if isError(t) || i >= len(ft.Parameters) {
+			continue
 		}
 		// Check argument type-matches corresponding parameter.
 		switch pt := ft.Parameters[i].DType.(type) {
 		case PrimitiveType:
+			if c.wholeArray(a, t, fmt.Sprintf("argument #%d to %q", i+1, n.ID)) {
+				continue
+			}
 			if t != pt {
-				return nil, fmt.Errorf("argument #%d to %q has type %T, want %T", i+1, n.ID, t, pt)
+				c.errorf(a, CodeType, "argument #%d to %q has type %q, want %q", i+1, n.ID, t,
+					ft.Parameters[i])
+				continue
 			}
 		case ArrayType:
 			// Ignore array sizes (i.e. only check the element types match).
 			at, ok := t.(ArrayType)
 			if !ok || at.PrimitiveType != pt.PrimitiveType {
-				return nil, fmt.Errorf("argument #%d to %q has type %T, want %T", i+1, n.ID, t, pt)
+				c.errorf(a, CodeType, "argument #%d to %q has type %q, want %q", i+1, n.ID, t,
+					ft.Parameters[i])
+				continue
 			}
 		default:
 			panic(fmt.Sprintf("function parameter of invalid data type %T", pt))
@@ -177,253 +286,296 @@ func (n *FuncCall) check(st *SymTab) (Type, error) {
 		if ft.Parameters[i].IsRef {
 			// Check argument is an l-value.
 			if _, ok := a.(LVal); !ok {
-				return nil, fmt.Errorf("argument #%d to %q cannot be passed by reference (not an "+
-					"l-value", i+1, n.ID)
+				c.errorf(a, CodeByRef, "argument #%d to %q cannot be passed by reference (not "+
+					"an l-value)", i+1, n.ID)
+			} else if _, ok := a.(*StrLitExpr); ok {
+				// Whether the callee may write to it is only known once the program is checked.
+				c.strArgs = append(c.strArgs, strArg{call: n, i: i})
 			}
 		}
 	}
 
-	return ft.Return, nil
+	return ft.Return
 }
 
-func (n *FuncCallStmt) check(st *SymTab) (Type, error) {
+func (n *FuncCallStmt) check(c *checker) Type {
 	// Descend on FuncCall.
-	if _, err := n.FuncCall.check(st); err != nil {
-		return nil, err
-	}
+	n.FuncCall.check(c)
 
-	return nil, nil
+	return nil
 }
 
-func (n *IfStmt) check(st *SymTab) (Type, error) {
+func (n *IfStmt) check(c *checker) Type {
 	// Descend on condition.
-	// TODO OPT: Check condition type? Shouldn't be necessary...
-	if _, err := n.Cond.check(st); err != nil {
-		return nil, err
-	}
+	c.checkCond(n.Cond, "condition")
 	// Descend on statement.
-	if _, err := n.Stmt.check(st); err != nil {
-		return nil, err
-	}
+	n.Stmt.check(c)
 
-	return nil, nil
+	return nil
 }
 
-func (n *IfElseStmt) check(st *SymTab) (Type, error) {
+func (n *IfElseStmt) check(c *checker) Type {
 	// Descend on condition.
-	// TODO OPT: Check condition type? Shouldn't be necessary...
-	if _, err := n.Cond.check(st); err != nil {
-		return nil, err
-	}
+	c.checkCond(n.Cond, "condition")
 	// Descend on if branch statement.
-	if _, err := n.Stmt1.check(st); err != nil {
-		return nil, err
-	}
+	n.Stmt1.check(c)
 	// Descend on else branch statement.
-	if _, err := n.Stmt2.check(st); err != nil {
-		return nil, err
-	}
+	n.Stmt2.check(c)
 
-	return nil, nil
+	return nil
 }
 
-func (n *WhileStmt) check(st *SymTab) (Type, error) {
+func (n *WhileStmt) check(c *checker) Type {
 	// Descend on condition.
-	// TODO OPT: Check condition type? Shouldn't be necessary...
-	if _, err := n.Cond.check(st); err != nil {
-		return nil, err
-	}
+	c.checkCond(n.Cond, "condition")
 	// Descend on statement.
-	if _, err := n.Stmt.check(st); err != nil {
-		return nil, err
-	}
+	n.Stmt.check(c)
 
-	return nil, nil
+	return nil
 }
 
-func (n *ReturnStmt) check(st *SymTab) (Type, error) {
+func (n *ReturnStmt) check(c *checker) Type {
+	fName := c.fn.ID
+	fRet := c.fn.RType
 	if n.Expr == nil {
-		return nil, nil
+		// Check the enclosing function is a proc.
+		if fRet != nil {
+			c.errorf(n, CodeReturn, "missing return value in function %q with return type %q",
+				fName, *fRet)
+		}
+		return nil
 	}
 	// Descend on expression.
-	t, err := n.Expr.check(st)
-	if err != nil {
-		return nil, err
+	t := n.Expr.check(c)
+	if isError(t) {
+		return nil
 	}
 	// Check expression type matches return type of enclosing function.
-	fName := st.CurrentID()
-	fRet := st.Lookup(fName).(FunctionType).Return
 	if fRet == nil {
-		if t != nil {
-			return nil, fmt.Errorf("return %T from procedure %q", t, fName)
-		}
+		c.errorf(n, CodeReturn, "return %q from procedure %q", t, fName)
+		return nil
 	}
 	if t != *fRet {
-		return nil, fmt.Errorf("return %T from function %q with return type %T", t, fName, fRet)
+		c.errorf(n, CodeReturn, "return %q from function %q with return type %q", t, fName,
+			*fRet)
 	}
 
-	return nil, nil
+	return nil
 }
 
-func (n *IntConstExpr) check(st *SymTab) (Type, error) {
-	return PrimitiveTypeInt, nil
+func (n *IntConstExpr) check(c *checker) Type {
+	// Check the constant is in range (the most negative int is handled by UnArithExpr).
+	if n.Val > c.target.MaxInt() {
+		return c.errorf(n, CodeRange, "integer constant out of range for %d-bit int (max %d)",
+			c.target.IntWidth(), c.target.MaxInt())
+	}
+
+	return PrimitiveTypeInt
 }
 
-func (n *CharConstExpr) check(st *SymTab) (Type, error) {
-	return PrimitiveTypeByte, nil
+func (n *CharConstExpr) check(c *checker) Type {
+	return PrimitiveTypeByte
 }
 
-func (n *VarRef) check(st *SymTab) (Type, error) {
+func (n *VarRef) check(c *checker) Type {
 	// Lookup ID.
-	t := st.Lookup(n.ID)
+	t, decl := c.LookupDecl(n.ID)
+	n.Decl = decl
+	n.Type = errorType{}
 	if t == nil {
-		return nil, fmt.Errorf("%q not defined", n.ID)
+		return c.undefined(n, n.ID)
 	}
 	pt, ok := t.(DType)
 	if !ok {
-		return nil, fmt.Errorf("%q not a variable", n.ID)
+		return c.errorf(n, CodeKind, "%q not a variable", n.ID)
 	}
+	n.Type = pt
 
-	return pt, nil
+	return pt
 }
 
-func (n *ArrayElem) check(st *SymTab) (Type, error) {
+func (n *ArrayElem) check(c *checker) Type {
 	// Lookup ID.
-	t := st.Lookup(n.ID)
-	if t == nil {
-		return nil, fmt.Errorf("%q not defined", n.ID)
-	}
-	at, ok := t.(ArrayType)
-	if !ok {
-		return nil, fmt.Errorf("%q not an array", n.ID)
+	t, decl := c.LookupDecl(n.ID)
+	n.Decl = decl
+	var et DType
+	switch t := t.(type) {
+	case nil:
+		et = c.undefined(n, n.ID).(DType)
+	case errorType:
+		et = t
+	case ArrayType:
+		et = t.PrimitiveType
+	default:
+		et = c.errorf(n, CodeKind, "%q not an array", n.ID).(DType)
 	}
+	n.Type = et
 
 	// Descend on expression.
-	t, err := n.Index.check(st)
-	if err != nil {
-		return nil, err
-	}
+	t = n.Index.check(c)
 	// Check expression is int.
-	switch et := t.(type) {
+	switch it := t.(type) {
+	case errorType:
 	case PrimitiveType:
-		if et != PrimitiveTypeInt {
-			return nil, fmt.Errorf("array index of primitive type %T, need \"int\"", et)
+		if it != PrimitiveTypeInt {
+			c.errorf(n.Index, CodeType, "array index of primitive type %q, need \"int\"", it)
 		}
 	default:
-		return nil, fmt.Errorf("array index of non-primitive type %T, need \"int\"", t)
+		c.errorf(n.Index, CodeType, "array index of non-primitive type %q, need \"int\"", t)
+	}
+	// Check a constant index is within bounds (the size of array parameters is unknown, but it
+	// can't be negative).
+	if t != PrimitiveTypeInt {
+		return et
+	}
+	if i, ok := constant(n.Index, c.target); ok {
+		switch decl := decl.(type) {
+		case *ArrayDef:
+			if i < 0 || int(i) >= decl.Type.Size {
+				c.errorf(n.Index, CodeBounds, "index %d out of bounds for array %q of size %d", i,
+					n.ID, decl.Type.Size)
+			}
+		case *ParDef:
+			if i < 0 {
+				c.errorf(n.Index, CodeBounds, "negative index %d for array %q", i, n.ID)
+			}
+		}
 	}
 
-	return at.PrimitiveType, nil
+	return et
 }
 
-func (n *StrLitExpr) check(st *SymTab) (Type, error) {
+func (n *StrLitExpr) check(c *checker) Type {
 	return ArrayType{
 		PrimitiveType: PrimitiveTypeByte,
 		Size:          len(n.Val) + 1,
-	}, nil
+	}
 }
 
-func (n *FuncCallExpr) check(st *SymTab) (Type, error) {
+func (n *FuncCallExpr) check(c *checker) Type {
 	// Descend on FuncCall.
-	t, err := n.FuncCall.check(st)
-	if err != nil {
-		return nil, err
+	n.Type = errorType{}
+	t := n.FuncCall.check(c)
+	if isError(t) {
+		return t
 	}
 	rt := t.(*PrimitiveType)
 	if rt == nil {
-		return nil, fmt.Errorf("cannot call procedure %q in an expression", n.ID)
+		return c.errorf(n, CodeType, "cannot call procedure %q in an expression", n.ID)
 	}
+	n.Type = *rt
 
-	return *rt, nil
+	return *rt
 }
 
-func (n *UnArithExpr) check(st *SymTab) (Type, error) {
+func (n *UnArithExpr) check(c *checker) Type {
+	// The most negative int can only be written negated, as its absolute value is out of range.
+	if ic, ok := n.Expr.(*IntConstExpr); ok && n.Sign == SignMinus && ic.Val == -c.target.MinInt() {
+		return PrimitiveTypeInt
+	}
 	// Descend on expression.
-	t, err := n.Expr.check(st)
-	if err != nil {
-		return nil, err
+	t := n.Expr.check(c)
+	if c.wholeArray(n.Expr, t, "operand of unary arithmetic expression") {
+		return errorType{}
 	}
 	// Check expression is int.
 	switch et := t.(type) {
+	case errorType:
+		return et
 	case PrimitiveType:
 		if et != PrimitiveTypeInt {
-			return nil, fmt.Errorf("unary arithmetic expression of primitive type %T, need \"int\"",
-				et)
+			return c.errorf(n.Expr, CodeType, "unary arithmetic expression of primitive type %q, "+
+				"need \"int\"", et)
 		}
 	default:
-		return nil, fmt.Errorf("unary arithmetic expression of non-primitive type %T, need \"int\"",
-			t)
+		return c.errorf(n.Expr, CodeType, "unary arithmetic expression of non-primitive type %q, "+
+			"need \"int\"", t)
 	}
 
-	return PrimitiveTypeInt, nil
+	return PrimitiveTypeInt
 }
 
-func (n *BinArithExpr) check(st *SymTab) (Type, error) {
+func (n *BinArithExpr) check(c *checker) Type {
 	// Descend on Left.
-	lt, err := n.Left.check(st)
-	if err != nil {
-		return nil, err
-	}
+	n.Type = errorType{}
+	lt := n.Left.check(c)
 	// Descend on Right.
-	rt, err := n.Right.check(st)
-	if err != nil {
-		return nil, err
+	rt := n.Right.check(c)
+	if isError(lt) || isError(rt) {
+		return errorType{}
+	}
+	if c.wholeArray(n.Left, lt, "left operand of binary arithmetic expression") ||
+		c.wholeArray(n.Right, rt, "right operand of binary arithmetic expression") {
+		return errorType{}
 	}
 
 	// Check Left and Right type-match (int or byte).
 	plt, ok := lt.(PrimitiveType)
 	if !ok {
-		return nil, fmt.Errorf("left operand of binary arithmetic expression of non-primitive "+
-			"type %T", lt)
+		return c.errorf(n.Left, CodeType, "left operand of binary arithmetic expression of "+
+			"non-primitive type %q", lt)
 	}
 	prt, ok := rt.(PrimitiveType)
 	if !ok {
-		return nil, fmt.Errorf("right operand of binary arithmetic expression of non-primitive "+
-			"type %T", lt)
+		return c.errorf(n.Right, CodeType, "right operand of binary arithmetic expression of "+
+			"non-primitive type %q", rt)
 	}
 	if plt != prt {
-		return nil, fmt.Errorf("cannot apply binary arithmetic operator to primitive types %T and "+
-			"%T", plt, prt)
+		return c.errorf(n, CodeType, "cannot apply binary arithmetic operator to primitive "+
+			"types %q and %q", plt, prt)
 	}
+	n.Type = plt
 
-	return plt, nil
+	return plt
 }
 
-func (n *ConstCond) check(st *SymTab) (Type, error) {
-	return PrimitiveTypeBool, nil
+func (n *ConstCond) check(c *checker) Type {
+	return PrimitiveTypeBool
 }
 
-func (n *UnCond) check(st *SymTab) (Type, error) {
+func (n *UnCond) check(c *checker) Type {
 	// Descend on condition.
-	if _, err := n.Cond.check(st); err != nil {
-		return nil, err
-	}
+	c.checkCond(n.Cond, "operand of logical negation")
 
-	return PrimitiveTypeBool, nil
+	return PrimitiveTypeBool
 }
 
-func (n *CompCond) check(st *SymTab) (Type, error) {
+func (n *CompCond) check(c *checker) Type {
 	// Descend on Left.
-	if _, err := n.Left.check(st); err != nil {
-		return nil, err
-	}
+	lt := n.Left.check(c)
 	// Descend on Right.
-	if _, err := n.Right.check(st); err != nil {
-		return nil, err
+	rt := n.Right.check(c)
+	if isError(lt) || isError(rt) {
+		return PrimitiveTypeBool
+	}
+	if c.wholeArray(n.Left, lt, "left operand of comparison") ||
+		c.wholeArray(n.Right, rt, "right operand of comparison") {
+		return PrimitiveTypeBool
 	}
 
-	return PrimitiveTypeBool, nil
+	// Check Left and Right type-match (int or byte).
+	plt, ok := lt.(PrimitiveType)
+	if !ok {
+		c.errorf(n.Left, CodeType, "left operand of comparison of non-primitive type %q", lt)
+		return PrimitiveTypeBool
+	}
+	prt, ok := rt.(PrimitiveType)
+	if !ok {
+		c.errorf(n.Right, CodeType, "right operand of comparison of non-primitive type %q", rt)
+		return PrimitiveTypeBool
+	}
+	if plt != prt {
+		c.errorf(n, CodeType, "cannot compare primitive types %q and %q", plt, prt)
+	}
+
+	return PrimitiveTypeBool
 }
 
-func (n *BinCond) check(st *SymTab) (Type, error) {
+func (n *BinCond) check(c *checker) Type {
 	// Descend on Left.
-	if _, err := n.Left.check(st); err != nil {
-		return nil, err
-	}
+	c.checkCond(n.Left, "left operand of logical operator")
 	// Descend on Right.
-	if _, err := n.Right.check(st); err != nil {
-		return nil, err
-	}
+	c.checkCond(n.Right, "right operand of logical operator")
 
-	return PrimitiveTypeBool, nil
+	return PrimitiveTypeBool
 }
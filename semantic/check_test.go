@@ -0,0 +1,535 @@
+package semantic_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/parser"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// check parses and checks src, returning the codes of the diagnostics reported by the checker.
+func check(t *testing.T, src string) []semantic.Code {
+	t.Helper()
+	l := parser.NewLexer("test.alan", strings.NewReader(src))
+	ast, err := parser.Parse(&l)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	err = semantic.Check(ast)
+	if err == nil {
+		return nil
+	}
+	ds, ok := err.(semantic.Diagnostics)
+	if !ok {
+		t.Fatalf("Check() returned %T, want semantic.Diagnostics", err)
+	}
+	codes := make([]semantic.Code, len(ds))
+	for i, d := range ds {
+		codes[i] = d.Code
+	}
+	return codes
+}
+
+func TestCheckMultipleErrors(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	b : byte;
+	x : byte;
+{
+	x = y + 1;
+	y = 2;
+	b = x;
+	writeInteger(b);
+	z(y);
+}`
+	want := []semantic.Code{
+		semantic.CodeRedefined, // x : byte
+		semantic.CodeUndefined, // y (only reported once)
+		semantic.CodeType,      // b = x
+		semantic.CodeType,      // writeInteger(b)
+		semantic.CodeUndefined, // z
+	}
+	got := check(t, src)
+	if len(got) != len(want) {
+		t.Fatalf("Check() reported %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diagnostic #%d has code %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckValid(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	f(n : int) : int
+	{
+		return n + x;
+	}
+{
+	x = 1;
+	writeInteger(f(2));
+}`
+	if got := check(t, src); got != nil {
+		t.Errorf("Check() reported %v, want nothing", got)
+	}
+}
+
+func TestCheckCalls(t *testing.T) {
+	// prog wraps body in a program defining a few variables and user functions to call.
+	prog := func(body string) string {
+		return `main() : proc
+	x : int;
+	b : byte;
+	s : byte[10];
+	f(n : int, c : byte) : int
+	{
+		return n;
+	}
+	g(a : reference byte[]) : proc { }
+	h() : proc { }
+{
+` + body + `
+}`
+	}
+	tests := []struct {
+		name string
+		body string
+		want []semantic.Code
+	}{
+		{"user ok", "x = f(1, 'a'); g(s); h();", nil},
+		{"user too few", "x = f(1);", []semantic.Code{semantic.CodeArity}},
+		{"user too many", "x = f(1, b, 2);", []semantic.Code{semantic.CodeArity}},
+		{"user none expected", "h(x);", []semantic.Code{semantic.CodeArity}},
+		{"user missing all", "g();", []semantic.Code{semantic.CodeArity}},
+		{"user wrong type", "x = f(b, 'a');", []semantic.Code{semantic.CodeType}},
+		{"user array for primitive", "x = f(s, b);", []semantic.Code{semantic.CodeArray}},
+		{"user primitive for array", "g(b);", []semantic.Code{semantic.CodeType}},
+		{"user too many and wrong type", "x = f(b, b, b);",
+			[]semantic.Code{semantic.CodeArity, semantic.CodeType}},
+		{"user not a function", "x(1);", []semantic.Code{semantic.CodeKind}},
+		{"user procedure in expression", "x = h();", []semantic.Code{semantic.CodeType}},
+		{"stdlib ok", `writeInteger(x); writeString("hi"); readString(10, s); x = strlen(s);`,
+			nil},
+		{"stdlib too few", "readString(10);", []semantic.Code{semantic.CodeArity}},
+		{"stdlib too many", "writeInteger(x, x);", []semantic.Code{semantic.CodeArity}},
+		{"stdlib none expected", "x = readInteger(1);", []semantic.Code{semantic.CodeArity}},
+		{"stdlib wrong type", "writeByte(x);", []semantic.Code{semantic.CodeType}},
+		{"stdlib by reference", "writeString(s[0]);", []semantic.Code{semantic.CodeType}},
+		{"undefined", "k(1, 2);", []semantic.Code{semantic.CodeUndefined}},
+	}
+	for _, test := range tests {
+		got := check(t, prog(test.body))
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: Check() reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckArityMessage(t *testing.T) {
+	src := `main() : proc
+	f(n : int) : proc { }
+{
+	f(1, 2);
+}`
+	l := parser.NewLexer("test.alan", strings.NewReader(src))
+	ast, err := parser.Parse(&l)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	want := "test.alan:4:2: error: \"f\" called with 2 argument(s), want 1 [arity]\n" +
+		"test.alan:2:2: note: \"f\" defined here"
+	if err := semantic.Check(ast); err == nil || err.Error() != want {
+		t.Errorf("Check() = %v, want %s", err, want)
+	}
+}
+
+func TestCheckConds(t *testing.T) {
+	prog := func(body string) string {
+		return `main() : proc
+	x : int;
+	b : byte;
+	s : byte[10];
+{
+` + body + `
+}`
+	}
+	tests := []struct {
+		name string
+		body string
+		want []semantic.Code
+	}{
+		{"ok", "if (x == 1 & b < 'a' | !(x >= x)) x = 1; while (b != b) b = 'a';", nil},
+		{"int and byte", "if (x == b) x = 1;", []semantic.Code{semantic.CodeType}},
+		{"byte and int", "while (b < 1) x = 1;", []semantic.Code{semantic.CodeType}},
+		{"string literal", `if (x == "abc") x = 1;`, []semantic.Code{semantic.CodeType}},
+		{"array left", "if (s > x) x = 1;", []semantic.Code{semantic.CodeArray}},
+		{"in logical operator", "if (true & x <= b | b >= x) x = 1;",
+			[]semantic.Code{semantic.CodeType, semantic.CodeType}},
+		{"in negation", "if (!(b == x)) x = 1; else x = 2;", []semantic.Code{semantic.CodeType}},
+		{"undefined operand", "if (y == 1 | y > b) x = 1;", []semantic.Code{semantic.CodeUndefined}},
+	}
+	for _, test := range tests {
+		got := check(t, prog(test.body))
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: Check() reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckFlow(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []semantic.Code
+	}{
+		{"returns", `main() : proc
+	f(x : int) : int
+	{
+		if (x > 0) return 1;
+		else { return 2; }
+	}
+	g() : int { while (true) ; }
+	h() : proc { return; }
+{
+}`, nil},
+		{"missing return", `main() : proc
+	f(x : int) : int
+	{
+		if (x > 0) return 1;
+	}
+	g(x : int) : int
+	{
+		while (x > 0) return x;
+	}
+{
+}`, []semantic.Code{semantic.CodeReturn, semantic.CodeReturn}},
+		{"empty function", "main() : proc\n\tf() : byte { }\n{\n}",
+			[]semantic.Code{semantic.CodeReturn}},
+		{"missing return value", "main() : proc\n\tf() : int { return; }\n{\n}",
+			[]semantic.Code{semantic.CodeReturn}},
+		{"return value from proc", "main() : proc\n{\n\treturn 1;\n}",
+			[]semantic.Code{semantic.CodeReturn}},
+		{"unreachable", `main() : proc
+	f(x : int) : int
+	{
+		if (x > 0) {
+			return 1;
+			x = 2;
+			x = 3;
+		} else return 2;
+		return 3;
+	}
+{
+	return;
+	writeInteger(1);
+}`, []semantic.Code{semantic.CodeUnreachable, semantic.CodeUnreachable,
+			semantic.CodeUnreachable}},
+	}
+	for _, test := range tests {
+		got := check(t, test.src)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: Check() reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckArrays(t *testing.T) {
+	prog := func(body string) string {
+		return `main() : proc
+	x : int;
+	s : byte[10];
+	t : byte[10];
+	f(a : reference byte[], n : int) : proc
+	{
+		a = a;
+		n = -a + 1;
+	}
+{
+` + body + `
+}`
+	}
+	tests := []struct {
+		name string
+		body string
+		want []semantic.Code
+	}{
+		{"elements", "s[0] = t[1]; x = x + extend(s[2]); if (s[0] < t[0]) f(s, 1);", nil},
+		{"assign", "s = t;", []semantic.Code{semantic.CodeArray}},
+		{"assign to element", "s[0] = t;", []semantic.Code{semantic.CodeArray}},
+		{"arithmetic", "x = 1 + s * 2;", []semantic.Code{semantic.CodeArray}},
+		{"comparison", "if (s[0] == t) x = 1;", []semantic.Code{semantic.CodeArray}},
+		{"by value argument", "x = extend(s);", []semantic.Code{semantic.CodeArray}},
+	}
+	for _, test := range tests {
+		// f's parameter "a" is used as a whole in its body, in an assignment and an arithmetic
+		// expression.
+		want := append([]semantic.Code{semantic.CodeArray, semantic.CodeArray}, test.want...)
+		got := check(t, prog(test.body))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: Check() reported %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+func TestCheckByValueArrayParameter(t *testing.T) {
+	src := `main() : proc
+	s : byte[10];
+	f(a : byte[], b : byte[]) : byte
+	{
+		return a[0];
+	}
+{
+	s[0] = f(s, s);
+}`
+	want := []semantic.Code{semantic.CodeByRef, semantic.CodeByRef}
+	if got := check(t, src); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Check() reported %v, want %v", got, want)
+	}
+}
+
+func TestCheckMissingReturnLocation(t *testing.T) {
+	src := "main() : proc\n\tf() : int\n\t{\n\t}\n{\n}"
+	l := parser.NewLexer("test.alan", strings.NewReader(src))
+	ast, err := parser.Parse(&l)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	want := "test.alan:4:2: error: missing return at end of function \"f\" [return]"
+	if err := semantic.Check(ast); err == nil || err.Error() != want {
+		t.Errorf("Check() = %v, want %s", err, want)
+	}
+}
+
+func TestCheckMessages(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	s : byte[10];
+	f(a : reference byte[]) : int { return 0; }
+{
+	x = s;
+	x = f(x);
+	if (x == 'a') return;
+}`
+	l := parser.NewLexer("test.alan", strings.NewReader(src))
+	ast, err := parser.Parse(&l)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	err = semantic.Check(ast)
+	ds, ok := err.(semantic.Diagnostics)
+	if !ok {
+		t.Fatalf("Check() returned %T (%v), want semantic.Diagnostics", err, err)
+	}
+	want := []string{
+		`whole array "s" used as assigned value`,
+		`argument #1 to "f" has type "int", want "reference byte []"`,
+		`cannot compare primitive types "int" and "byte"`,
+	}
+	if len(ds) != len(want) {
+		t.Fatalf("Check() reported %v, want %d errors", ds, len(want))
+	}
+	for i, d := range ds {
+		if d.Msg != want[i] {
+			t.Errorf("error #%d is %q, want %q", i+1, d.Msg, want[i])
+		}
+	}
+}
+
+func TestCheckAnnotations(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	s : byte[10];
+	f(n : int, a : reference byte[]) : byte
+	{
+		return a[n];
+	}
+{
+	x = strlen(s) + 1;
+	s[0] = f(x, s);
+}`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+
+	main := ast.Program
+	x := main.LDefs[0].(*semantic.PrimVarDef)
+	s := main.LDefs[1].(*semantic.ArrayDef)
+	f := main.LDefs[2].(*semantic.FuncDef)
+	ret := f.Stmts[0].(*semantic.ReturnStmt).Expr.(*semantic.ArrayElem)
+	as1 := main.Stmts[0].(*semantic.AssignStmt)
+	sum := as1.Right.(*semantic.BinArithExpr)
+	strlen := sum.Left.(*semantic.FuncCallExpr)
+	as2 := main.Stmts[1].(*semantic.AssignStmt)
+	call := as2.Right.(*semantic.FuncCallExpr)
+
+	decls := []struct {
+		name string
+		got  semantic.Node
+		want semantic.Node
+	}{
+		{"x", as1.Left.(*semantic.VarRef).Decl, x},
+		{"s[0]", as2.Left.(*semantic.ArrayElem).Decl, s},
+		{"a[n]", ret.Decl, &f.Parameters[1]},
+		{"n", ret.Index.(*semantic.VarRef).Decl, &f.Parameters[0]},
+		{"f(x, s)", call.Decl, f},
+		{"s (argument)", call.Args[1].(*semantic.VarRef).Decl, s},
+	}
+	for _, d := range decls {
+		if d.got != d.want {
+			t.Errorf("%s: Decl = %v, want %v", d.name, d.got, d.want)
+		}
+	}
+	if !semantic.IsStdlib(strlen.Decl) || strlen.Decl.ID != "strlen" {
+		t.Errorf("strlen(s): Decl = %+v, want the standard library definition", strlen.Decl)
+	}
+	if semantic.IsStdlib(call.Decl) {
+		t.Errorf("f(x, s): Decl is a standard library definition")
+	}
+
+	types := []struct {
+		name string
+		semantic.Expr
+		want semantic.DType
+	}{
+		{"strlen(s) + 1", sum, semantic.PrimitiveTypeInt},
+		{"strlen(s)", strlen, semantic.PrimitiveTypeInt},
+		{"s[0]", as2.Left, semantic.PrimitiveTypeByte},
+		{"f(x, s)", call, semantic.PrimitiveTypeByte},
+		{"s", call.Args[1], semantic.ArrayType{PrimitiveType: semantic.PrimitiveTypeByte, Size: 10}},
+		{"a[n]", ret, semantic.PrimitiveTypeByte},
+	}
+	for _, test := range types {
+		if got := test.DataType(); got != test.want {
+			t.Errorf("%s: DataType() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	tests := []struct {
+		target string
+		expr   string
+		ok     bool
+	}{
+		{target: "int32", expr: "2147483647", ok: true},
+		{target: "int32", expr: "2147483648", ok: false},
+		{target: "int32", expr: "-2147483648", ok: true},
+		{target: "int32", expr: "-2147483649", ok: false},
+		{target: "int32", expr: "99999999999999999999999", ok: false},
+		{target: "int16", expr: "32767", ok: true},
+		{target: "int16", expr: "32768", ok: false},
+		{target: "int16", expr: "-32768", ok: true},
+		{target: "int16", expr: "-32769", ok: false},
+		{target: "int16", expr: "1 + 70000", ok: false},
+	}
+	for _, test := range tests {
+		src := fmt.Sprintf("main() : proc\n\tx : int;\n{\n\tx = %s;\n}\n", test.expr)
+		l := parser.NewLexer("test.alan", strings.NewReader(src))
+		ast, err := parser.Parse(&l)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.expr, err)
+		}
+		ast.Target = semantic.Targets[test.target]
+		err = semantic.Check(ast)
+		if test.ok {
+			if err != nil {
+				t.Errorf("Check(%q) for %s failed: %v", test.expr, test.target, err)
+			}
+			continue
+		}
+		ds, ok := err.(semantic.Diagnostics)
+		if !ok || len(ds) != 1 || ds[0].Code != semantic.CodeRange {
+			t.Errorf("Check(%q) for %s = %v, want a single %q error", test.expr, test.target, err,
+				semantic.CodeRange)
+		}
+	}
+}
+
+func TestCheckBounds(t *testing.T) {
+	src := `main() : proc
+	a : int [4];
+	z : byte [0];
+	f(x : reference int []) : proc
+	{
+		x[100] = 1;
+		x[-1] = 1;
+	}
+	i : int;
+{
+	a[0] = a[3];
+	a[4] = 1;
+	a[-1] = 1;
+	a[2 * 2] = 1;
+	a[7 / 2] = 1;
+	a[i + 4] = 1;
+	a[1 / 0] = 1;
+}`
+	want := []semantic.Code{
+		semantic.CodeSize,   // z : byte [0]
+		semantic.CodeBounds, // x[-1]
+		semantic.CodeBounds, // a[4]
+		semantic.CodeBounds, // a[-1]
+		semantic.CodeBounds, // a[2 * 2]
+	}
+	got := check(t, src)
+	if len(got) != len(want) {
+		t.Fatalf("Check() reported %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diagnostic #%d has code %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckStringLiterals(t *testing.T) {
+	// prog wraps body in a program defining functions reading and writing their array parameters.
+	prog := func(body string) string {
+		return `main() : proc
+	s : byte[10];
+	r(a : reference byte[]) : proc { writeString(a); }
+	w(a : reference byte[]) : proc { a[0] = 'x'; }
+	pw(a : reference byte[]) : proc { w(a); }
+	nw(a : reference byte[]) : proc
+		g() : proc { strcpy(a, "x"); }
+	{
+		g();
+	}
+	rec(a : reference byte[], n : int) : proc
+	{
+		if (n > 0) rec(a, n - 1);
+		else readString(2, a);
+	}
+{
+` + body + `
+}`
+	}
+	tests := []struct {
+		name string
+		body string
+		want []semantic.Code
+	}{
+		{"stdlib reads", `writeString("hi"); s[0] = shrink(strlen("hi") + strcmp("a", "b"));`, nil},
+		{"stdlib copy from", `strcpy(s, "hi"); strcat(s, "!");`, nil},
+		{"assign", `"hi" = s;`, []semantic.Code{semantic.CodeReadOnly}},
+		{"assign undefined", `"hi" = y;`,
+			[]semantic.Code{semantic.CodeReadOnly, semantic.CodeUndefined}},
+		{"readString", `readString(10, "abc");`, []semantic.Code{semantic.CodeReadOnly}},
+		{"strcpy", `strcpy("abc", s);`, []semantic.Code{semantic.CodeReadOnly}},
+		{"strcat", `strcat("abc", s);`, []semantic.Code{semantic.CodeReadOnly}},
+		{"user reads", `r("abc");`, nil},
+		{"user writes", `w("abc");`, []semantic.Code{semantic.CodeReadOnly}},
+		{"user passes on", `pw("abc");`, []semantic.Code{semantic.CodeReadOnly}},
+		{"user nested writes", `nw("abc");`, []semantic.Code{semantic.CodeReadOnly}},
+		{"user recursive", `rec("abc", 3);`, []semantic.Code{semantic.CodeReadOnly}},
+	}
+	for _, test := range tests {
+		got := check(t, prog(test.body))
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: Check() reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
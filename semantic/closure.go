@@ -0,0 +1,200 @@
+package semantic
+
+import "sort"
+
+// Closure analysis. A nested function may reference variables of the functions enclosing it (Pascal
+// scope), so it can't run without access to them: backends must either pass it a static link to
+// the enclosing frames, or lift it to the top level passing it the variables themselves. This
+// analysis provides what either needs. It relies on the declarations resolved by the checker, so it
+// can only be run on a checked AST.
+
+// Closure is the result of the closure analysis of a function.
+type Closure struct {
+	// Def is the function's definition.
+	Def *FuncDef
+	// Parent is the closure of the function this is nested in (nil for main).
+	Parent *Closure
+	// Depth is the function's static nesting depth (0 for main).
+	Depth int
+	// Free are the variables of enclosing functions the function captures, by the function
+	// declaring them, each in order of declaration. These include not only the variables the
+	// function references directly, but also those captured by any functions it calls (as they
+	// have to be passed along when lifting). Variables are *ParDef, *PrimVarDef or *ArrayDef.
+	Free map[*FuncDef][]Node
+}
+
+// Captures returns all of c's free variables, those of the outermost function first.
+func (c *Closure) Captures() []Node {
+	var outer []*FuncDef
+	for p := c.Parent; p != nil; p = p.Parent {
+		outer = append(outer, p.Def)
+	}
+	var fvs []Node
+	for i := len(outer) - 1; i >= 0; i-- {
+		fvs = append(fvs, c.Free[outer[i]]...)
+	}
+	return fvs
+}
+
+// closureAnalysis holds the state of the closure analysis.
+type closureAnalysis struct {
+	// closures are the closures of all functions, by definition.
+	closures map[*FuncDef]*Closure
+	// owners are the functions declaring each variable.
+	owners map[Node]*FuncDef
+	// order are the indices of variables in the order of declaration in their function.
+	order map[Node]int
+	// free are the sets of free variables of each function.
+	free map[*FuncDef]map[Node]bool
+	// calls are the (user) functions each function calls.
+	calls map[*FuncDef][]*FuncDef
+}
+
+// AnalyzeClosures performs the closure analysis on ast, returning the closure of every function in
+// it, by definition. ast must have passed the semantic checks.
+func AnalyzeClosures(ast *Ast) map[*FuncDef]*Closure {
+	ca := &closureAnalysis{
+		closures: map[*FuncDef]*Closure{},
+		owners:   map[Node]*FuncDef{},
+		order:    map[Node]int{},
+		free:     map[*FuncDef]map[Node]bool{},
+		calls:    map[*FuncDef][]*FuncDef{},
+	}
+	ca.funcDef(ast.Program, nil)
+
+	// Propagate free variables from callees to callers, until nothing changes (calls may be
+	// recursive).
+	for changed := true; changed; {
+		changed = false
+		for f, callees := range ca.calls {
+			for _, g := range callees {
+				for v := range ca.free[g] {
+					if ca.owners[v] != f && !ca.free[f][v] {
+						ca.free[f][v] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	for f, c := range ca.closures {
+		for v := range ca.free[f] {
+			owner := ca.owners[v]
+			c.Free[owner] = append(c.Free[owner], v)
+		}
+		for _, fvs := range c.Free {
+			sort.Slice(fvs, func(i, j int) bool { return ca.order[fvs[i]] < ca.order[fvs[j]] })
+		}
+	}
+	return ca.closures
+}
+
+// funcDef analyzes the function defined by def (and any functions nested in it). parent is the
+// closure of the function def is nested in (nil for main).
+func (ca *closureAnalysis) funcDef(def *FuncDef, parent *Closure) {
+	c := &Closure{
+		Def:    def,
+		Parent: parent,
+		Free:   map[*FuncDef][]Node{},
+	}
+	if parent != nil {
+		c.Depth = parent.Depth + 1
+	}
+	ca.closures[def] = c
+	ca.free[def] = map[Node]bool{}
+
+	for i := range def.Parameters {
+		ca.declare(&def.Parameters[i], def)
+	}
+	for _, ld := range def.LDefs {
+		switch ld := ld.(type) {
+		case *FuncDef:
+			ca.funcDef(ld, c)
+		case *PrimVarDef, *ArrayDef:
+			ca.declare(ld, def)
+		}
+	}
+	ca.stmt(&def.CompStmt, def)
+}
+
+// declare records variable decl as declared by owner.
+func (ca *closureAnalysis) declare(decl Node, owner *FuncDef) {
+	ca.order[decl] = len(ca.order)
+	ca.owners[decl] = owner
+}
+
+// stmt records the variables referenced and the functions called in s, part of the body of f.
+func (ca *closureAnalysis) stmt(s Stmt, f *FuncDef) {
+	switch s := s.(type) {
+	case *CompStmt:
+		for _, s := range s.Stmts {
+			ca.stmt(s, f)
+		}
+	case *AssignStmt:
+		ca.expr(s.Left, f)
+		ca.expr(s.Right, f)
+	case *FuncCallStmt:
+		ca.call(&s.FuncCall, f)
+	case *IfStmt:
+		ca.expr(s.Cond, f)
+		ca.stmt(s.Stmt, f)
+	case *IfElseStmt:
+		ca.expr(s.Cond, f)
+		ca.stmt(s.Stmt1, f)
+		ca.stmt(s.Stmt2, f)
+	case *WhileStmt:
+		ca.expr(s.Cond, f)
+		ca.stmt(s.Stmt, f)
+	case *ReturnStmt:
+		if s.Expr != nil {
+			ca.expr(s.Expr, f)
+		}
+	}
+}
+
+// expr records the variables referenced and the functions called in e, part of the body of f.
+func (ca *closureAnalysis) expr(e Expr, f *FuncDef) {
+	switch e := e.(type) {
+	case *VarRef:
+		ca.ref(e.Decl, f)
+	case *ArrayElem:
+		ca.ref(e.Decl, f)
+		ca.expr(e.Index, f)
+		if e.Bound != nil {
+			ca.expr(e.Bound, f)
+		}
+	case *FuncCallExpr:
+		ca.call(&e.FuncCall, f)
+	case *UnArithExpr:
+		ca.expr(e.Expr, f)
+	case *BinArithExpr:
+		ca.expr(e.Left, f)
+		ca.expr(e.Right, f)
+	case *UnCond:
+		ca.expr(e.Cond, f)
+	case *CompCond:
+		ca.expr(e.Left, f)
+		ca.expr(e.Right, f)
+	case *BinCond:
+		ca.expr(e.Left, f)
+		ca.expr(e.Right, f)
+	}
+}
+
+// ref records a reference to the variable declared by decl from the body of f.
+func (ca *closureAnalysis) ref(decl Node, f *FuncDef) {
+	if ca.owners[decl] != f {
+		ca.free[f][decl] = true
+	}
+}
+
+// call records the function call c (and the references in its arguments) from the body of f.
+func (ca *closureAnalysis) call(c *FuncCall, f *FuncDef) {
+	if !IsStdlib(c.Decl) {
+		ca.calls[f] = append(ca.calls[f], c.Decl)
+	}
+	for _, a := range c.Args {
+		ca.expr(a, f)
+	}
+}
@@ -0,0 +1,87 @@
+package semantic_test
+
+import (
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestAnalyzeClosures(t *testing.T) {
+	src := `main() : proc
+	a : int;
+	b : byte[4];
+	f(x : int) : proc
+		g() : proc
+		{
+			a = x;
+		}
+		c : int;
+	{
+		c = 0;
+		g();
+	}
+	h() : proc
+	{
+		f(1);
+		b[0] = 'x';
+		h();
+	}
+	k : int;
+{
+	k = 0;
+	h();
+}`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	closures := semantic.AnalyzeClosures(ast)
+
+	main := ast.Program
+	a := main.LDefs[0]
+	b := main.LDefs[1]
+	f := main.LDefs[2].(*semantic.FuncDef)
+	x := &f.Parameters[0]
+	g := f.LDefs[0].(*semantic.FuncDef)
+	h := main.LDefs[3].(*semantic.FuncDef)
+
+	tests := []struct {
+		*semantic.FuncDef
+		parent *semantic.FuncDef
+		depth  int
+		want   []semantic.Node
+	}{
+		{main, nil, 0, nil},
+		{f, main, 1, []semantic.Node{a}},
+		{g, f, 2, []semantic.Node{a, x}},
+		{h, main, 1, []semantic.Node{a, b}},
+	}
+	if len(closures) != len(tests) {
+		t.Errorf("AnalyzeClosures() returned %d closures, want %d", len(closures), len(tests))
+	}
+	for _, test := range tests {
+		c, ok := closures[test.FuncDef]
+		if !ok {
+			t.Errorf("%s: no closure", test.ID)
+			continue
+		}
+		if c.Depth != test.depth {
+			t.Errorf("%s: Depth = %d, want %d", test.ID, c.Depth, test.depth)
+		}
+		if (c.Parent == nil && test.parent != nil) ||
+			(c.Parent != nil && c.Parent.Def != test.parent) {
+			t.Errorf("%s: wrong Parent", test.ID)
+		}
+		got := c.Captures()
+		if len(got) != len(test.want) {
+			t.Errorf("%s: Captures() = %v, want %v", test.ID, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: Captures()[%d] = %v, want %v", test.ID, i, got[i], test.want[i])
+			}
+		}
+	}
+	if fvs := closures[g].Free[f]; len(fvs) != 1 || fvs[0] != x {
+		t.Errorf("g: Free[f] = %v, want [x]", fvs)
+	}
+}
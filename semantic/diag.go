@@ -0,0 +1,202 @@
+package semantic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// SeverityError is the severity of errors (the program is rejected).
+	SeverityError Severity = iota
+	// SeverityWarning is the severity of warnings (the program is accepted).
+	SeverityWarning
+	// SeverityNote is the severity of notes (additional information on another diagnostic).
+	SeverityNote
+)
+
+const (
+	// CodeLex is the code of lexical errors.
+	CodeLex Code = "lex"
+	// CodeSyntax is the code of syntax errors.
+	CodeSyntax Code = "syntax"
+	// CodeUndefined is the code of references to undefined identifiers.
+	CodeUndefined Code = "undefined"
+	// CodeRedefined is the code of redefinitions of identifiers in the same scope.
+	CodeRedefined Code = "redefined"
+	// CodeKind is the code of references to identifiers of the wrong kind (e.g. calling a
+	// variable).
+	CodeKind Code = "kind"
+	// CodeType is the code of type mismatches.
+	CodeType Code = "type"
+	// CodeArity is the code of function calls with the wrong number of arguments.
+	CodeArity Code = "arity"
+	// CodeByRef is the code of invalid arguments to parameters passed by reference.
+	CodeByRef Code = "by-ref"
+	// CodeArray is the code of whole arrays used as values (e.g. in arithmetic or assignments).
+	CodeArray Code = "array"
+	// CodeReadOnly is the code of writes to string literals.
+	CodeReadOnly Code = "read-only"
+	// CodeMain is the code of invalid main function definitions.
+	CodeMain Code = "main"
+	// CodeReturn is the code of invalid return statements.
+	CodeReturn Code = "return"
+	// CodeRange is the code of integer constants out of the range of int.
+	CodeRange Code = "range"
+	// CodeSize is the code of invalid array sizes.
+	CodeSize Code = "size"
+	// CodeBounds is the code of constant array indices out of bounds.
+	CodeBounds Code = "bounds"
+	// CodeDivZero is the code of divisions by a constant zero.
+	CodeDivZero Code = "div-zero"
+	// CodeUnreachable is the code of statements that can never be executed.
+	CodeUnreachable Code = "unreachable"
+	// CodeNote is the code of notes.
+	CodeNote Code = "note"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Code identifies the kind of a diagnostic.
+type Code string
+
+// Diagnostic is a message from the compiler concerning a specific location in the source.
+type Diagnostic struct {
+	// Severity is the diagnostic's severity.
+	Severity
+	// Code identifies the kind of the diagnostic.
+	Code
+	// Span is the location the diagnostic refers to.
+	Span Span
+	// Msg is the diagnostic's message.
+	Msg string
+	// Related are notes concerning related locations (e.g. a previous definition).
+	Related []*Diagnostic
+}
+
+// Errorf returns an error diagnostic of kind code for span, with the message formatted according
+// to format.
+func Errorf(span Span, code Code, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Span:     span,
+		Msg:      fmt.Sprintf(format, a...),
+	}
+}
+
+// Note returns a note for span, with the message formatted according to format.
+func Note(span Span, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: SeverityNote,
+		Code:     CodeNote,
+		Span:     span,
+		Msg:      fmt.Sprintf(format, a...),
+	}
+}
+
+func (d *Diagnostic) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: %v: %s", d.Span.Start, d.Severity, d.Msg)
+	if d.Severity != SeverityNote {
+		fmt.Fprintf(&b, " [%s]", d.Code)
+	}
+	for _, r := range d.Related {
+		fmt.Fprintf(&b, "\n%v", r)
+	}
+	return b.String()
+}
+
+// Render writes d (and its related notes) to w, along with the source line it refers to (taken from
+// src, the contents of the source file) and a marker underlining the offending part of it, e.g.:
+//
+//	test.alan:4:6: error: "y" not defined [undefined]
+//		x = y + 1;
+//		    ^
+func (d *Diagnostic) Render(w io.Writer, src []byte) {
+	if d.Severity == SeverityNote {
+		fmt.Fprintf(w, "%v: %v: %s\n", d.Span.Start, d.Severity, d.Msg)
+	} else {
+		fmt.Fprintf(w, "%v: %v: %s [%s]\n", d.Span.Start, d.Severity, d.Msg, d.Code)
+	}
+	if line, ok := sourceLine(src, d.Span.Start); ok {
+		fmt.Fprintf(w, "%s\n%s\n", line, marker(line, d.Span))
+	}
+	for _, r := range d.Related {
+		r.Render(w, src)
+	}
+}
+
+// Diagnostics is a list of diagnostics, reported together.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	msgs := make([]string, len(ds))
+	for i, d := range ds {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Render renders each of ds in turn (see Diagnostic.Render).
+func (ds Diagnostics) Render(w io.Writer, src []byte) {
+	for _, d := range ds {
+		d.Render(w, src)
+	}
+}
+
+// sourceLine returns the line of src containing p (without the trailing newline). It returns false
+// if p is not in src.
+func sourceLine(src []byte, p Pos) ([]byte, bool) {
+	if p.Line < 1 || p.Offset < 0 || p.Offset > len(src) {
+		return nil, false
+	}
+	start := bytes.LastIndexByte(src[:p.Offset], '\n') + 1
+	end := bytes.IndexByte(src[p.Offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += p.Offset
+	}
+	return src[start:end], true
+}
+
+// marker returns a line underlining the part of line (the source line containing the start of
+// span) covered by span, with a caret at its start. Tabs in line are preserved, to keep things
+// aligned.
+func marker(line []byte, span Span) string {
+	var b strings.Builder
+	col := span.Start.Col - 1
+	for i := 0; i < col && i < len(line); i++ {
+		if line[i] == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	// Underline up to the end of the span, or of the line if the span extends beyond it.
+	end := len(line)
+	if span.End.Line == span.Start.Line {
+		end = span.End.Col - 1
+	}
+	for i := col + 1; i < end && i < len(line); i++ {
+		b.WriteByte('~')
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticRender(t *testing.T) {
+	src := []byte("main() : proc\n\tx : int;\n{\n\tx = y + 1;\n}\n")
+	d := Errorf(Span{
+		Start: Pos{File: "test.alan", Line: 4, Col: 6, Offset: 31},
+		End:   Pos{File: "test.alan", Line: 4, Col: 7, Offset: 32},
+	}, CodeUndefined, "%q not defined", "y")
+	d.Related = append(d.Related, Note(Span{
+		Start: Pos{File: "test.alan", Line: 2, Col: 2, Offset: 15},
+		End:   Pos{File: "test.alan", Line: 2, Col: 10, Offset: 23},
+	}, "did you mean %q?", "x"))
+
+	want := "test.alan:4:6: error: \"y\" not defined [undefined]\n" +
+		"\tx = y + 1;\n" +
+		"\t    ^\n" +
+		"test.alan:2:2: note: did you mean \"x\"?\n" +
+		"\tx : int;\n" +
+		"\t^~~~~~~~\n"
+	var b strings.Builder
+	d.Render(&b, src)
+	if got := b.String(); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	wantErr := "test.alan:4:6: error: \"y\" not defined [undefined]\n" +
+		"test.alan:2:2: note: did you mean \"x\"?"
+	if got := d.Error(); got != wantErr {
+		t.Errorf("Error() = %q, want %q", got, wantErr)
+	}
+}
+
+func TestDiagnosticRenderOutOfSource(t *testing.T) {
+	// Diagnostics pointing outside of the source are rendered without it.
+	d := Errorf(Span{
+		Start: Pos{Line: 10, Col: 1, Offset: 100},
+		End:   Pos{Line: 10, Col: 1, Offset: 100},
+	}, CodeSyntax, "syntax error")
+	want := "10:1: error: syntax error [syntax]\n"
+	var b strings.Builder
+	d.Render(&b, []byte("main() : proc {}\n"))
+	if got := b.String(); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
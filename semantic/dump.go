@@ -0,0 +1,118 @@
+package semantic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// dumper writes an AST as a tree, for debugging.
+type dumper struct {
+	w *bufio.Writer
+}
+
+// Dump writes ast to w as a tree: one line per node (its kind, identifier and location), with its
+// fields below it, indented by two spaces per level. Nil fields and empty lists are omitted, and
+// declarations resolved by the checker are shown by kind, identifier and location only. Unlike
+// Fprint, this shows the AST as is (e.g. any annotations of the checker).
+func Dump(w io.Writer, ast *Ast) error {
+	d := &dumper{w: bufio.NewWriter(w)}
+	d.value("", reflect.ValueOf(ast.Program), 0)
+	return d.w.Flush()
+}
+
+// line writes a line at indentation indent, formatted according to format.
+func (d *dumper) line(indent int, format string, a ...interface{}) {
+	fmt.Fprintf(d.w, "%s%s\n", strings.Repeat("  ", indent), fmt.Sprintf(format, a...))
+}
+
+// header returns the description of node n (a struct): its kind, its identifier (if any) and its
+// location (if known, e.g. not for standard library functions).
+func header(n reflect.Value) string {
+	h := n.Type().Name()
+	if id := n.FieldByName("ID"); id.IsValid() {
+		h += fmt.Sprintf(" %q", id.String())
+	}
+	if span := n.FieldByName("Span"); span.IsValid() {
+		if start := span.Interface().(Span).Start; start.Line > 0 {
+			h += fmt.Sprintf(" @%d:%d", start.Line, start.Col)
+		}
+	}
+	return h
+}
+
+// fields writes the fields of n (a struct) at indentation indent. Embedded structs (e.g. the
+// CompStmt of a FuncDef) have their fields written in place of them.
+func (d *dumper) fields(n reflect.Value, indent int) {
+	for i := 0; i < n.NumField(); i++ {
+		f := n.Type().Field(i)
+		fv := n.Field(i)
+		switch {
+		case f.Name == "ID" || f.Name == "Span":
+			// Part of the header.
+		case f.Anonymous && fv.Kind() == reflect.Struct && !isStringer(fv):
+			d.fields(fv, indent)
+		case f.Name == "Decl":
+			if !fv.IsNil() {
+				d.line(indent, "Decl: %s", header(deref(fv)))
+			}
+		default:
+			d.value(f.Name, fv, indent)
+		}
+	}
+}
+
+// value writes v, the value of the field called name (or a list element, if name is empty), at
+// indentation indent.
+func (d *dumper) value(name string, v reflect.Value, indent int) {
+	prefix := ""
+	if name != "" {
+		prefix = name + ": "
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return
+		}
+		d.line(indent, "%s", name+":")
+		for i := 0; i < v.Len(); i++ {
+			d.value("", v.Index(i), indent+1)
+		}
+		return
+	}
+	if isStringer(v) {
+		d.line(indent, "%s%v", prefix, v.Interface())
+		return
+	}
+	v = deref(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		d.line(indent, "%s%s", prefix, header(v))
+		d.fields(v, indent+1)
+	case reflect.Int32, reflect.Uint8, reflect.String:
+		// Operators, signs and character or string constants.
+		d.line(indent, "%s%q", prefix, v.Interface())
+	default:
+		d.line(indent, "%s%v", prefix, v.Interface())
+	}
+}
+
+// isStringer returns whether v can render itself (e.g. types).
+func isStringer(v reflect.Value) bool {
+	_, ok := v.Interface().(fmt.Stringer)
+	return ok
+}
+
+// deref returns the value v points to (through any number of pointers and interfaces).
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,75 @@
+package semantic_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestDump(t *testing.T) {
+	src := `main() : proc
+	s : byte [4];
+	f(a : reference byte []) : byte { return a[-0 + 1]; }
+{
+	if (s[0] == 'a' | true) writeByte(f(s));
+}`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	var b strings.Builder
+	if err := semantic.Dump(&b, ast); err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	want := `FuncDef "main" @1:1
+  LDefs:
+    ArrayDef "s" @2:2
+      Type: byte [4]
+    FuncDef "f" @3:2
+      Parameters:
+        ParDef "a" @3:4
+          Type: reference byte []
+      RType: byte
+      Stmts:
+        ReturnStmt @3:36
+          Expr: ArrayElem "a" @3:43
+            Index: BinArithExpr @3:45
+              Left: UnArithExpr @3:45
+                Sign: '-'
+                Expr: IntConstExpr @3:46
+                  Val: 0
+              Op: '+'
+              Right: IntConstExpr @3:50
+                Val: 1
+              Type: int
+            Decl: ParDef "a" @3:4
+            Type: byte
+  Stmts:
+    IfStmt @5:2
+      Cond: BinCond @5:6
+        Left: CompCond @5:6
+          Left: ArrayElem "s" @5:6
+            Index: IntConstExpr @5:8
+              Val: 0
+            Decl: ArrayDef "s" @2:2
+            Type: byte
+          Op: "=="
+          Right: CharConstExpr @5:14
+            Val: 'a'
+        Op: '|'
+        Right: ConstCond @5:20
+          Val: true
+      Stmt: FuncCallStmt "writeByte" @5:26
+        Args:
+          FuncCallExpr "f" @5:36
+            Args:
+              VarRef "s" @5:38
+                Decl: ArrayDef "s" @2:2
+                Type: byte [4]
+            Decl: FuncDef "f" @3:2
+            Type: byte
+        Decl: FuncDef "writeByte"
+`
+	if got := b.String(); got != want {
+		t.Errorf("Dump() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package semantic
+
+// Control-flow analysis. Since Alan has no jumps other than return (i.e. no break, goto, etc.),
+// this is done directly on the AST, by determining whether each statement may complete normally
+// (i.e. let control flow to the statement after it) or always returns.
+
+// checkFlow performs the control-flow checks on the body of the function defined by n: that there
+// is no unreachable code in it and that it doesn't reach its end without returning (if it's not a
+// proc).
+func (c *checker) checkFlow(n *FuncDef) {
+	if c.returns(&n.CompStmt) || n.RType == nil {
+		return
+	}
+	// Point at the closing brace of the body.
+	end := n.CompStmt.Span.End
+	brace := end
+	brace.Col--
+	brace.Offset--
+	c.diags = append(c.diags, Errorf(Span{Start: brace, End: end}, CodeReturn,
+		"missing return at end of function %q", n.ID))
+}
+
+// returns returns whether s always returns (i.e. it never completes normally). Unreachable
+// statements found in s are reported.
+func (c *checker) returns(s Stmt) bool {
+	switch s := s.(type) {
+	case *ReturnStmt:
+		return true
+	case *CompStmt:
+		for i, ss := range s.Stmts {
+			if !c.returns(ss) {
+				continue
+			}
+			if i+1 < len(s.Stmts) {
+				// Report only the first unreachable statement, the rest are implied.
+				c.errorf(s.Stmts[i+1], CodeUnreachable, "unreachable code after return")
+			}
+			return true
+		}
+		return false
+	case *IfStmt:
+		c.returns(s.Stmt)
+		return false
+	case *IfElseStmt:
+		// NOTE: Evaluate both, to report unreachable code in either.
+		r1 := c.returns(s.Stmt1)
+		r2 := c.returns(s.Stmt2)
+		return r1 && r2
+	case *WhileStmt:
+		c.returns(s.Stmt)
+		// There is no break, so an infinite loop never completes normally.
+		cc, ok := s.Cond.(*ConstCond)
+		return ok && cc.Val
+	default:
+		return false
+	}
+}
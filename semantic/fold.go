@@ -0,0 +1,256 @@
+package semantic
+
+import "fmt"
+
+// Constant folding. Arithmetic expressions and conditions on constants are replaced by their value,
+// computed as it would be at run time on the target (see Target). Statements whose conditions
+// turn out constant are pruned: an if is replaced by the branch taken and a while that never runs
+// is removed. Folding relies on the types resolved by the checker, so it can only be run on a
+// checked AST.
+
+// folder holds the state of constant folding.
+type folder struct {
+	target Target
+	// diags are the diagnostics reported so far.
+	diags Diagnostics
+}
+
+// Fold performs constant folding on ast, in place. Division by a constant zero is reported as an
+// error, as Diagnostics (the rest of the program is still folded), unless it's in code that is
+// never evaluated: a pruned branch or loop body, or an operand short-circuited by a constant one.
+func Fold(ast *Ast) error {
+	f := &folder{target: ast.Target}
+	f.funcDef(ast.Program)
+	if len(f.diags) > 0 {
+		return f.diags
+	}
+	return nil
+}
+
+// discard discards the diagnostics in f.diags[from:to], reported for code that turned out never to
+// be evaluated.
+func (f *folder) discard(from, to int) {
+	f.diags = append(f.diags[:from], f.diags[to:]...)
+}
+
+// funcDef folds the body of n and of the functions nested in it.
+func (f *folder) funcDef(n *FuncDef) {
+	for _, ld := range n.LDefs {
+		if fd, ok := ld.(*FuncDef); ok {
+			f.funcDef(fd)
+		}
+	}
+	f.compStmt(&n.CompStmt)
+}
+
+// compStmt folds the statements of n, dropping those pruned altogether.
+func (f *folder) compStmt(n *CompStmt) {
+	stmts := n.Stmts[:0]
+	for _, s := range n.Stmts {
+		if s = f.stmt(s); s != nil {
+			stmts = append(stmts, s)
+		}
+	}
+	n.Stmts = stmts
+}
+
+// body folds s, the body of a statement, returning an empty compound statement in its place if it's
+// pruned altogether.
+func (f *folder) body(s Stmt) Stmt {
+	if fs := f.stmt(s); fs != nil {
+		return fs
+	}
+	return &CompStmt{Span: s.Loc()}
+}
+
+// stmt folds s, returning the statement to replace it with (nil if it's to be removed).
+func (f *folder) stmt(s Stmt) Stmt {
+	switch s := s.(type) {
+	case *CompStmt:
+		f.compStmt(s)
+	case *AssignStmt:
+		if ae, ok := s.Left.(*ArrayElem); ok {
+			ae.Index = f.expr(ae.Index)
+		}
+		s.Right = f.expr(s.Right)
+	case *FuncCallStmt:
+		f.funcCall(&s.FuncCall)
+	case *IfStmt:
+		s.Cond = f.cond(s.Cond)
+		start := len(f.diags)
+		s.Stmt = f.body(s.Stmt)
+		if cc, ok := s.Cond.(*ConstCond); ok {
+			if cc.Val {
+				return s.Stmt
+			}
+			f.discard(start, len(f.diags))
+			return nil
+		}
+	case *IfElseStmt:
+		s.Cond = f.cond(s.Cond)
+		start1 := len(f.diags)
+		s.Stmt1 = f.body(s.Stmt1)
+		start2 := len(f.diags)
+		s.Stmt2 = f.body(s.Stmt2)
+		if cc, ok := s.Cond.(*ConstCond); ok {
+			if cc.Val {
+				f.discard(start2, len(f.diags))
+				return s.Stmt1
+			}
+			f.discard(start1, start2)
+			return s.Stmt2
+		}
+	case *WhileStmt:
+		s.Cond = f.cond(s.Cond)
+		start := len(f.diags)
+		s.Stmt = f.body(s.Stmt)
+		// NOTE: A loop that always runs is kept: it never completes.
+		if cc, ok := s.Cond.(*ConstCond); ok && !cc.Val {
+			f.discard(start, len(f.diags))
+			return nil
+		}
+	case *ReturnStmt:
+		if s.Expr != nil {
+			s.Expr = f.expr(s.Expr)
+		}
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+	return s
+}
+
+// funcCall folds the arguments of n.
+func (f *folder) funcCall(n *FuncCall) {
+	for i, a := range n.Args {
+		n.Args[i] = f.expr(a)
+	}
+}
+
+// expr folds e, returning the expression to replace it with.
+func (f *folder) expr(e Expr) Expr {
+	switch e := e.(type) {
+	case *IntConstExpr, *CharConstExpr, *StrLitExpr, *VarRef:
+	case *ArrayElem:
+		e.Index = f.expr(e.Index)
+	case *FuncCallExpr:
+		f.funcCall(&e.FuncCall)
+	case *UnArithExpr:
+		e.Expr = f.expr(e.Expr)
+		if c, ok := e.Expr.(*IntConstExpr); ok {
+			v := f.target.WrapInt(int32(c.Val))
+			if e.Sign == SignMinus {
+				v = f.target.WrapInt(-v)
+			}
+			return &IntConstExpr{Val: int64(v), Span: e.Span}
+		}
+	case *BinArithExpr:
+		return f.binArith(e)
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+	return e
+}
+
+// constVal returns the value of e, if it's a constant.
+func constVal(e Expr) (int32, bool) {
+	switch e := e.(type) {
+	case *IntConstExpr:
+		return int32(e.Val), true
+	case *CharConstExpr:
+		return int32(byte(e.Val)), true
+	default:
+		return 0, false
+	}
+}
+
+// binArith folds n, returning the expression to replace it with.
+func (f *folder) binArith(n *BinArithExpr) Expr {
+	n.Left = f.expr(n.Left)
+	n.Right = f.expr(n.Right)
+	r, rok := constVal(n.Right)
+	if rok && r == 0 && (n.Op == ArithOpDiv || n.Op == ArithOpMod) {
+		f.diags = append(f.diags, Errorf(n.Right.Loc(), CodeDivZero, "division by zero"))
+		return n
+	}
+	l, lok := constVal(n.Left)
+	if !lok || !rok {
+		return n
+	}
+	t := n.Type.(PrimitiveType)
+	v := f.target.Arith(n.Op, f.target.WrapInt(l), f.target.WrapInt(r), t)
+	if t == PrimitiveTypeByte {
+		return &CharConstExpr{Val: rune(v), Span: n.Span}
+	}
+	return &IntConstExpr{Val: int64(v), Span: n.Span}
+}
+
+// cond folds c, returning the condition to replace it with.
+func (f *folder) cond(c Cond) Cond {
+	switch c := c.(type) {
+	case *ConstCond:
+	case *UnCond:
+		c.Cond = f.cond(c.Cond)
+		if cc, ok := c.Cond.(*ConstCond); ok {
+			return &ConstCond{Val: !cc.Val, Span: c.Span}
+		}
+	case *CompCond:
+		c.Left = f.expr(c.Left)
+		c.Right = f.expr(c.Right)
+		l, lok := constVal(c.Left)
+		r, rok := constVal(c.Right)
+		if lok && rok {
+			return &ConstCond{Val: Compare(c.Op, l, r), Span: c.Span}
+		}
+	case *BinCond:
+		c.Left = f.cond(c.Left)
+		start := len(f.diags)
+		c.Right = f.cond(c.Right)
+		// A constant left operand either decides the result (short-circuit evaluation) or leaves
+		// it to the right one. A constant right operand leaving the result to the left one can be
+		// dropped too (but one deciding it can't, as the left one may have side effects).
+		if lc, ok := c.Left.(*ConstCond); ok {
+			if lc.Val == (c.Op == LogOpOr) {
+				// The right operand is never evaluated.
+				f.discard(start, len(f.diags))
+				return &ConstCond{Val: lc.Val, Span: c.Span}
+			}
+			return c.Right
+		}
+		if rc, ok := c.Right.(*ConstCond); ok && rc.Val == (c.Op == LogOpAnd) {
+			return c.Left
+		}
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+	return c
+}
+
+// constant returns the value e (a checked expression) folds to on target, if it's constant. Division
+// by zero is not constant.
+func constant(e Expr, target Target) (int32, bool) {
+	switch e := e.(type) {
+	case *IntConstExpr:
+		return target.WrapInt(int32(e.Val)), true
+	case *CharConstExpr:
+		return int32(byte(e.Val)), true
+	case *UnArithExpr:
+		v, ok := constant(e.Expr, target)
+		if ok && e.Sign == SignMinus {
+			v = target.WrapInt(-v)
+		}
+		return v, ok
+	case *BinArithExpr:
+		t, ok := e.Type.(PrimitiveType)
+		if !ok {
+			return 0, false
+		}
+		l, lok := constant(e.Left, target)
+		r, rok := constant(e.Right, target)
+		if !lok || !rok || r == 0 && (e.Op == ArithOpDiv || e.Op == ArithOpMod) {
+			return 0, false
+		}
+		return target.Arith(e.Op, l, r, t), true
+	default:
+		return 0, false
+	}
+}
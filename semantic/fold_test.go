@@ -0,0 +1,144 @@
+package semantic_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestFold(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	b : byte;
+	f() : int { return 1; }
+{
+	x = 2 * 3 + x * (4 - 4);
+	x = -(1 + 2) * 2147483647 + 10 / 3 - -7 % 4;
+	b = 'a' + 'z' + 'z';
+	if (1 < 2) writeInteger(x); else writeInteger(0);
+	if (x > 1 & 'a' == 'b') writeInteger(1);
+	if (!false | f() == 1) writeInteger(2);
+	if (x == 1 & true | f() > 0 & false) writeByte(b);
+	while (2 + 2 == 5) x = x + 1;
+	while (x != 0 & (1 > 0 | x < 0))
+		if (3 % 2 == 0) x = 0; else x = x / 2;
+}
+`
+	want := `main() : proc
+	x : int;
+	b : byte;
+	f() : int
+	{
+		return 1;
+	}
+{
+	x = 6 + x * 0;
+	x = -2147483639;
+	b = 'U';
+	writeInteger(x);
+	if (x > 1 & false)
+		writeInteger(1);
+	writeInteger(2);
+	if (x == 1 | f() > 0 & false)
+		writeByte(b);
+	while (x != 0)
+		x = x / 2;
+}
+`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	if err := semantic.Fold(ast); err != nil {
+		t.Fatalf("Fold() failed: %v", err)
+	}
+	var b strings.Builder
+	if err := semantic.Fprint(&b, ast); err != nil {
+		t.Fatalf("Fprint() failed: %v", err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("folded program =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFoldDivZero(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	b : byte;
+{
+	x = x / (1 - 1);
+	b = b % '\0';
+	if (true) x = 1 / 0;
+	if (x == 1 | 1 % 0 == 1) x = 1;
+	x = x / 1;
+}
+`
+	err := semantic.Fold(alantest.Parse(t, "test.alan", []byte(src), semantic.Target{}))
+	ds, ok := err.(semantic.Diagnostics)
+	if !ok {
+		t.Fatalf("Fold() = %v, want semantic.Diagnostics", err)
+	}
+	if len(ds) != 4 {
+		t.Fatalf("Fold() reported %d diagnostics, want 4: %v", len(ds), ds)
+	}
+	for i, d := range ds {
+		if d.Code != semantic.CodeDivZero {
+			t.Errorf("diagnostic #%d has code %q, want %q", i+1, d.Code, semantic.CodeDivZero)
+		}
+	}
+}
+
+func TestFoldDivZeroNotEvaluated(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"pruned if", "if (false) x = x / 0;"},
+		{"pruned then", "if (1 > 2) x = x / 0; else x = 1;"},
+		{"pruned else", "if (1 < 2) x = 1; else { x = 1 % 0; }"},
+		{"pruned while", "while (false) x = x / 0;"},
+		{"short-circuited or", "if (true | x / 0 == 1) x = 1;"},
+		{"short-circuited and", "if (false & x % 0 == 1) x = 1; else x = 2;"},
+		{"nested", "if (false) { if (x == 1) x = x / 0; while (x > 0) x = 1 / 0; }"},
+	}
+	for _, test := range tests {
+		src := "main() : proc\n\tx : int;\n{\n\t" + test.body + "\n}\n"
+		ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+		if err := semantic.Fold(ast); err != nil {
+			t.Errorf("%s: Fold() = %v, want no error", test.name, err)
+		}
+	}
+}
+
+func TestFoldTarget(t *testing.T) {
+	src := `main() : proc
+	x : int;
+{
+	x = 32767 + 1;
+	x = 300 * 300;
+	x = -32768 / -1;
+	x = -32768 % -1;
+}
+`
+	tests := []struct {
+		target string
+		want   []int64
+	}{
+		{target: "int32", want: []int64{32768, 90000, 32768, 0}},
+		{target: "int16", want: []int64{-32768, 24464, -32768, 0}},
+	}
+	for _, test := range tests {
+		ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Targets[test.target])
+		if err := semantic.Fold(ast); err != nil {
+			t.Fatalf("Fold() for %s failed: %v", test.target, err)
+		}
+		for i, s := range ast.Program.Stmts {
+			c, ok := s.(*semantic.AssignStmt).Right.(*semantic.IntConstExpr)
+			if !ok {
+				t.Errorf("statement #%d for %s not folded", i+1, test.target)
+			} else if c.Val != test.want[i] {
+				t.Errorf("statement #%d for %s folded to %d, want %d", i+1, test.target, c.Val,
+					test.want[i])
+			}
+		}
+	}
+}
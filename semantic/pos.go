@@ -0,0 +1,36 @@
+package semantic
+
+import "fmt"
+
+// Pos is a position in a source file.
+type Pos struct {
+	// File is the name of the source file (may be empty).
+	File string
+	// Line is the line number, starting at 1.
+	Line int
+	// Col is the column number (in bytes), starting at 1.
+	Col int
+	// Offset is the byte offset, starting at 0.
+	Offset int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Span is a range of source text, from Start (inclusive) to End (exclusive).
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// Join returns the span from the start of s to the end of t.
+func (s Span) Join(t Span) Span {
+	return Span{
+		Start: s.Start,
+		End:   t.End,
+	}
+}
@@ -0,0 +1,283 @@
+package semantic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Precedences of expressions, for parenthesizing (higher binds tighter).
+const (
+	precAdd  = iota + 1 // '+', '-'
+	precMul             // '*', '/', '%'
+	precSign            // unary '+', '-'
+	precPrim            // constants, l-values, function calls
+)
+
+// Precedences of conditions, for parenthesizing (higher binds tighter).
+const (
+	precOr   = iota + 1 // '|'
+	precAnd             // '&'
+	precComp            // comparisons
+	precNot             // '!', constants
+)
+
+// printer writes an AST as Alan source.
+type printer struct {
+	w *bufio.Writer
+}
+
+// Fprint writes ast to w as Alan source, one tab per level of indentation. Parentheses are only
+// added where necessary and comments are lost, but parsing the result yields an equivalent AST.
+func Fprint(w io.Writer, ast *Ast) error {
+	p := &printer{w: bufio.NewWriter(w)}
+	p.funcDef(ast.Program, 0)
+	p.printf("\n")
+	return p.w.Flush()
+}
+
+// printf writes to p according to format.
+func (p *printer) printf(format string, a ...interface{}) {
+	fmt.Fprintf(p.w, format, a...)
+}
+
+// line starts a new line, indented by indent tabs.
+func (p *printer) line(indent int) {
+	p.w.WriteString("\n" + strings.Repeat("\t", indent))
+}
+
+// funcDef prints the function definition n at indentation level indent.
+func (p *printer) funcDef(n *FuncDef, indent int) {
+	ps := make([]string, len(n.Parameters))
+	for i, pd := range n.Parameters {
+		ps[i] = fmt.Sprintf("%s : %v", pd.ID, pd.Type)
+	}
+	r := "proc"
+	if n.RType != nil {
+		r = n.RType.String()
+	}
+	p.printf("%s(%s) : %s", n.ID, strings.Join(ps, ", "), r)
+	for _, ld := range n.LDefs {
+		p.line(indent + 1)
+		switch ld := ld.(type) {
+		case *FuncDef:
+			p.funcDef(ld, indent+1)
+		case *PrimVarDef:
+			p.printf("%s : %v;", ld.ID, ld.Type)
+		case *ArrayDef:
+			p.printf("%s : %v;", ld.ID, ld.Type)
+		default:
+			panic(fmt.Sprintf("local definition of invalid type %T", ld))
+		}
+	}
+	p.line(indent)
+	p.compStmt(&n.CompStmt, indent)
+}
+
+// compStmt prints the compound statement n, whose braces are at indentation level indent.
+func (p *printer) compStmt(n *CompStmt, indent int) {
+	p.printf("{")
+	for _, s := range n.Stmts {
+		p.line(indent + 1)
+		p.stmt(s, indent+1)
+	}
+	p.line(indent)
+	p.printf("}")
+}
+
+// body prints s, the body of a control statement at indentation level indent: compound statements
+// go on the same line, anything else on the next one, indented.
+func (p *printer) body(s Stmt, indent int) {
+	if cs, ok := s.(*CompStmt); ok {
+		p.printf(" ")
+		p.compStmt(cs, indent)
+		return
+	}
+	p.line(indent + 1)
+	p.stmt(s, indent+1)
+}
+
+// stmt prints statement s at indentation level indent.
+func (p *printer) stmt(s Stmt, indent int) {
+	switch s := s.(type) {
+	case *CompStmt:
+		p.compStmt(s, indent)
+	case *AssignStmt:
+		p.expr(s.Left, 0)
+		p.printf(" = ")
+		p.expr(s.Right, 0)
+		p.printf(";")
+	case *FuncCallStmt:
+		p.funcCall(&s.FuncCall)
+		p.printf(";")
+	case *IfStmt:
+		p.printf("if (")
+		p.cond(s.Cond, 0)
+		p.printf(")")
+		p.body(s.Stmt, indent)
+	case *IfElseStmt:
+		p.printf("if (")
+		p.cond(s.Cond, 0)
+		p.printf(") ")
+		// NOTE: Always use braces here, so that a nested if without else can't capture the else.
+		cs, ok := s.Stmt1.(*CompStmt)
+		if !ok {
+			cs = &CompStmt{Stmts: []Stmt{s.Stmt1}}
+		}
+		p.compStmt(cs, indent)
+		p.printf(" else")
+		if elif, ok := s.Stmt2.(*IfElseStmt); ok {
+			p.printf(" ")
+			p.stmt(elif, indent)
+		} else {
+			p.body(s.Stmt2, indent)
+		}
+	case *WhileStmt:
+		p.printf("while (")
+		p.cond(s.Cond, 0)
+		p.printf(")")
+		p.body(s.Stmt, indent)
+	case *ReturnStmt:
+		if s.Expr == nil {
+			p.printf("return;")
+			return
+		}
+		p.printf("return ")
+		p.expr(s.Expr, 0)
+		p.printf(";")
+	default:
+		panic(fmt.Sprintf("statement of invalid type %T", s))
+	}
+}
+
+// funcCall prints function call n.
+func (p *printer) funcCall(n *FuncCall) {
+	p.printf("%s(", n.ID)
+	for i, a := range n.Args {
+		if i > 0 {
+			p.printf(", ")
+		}
+		p.expr(a, 0)
+	}
+	p.printf(")")
+}
+
+// expr prints expression e, parenthesized if its precedence is lower than prec.
+func (p *printer) expr(e Expr, prec int) {
+	var ep int
+	switch e := e.(type) {
+	case *BinArithExpr:
+		ep = precAdd
+		if e.Op == ArithOpMult || e.Op == ArithOpDiv || e.Op == ArithOpMod {
+			ep = precMul
+		}
+	case *UnArithExpr:
+		ep = precSign
+	case *IntConstExpr:
+		// NOTE: Negative constants (e.g. folded ones) are printed with their sign.
+		ep = precPrim
+		if e.Val < 0 {
+			ep = precSign
+		}
+	default:
+		ep = precPrim
+	}
+	if ep < prec {
+		p.printf("(")
+		defer p.printf(")")
+	}
+
+	switch e := e.(type) {
+	case *IntConstExpr:
+		p.printf("%d", e.Val)
+	case *CharConstExpr:
+		p.printf("'%s'", escape(byte(e.Val), '\''))
+	case *StrLitExpr:
+		var b strings.Builder
+		for i := 0; i < len(e.Val); i++ {
+			b.WriteString(escape(e.Val[i], '"'))
+		}
+		p.printf("\"%s\"", b.String())
+	case *VarRef:
+		p.printf("%s", e.ID)
+	case *ArrayElem:
+		p.printf("%s[", e.ID)
+		p.expr(e.Index, 0)
+		p.printf("]")
+	case *FuncCallExpr:
+		p.funcCall(&e.FuncCall)
+	case *UnArithExpr:
+		p.printf("%c", e.Sign)
+		// NOTE: Parenthesize nested signs, "--" starts a comment.
+		p.expr(e.Expr, precSign+1)
+	case *BinArithExpr:
+		// Binary arithmetic operators are left-associative.
+		p.expr(e.Left, ep)
+		p.printf(" %c ", e.Op)
+		p.expr(e.Right, ep+1)
+	default:
+		panic(fmt.Sprintf("expression of invalid type %T", e))
+	}
+}
+
+// cond prints condition c, parenthesized if its precedence is lower than prec.
+func (p *printer) cond(c Cond, prec int) {
+	var cp int
+	switch c := c.(type) {
+	case *BinCond:
+		cp = precOr
+		if c.Op == LogOpAnd {
+			cp = precAnd
+		}
+	case *CompCond:
+		cp = precComp
+	default:
+		cp = precNot
+	}
+	if cp < prec {
+		p.printf("(")
+		defer p.printf(")")
+	}
+
+	switch c := c.(type) {
+	case *ConstCond:
+		p.printf("%t", c.Val)
+	case *UnCond:
+		p.printf("!")
+		p.cond(c.Cond, precNot)
+	case *CompCond:
+		p.expr(c.Left, 0)
+		p.printf(" %s ", c.Op)
+		p.expr(c.Right, 0)
+	case *BinCond:
+		// Binary logical operators are left-associative.
+		p.cond(c.Left, cp)
+		p.printf(" %c ", c.Op)
+		p.cond(c.Right, cp+1)
+	default:
+		panic(fmt.Sprintf("condition of invalid type %T", c))
+	}
+}
+
+// escape returns character b as it would appear in a literal delimited by quote.
+func escape(b, quote byte) string {
+	switch b {
+	case '\n':
+		return `\n`
+	case '\t':
+		return `\t`
+	case '\r':
+		return `\r`
+	case 0:
+		return `\0`
+	case '\\':
+		return `\\`
+	case quote:
+		return `\` + string(quote)
+	}
+	if b < ' ' || b > '~' {
+		return fmt.Sprintf(`\x%02x`, b)
+	}
+	return string(b)
+}
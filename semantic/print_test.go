@@ -0,0 +1,75 @@
+package semantic_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/semantic"
+)
+
+func TestFprint(t *testing.T) {
+	src := `main() : proc
+	x : int;
+	s : byte [4];
+	f(n : int, a : reference byte []) : int
+	{
+		if (n <= 0) {
+			return -(-n);
+		} else if (!(a[0] == '\n') & true | false) {
+			return (n - 1) * -2 - (n + 1) % 3;
+		} else
+			return f(n - (1 - 1), a);
+	}
+{
+	x = f(1, "tab\t'quote'\n");
+	if (x > 0) {
+		if (x < 10)
+			x = 0;
+	} else {
+	}
+	while (true) {
+		writeInteger(x);
+		return;
+	}
+}
+`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	var b strings.Builder
+	if err := semantic.Fprint(&b, ast); err != nil {
+		t.Fatalf("Fprint() failed: %v", err)
+	}
+	if got := b.String(); got != src {
+		t.Errorf("Fprint() =\n%s\nwant\n%s", got, src)
+	}
+}
+
+func TestFprintRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.alan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "unclosed_comment.alan") {
+			continue
+		}
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b1, b2 bytes.Buffer
+		if err := semantic.Fprint(&b1, alantest.Parse(t, file, src, semantic.Target{})); err != nil {
+			t.Fatalf("Fprint(%q) failed: %v", file, err)
+		}
+		printed := alantest.Parse(t, file+" (printed)", b1.Bytes(), semantic.Target{})
+		if err := semantic.Fprint(&b2, printed); err != nil {
+			t.Fatalf("Fprint(%q) failed: %v", file, err)
+		}
+		if b1.String() != b2.String() {
+			t.Errorf("%s: printing is not stable:\n%s\nthen\n%s", file, b1.String(), b2.String())
+		}
+	}
+}
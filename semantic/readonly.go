@@ -0,0 +1,171 @@
+package semantic
+
+// Read-only analysis. String literals are arrays the program can read but not modify: they can't be
+// assigned to (see AssignStmt.check), nor passed by reference to a parameter the callee may write
+// to. A by-reference parameter may be written by an assignment to it (or an element of it) in the
+// body of its function or of any function nested in it, or by passing it on by reference to another
+// parameter that may be written. This relies on the declarations resolved by the checker and calls
+// may be recursive, so string literal arguments are only checked once the whole program has been.
+
+// stdlibWrites are the parameters of the standard library functions that are written, by function.
+var stdlibWrites = map[ID][]int{
+	"readString": {1},
+	"strcpy":     {0},
+	"strcat":     {0},
+}
+
+// strArg is a string literal passed by reference, as argument #i+1 of call.
+type strArg struct {
+	call *FuncCall
+	i    int
+}
+
+// writeAnalysis holds the state of the read-only analysis.
+type writeAnalysis struct {
+	// written are the by-reference parameters that may be written.
+	written map[*ParDef]bool
+	// passed are the by-reference parameters each parameter is passed on to.
+	passed map[*ParDef][]*ParDef
+}
+
+// checkReadOnly checks that none of the string literals passed by reference in program (recorded in
+// c.strArgs) may be written by the function called.
+func (c *checker) checkReadOnly(program *FuncDef) {
+	if len(c.strArgs) == 0 {
+		return
+	}
+	wa := &writeAnalysis{
+		written: map[*ParDef]bool{},
+		passed:  map[*ParDef][]*ParDef{},
+	}
+	for id, is := range stdlibWrites {
+		for _, i := range is {
+			wa.written[&stdlibDefs[id].Parameters[i]] = true
+		}
+	}
+	wa.funcDef(program)
+
+	// Propagate writes from the parameters passed on to the parameters passing them, until nothing
+	// changes (calls may be recursive).
+	for changed := true; changed; {
+		changed = false
+		for p, qs := range wa.passed {
+			for _, q := range qs {
+				if wa.written[q] && !wa.written[p] {
+					wa.written[p] = true
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, sa := range c.strArgs {
+		p := &sa.call.Decl.Parameters[sa.i]
+		if !wa.written[p] {
+			continue
+		}
+		a := sa.call.Args[sa.i]
+		d := Errorf(a.Loc(), CodeReadOnly, "string literal passed as argument #%d to %q, which "+
+			"may modify it", sa.i+1, sa.call.ID)
+		if !IsStdlib(sa.call.Decl) {
+			d.Related = append(d.Related, Note(p.Loc(), "parameter %q defined here", p.ID))
+		}
+		c.diags = append(c.diags, d)
+	}
+}
+
+// funcDef records the parameters written or passed on in the function defined by def (and any
+// functions nested in it).
+func (wa *writeAnalysis) funcDef(def *FuncDef) {
+	for _, ld := range def.LDefs {
+		if fd, ok := ld.(*FuncDef); ok {
+			wa.funcDef(fd)
+		}
+	}
+	wa.stmt(&def.CompStmt)
+}
+
+// stmt records the parameters written or passed on in s.
+func (wa *writeAnalysis) stmt(s Stmt) {
+	switch s := s.(type) {
+	case *CompStmt:
+		for _, s := range s.Stmts {
+			wa.stmt(s)
+		}
+	case *AssignStmt:
+		if p := param(s.Left); p != nil {
+			wa.written[p] = true
+		}
+		wa.expr(s.Left)
+		wa.expr(s.Right)
+	case *FuncCallStmt:
+		wa.call(&s.FuncCall)
+	case *IfStmt:
+		wa.expr(s.Cond)
+		wa.stmt(s.Stmt)
+	case *IfElseStmt:
+		wa.expr(s.Cond)
+		wa.stmt(s.Stmt1)
+		wa.stmt(s.Stmt2)
+	case *WhileStmt:
+		wa.expr(s.Cond)
+		wa.stmt(s.Stmt)
+	case *ReturnStmt:
+		if s.Expr != nil {
+			wa.expr(s.Expr)
+		}
+	}
+}
+
+// expr records the parameters passed on in the function calls in e.
+func (wa *writeAnalysis) expr(e Expr) {
+	switch e := e.(type) {
+	case *ArrayElem:
+		wa.expr(e.Index)
+	case *FuncCallExpr:
+		wa.call(&e.FuncCall)
+	case *UnArithExpr:
+		wa.expr(e.Expr)
+	case *BinArithExpr:
+		wa.expr(e.Left)
+		wa.expr(e.Right)
+	case *UnCond:
+		wa.expr(e.Cond)
+	case *CompCond:
+		wa.expr(e.Left)
+		wa.expr(e.Right)
+	case *BinCond:
+		wa.expr(e.Left)
+		wa.expr(e.Right)
+	}
+}
+
+// call records the parameters passed on by reference in the function call c (and in its
+// arguments).
+func (wa *writeAnalysis) call(c *FuncCall) {
+	for i, a := range c.Args {
+		wa.expr(a)
+		if c.Decl == nil || i >= len(c.Decl.Parameters) || !c.Decl.Parameters[i].Type.IsRef {
+			continue
+		}
+		if p := param(a); p != nil {
+			wa.passed[p] = append(wa.passed[p], &c.Decl.Parameters[i])
+		}
+	}
+}
+
+// param returns the by-reference parameter e refers to (either whole or an element of it), or nil
+// if it refers to anything else.
+func param(e Expr) *ParDef {
+	var decl Node
+	switch e := e.(type) {
+	case *VarRef:
+		decl = e.Decl
+	case *ArrayElem:
+		decl = e.Decl
+	}
+	if p, ok := decl.(*ParDef); ok && p.Type.IsRef {
+		return p
+	}
+	return nil
+}
@@ -186,3 +186,42 @@ var stdlib = []struct {
 		},
 	},
 }
+
+// stdlibDefs are the definitions of the standard library functions, for calls to link to (see
+// FuncCall.Decl). They have no parameter names, body or location.
+var stdlibDefs = newStdlibDefs()
+
+// newStdlibDefs returns the definitions of the standard library functions, by identifier.
+func newStdlibDefs() map[ID]*FuncDef {
+	defs := make(map[ID]*FuncDef, len(stdlib))
+	for _, fd := range stdlib {
+		pds := make([]ParDef, len(fd.Parameters))
+		for i, pt := range fd.Parameters {
+			pds[i] = ParDef{Type: pt}
+		}
+		defs[fd.ID] = &FuncDef{
+			ID:         fd.ID,
+			Parameters: pds,
+			RType:      fd.Return,
+			LDefs:      []LocalDef{},
+			CompStmt:   CompStmt{Stmts: []Stmt{}},
+		}
+	}
+	return defs
+}
+
+// IsStdlib returns whether fd is the definition of a standard library function.
+func IsStdlib(fd *FuncDef) bool {
+	return fd != nil && stdlibDefs[fd.ID] == fd
+}
+
+// Stdlib returns the type of the standard library function identified by id and whether such a
+// function exists.
+func Stdlib(id ID) (FunctionType, bool) {
+	for _, fd := range stdlib {
+		if fd.ID == id {
+			return fd.FunctionType, true
+		}
+	}
+	return FunctionType{}, false
+}
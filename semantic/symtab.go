@@ -3,8 +3,15 @@ package semantic
 // Pascal scope: each symbol is visible from the point of its declaration until the end of that
 // unit. Except if it's shadowed.
 
+// symbol is a single symbol definition.
+type symbol struct {
+	Type
+	// decl is the symbol's declaration (nil if unknown, e.g. for the standard library).
+	decl Node
+}
+
 // scope is a single Alan scope (the scope of a unit, not a single symbol).
-type scope map[ID]Type
+type scope map[ID]symbol
 
 // idScope is an identified scope (a scope and a name).
 type idScope struct {
@@ -80,24 +87,38 @@ func (st *SymTab) CurrentID() ID {
 // Add adds a new symbol definition for name to the current scope, returning false if there is a
 // definition for that name in the current scope already (not shadowable).
 func (st *SymTab) Add(name ID, t Type) bool {
+	return st.AddDecl(name, t, nil)
+}
+
+// AddDecl is like Add, but also records decl as the declaration of the symbol.
+func (st *SymTab) AddDecl(name ID, t Type, decl Node) bool {
 	_, sc := st.scopes.top()
 	if _, ok := (*sc)[name]; ok {
 		return false
 	}
 
-	(*sc)[name] = t
+	(*sc)[name] = symbol{
+		Type: t,
+		decl: decl,
+	}
 	return true
 }
 
 // Lookup searches if name is visible from the current scope. If so, it returns its type, otherwise
 // it returns nil.
 func (st *SymTab) Lookup(name ID) Type {
+	t, _ := st.LookupDecl(name)
+	return t
+}
+
+// LookupDecl is like Lookup, but also returns the declaration of the symbol (nil if unknown).
+func (st *SymTab) LookupDecl(name ID) (Type, Node) {
 	for i := len(st.scopes.stack) - 1; i >= 0; i-- {
-		if t, ok := st.scopes.stack[i].scope[name]; ok {
-			return t
+		if sym, ok := st.scopes.stack[i].scope[name]; ok {
+			return sym.Type, sym.decl
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 // Exit removes the current scope and switches to its previous.
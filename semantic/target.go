@@ -0,0 +1,96 @@
+package semantic
+
+import "fmt"
+
+// NOTE: int is a two's complement integer of the target's width, byte an 8-bit unsigned one.
+// Arithmetic on either wraps around (modulo 2^width) and division truncates towards zero, so the
+// only arithmetic error is division by zero (in particular, the most negative int divided by -1 is
+// itself, with remainder 0). Whatever its width, every backend holds int in 32 bits (so that the
+// runtime is the same for all targets), wrapping results around to the target's width as needed.
+
+// Target describes the machine programs are compiled for, as far as the language is concerned.
+type Target struct {
+	// IntBits is the width of int in bits, 16 or 32 (0 meaning the default, 32).
+	IntBits int
+}
+
+// Targets are the supported targets, by name.
+var Targets = map[string]Target{
+	"int16": {IntBits: 16},
+	"int32": {IntBits: 32},
+}
+
+// IntWidth returns the width of int in bits.
+func (t Target) IntWidth() int {
+	if t.IntBits == 0 {
+		return 32
+	}
+	return t.IntBits
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("int%d", t.IntWidth())
+}
+
+// MaxInt returns the largest int.
+func (t Target) MaxInt() int64 {
+	return 1<<(t.IntWidth()-1) - 1
+}
+
+// MinInt returns the smallest int.
+func (t Target) MinInt() int64 {
+	return -1 << (t.IntWidth() - 1)
+}
+
+// WrapInt returns v wrapped around to the range of int.
+func (t Target) WrapInt(v int32) int32 {
+	if t.IntWidth() == 16 {
+		return int32(int16(v))
+	}
+	return v
+}
+
+// Arith returns the result of arithmetic operator op on x and y (both of type typ, int or byte),
+// wrapped around as at run time. y must not be zero if op is a division.
+func (t Target) Arith(op ArithOp, x, y int32, typ PrimitiveType) int32 {
+	var v int32
+	switch op {
+	case ArithOpPlus:
+		v = x + y
+	case ArithOpMinus:
+		v = x - y
+	case ArithOpMult:
+		v = x * y
+	case ArithOpDiv:
+		v = x / y
+	case ArithOpMod:
+		v = x % y
+	default:
+		panic(fmt.Sprintf("invalid arithmetic operator %q", op))
+	}
+	if typ == PrimitiveTypeByte {
+		return int32(uint8(v))
+	}
+	return t.WrapInt(v)
+}
+
+// Compare returns the result of comparison operator op on x and y (which are the same on every
+// target, as values are kept wrapped around).
+func Compare(op CompOp, x, y int32) bool {
+	switch op {
+	case CompOpEQ:
+		return x == y
+	case CompOpNE:
+		return x != y
+	case CompOpLT:
+		return x < y
+	case CompOpGT:
+		return x > y
+	case CompOpLE:
+		return x <= y
+	case CompOpGE:
+		return x >= y
+	default:
+		panic(fmt.Sprintf("invalid comparison operator %q", op))
+	}
+}
@@ -1,5 +1,10 @@
 package semantic
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	// PrimitiveTypeInt is the "int" primitive type.
 	PrimitiveTypeInt PrimitiveType = iota
@@ -27,6 +32,19 @@ func (PrimitiveType) isType() {}
 
 func (PrimitiveType) isDType() {}
 
+func (t PrimitiveType) String() string {
+	switch t {
+	case PrimitiveTypeInt:
+		return "int"
+	case PrimitiveTypeByte:
+		return "byte"
+	case PrimitiveTypeBool:
+		return "bool"
+	default:
+		return fmt.Sprintf("PrimitiveType(%d)", int(t))
+	}
+}
+
 // ArrayType is an array type.
 type ArrayType struct {
 	// PrimitiveType is the array's element type.
@@ -39,6 +57,15 @@ func (ArrayType) isType() {}
 
 func (ArrayType) isDType() {}
 
+// String renders t in Alan syntax (e.g. "byte [10]"). The size is omitted if unknown (e.g. for array
+// parameters).
+func (t ArrayType) String() string {
+	if t.Size == 0 {
+		return fmt.Sprintf("%v []", t.PrimitiveType)
+	}
+	return fmt.Sprintf("%v [%d]", t.PrimitiveType, t.Size)
+}
+
 // ParameterType is a function parameter type (i.e a data type with pass-by information).
 type ParameterType struct {
 	// DType is the parameter's data type (if an array, size is ignored).
@@ -49,6 +76,14 @@ type ParameterType struct {
 
 func (ParameterType) isType() {}
 
+// String renders t in Alan syntax (e.g. "reference byte []").
+func (t ParameterType) String() string {
+	if t.IsRef {
+		return fmt.Sprintf("reference %v", t.DType)
+	}
+	return fmt.Sprint(t.DType)
+}
+
 // FunctionType is a function type.
 type FunctionType struct {
 	// Parameters are the parameters' types.
@@ -58,3 +93,35 @@ type FunctionType struct {
 }
 
 func (FunctionType) isType() {}
+
+// String renders t in Alan syntax, without the parameter names (e.g. "(int, reference byte []) :
+// int").
+func (t FunctionType) String() string {
+	ps := make([]string, len(t.Parameters))
+	for i, p := range t.Parameters {
+		ps[i] = p.String()
+	}
+	r := "proc"
+	if t.Return != nil {
+		r = t.Return.String()
+	}
+	return fmt.Sprintf("(%s) : %s", strings.Join(ps, ", "), r)
+}
+
+// errorType is the type of erroneous constructs (e.g. references to undefined identifiers). It is
+// accepted wherever any other type is, to avoid reporting cascading errors.
+type errorType struct{}
+
+func (errorType) isType() {}
+
+func (errorType) isDType() {}
+
+func (errorType) String() string {
+	return "invalid type"
+}
+
+// isError returns whether t is the error type.
+func isError(t Type) bool {
+	_, ok := t.(errorType)
+	return ok
+}
@@ -0,0 +1,33 @@
+package semantic
+
+import "testing"
+
+func TestTypeString(t *testing.T) {
+	tests := []struct {
+		Type
+		want string
+	}{
+		{PrimitiveTypeInt, "int"},
+		{PrimitiveTypeByte, "byte"},
+		{PrimitiveTypeBool, "bool"},
+		{ArrayType{PrimitiveType: PrimitiveTypeInt, Size: 10}, "int [10]"},
+		{ArrayType{PrimitiveType: PrimitiveTypeByte}, "byte []"},
+		{ParameterType{DType: PrimitiveTypeInt}, "int"},
+		{ParameterType{DType: PrimitiveTypeByte, IsRef: true}, "reference byte"},
+		{ParameterType{DType: ArrayType{PrimitiveType: PrimitiveTypeByte}, IsRef: true},
+			"reference byte []"},
+		{FunctionType{}, "() : proc"},
+		{FunctionType{
+			Parameters: []ParameterType{
+				{DType: PrimitiveTypeInt},
+				{DType: ArrayType{PrimitiveType: PrimitiveTypeByte}, IsRef: true},
+			},
+			Return: &rInt,
+		}, "(int, reference byte []) : int"},
+	}
+	for _, test := range tests {
+		if got := test.Type.(interface{ String() string }).String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package ssa
+
+import (
+	"fmt"
+
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: SSA construction follows Braun et al., "Simple and Efficient Construction of Static Single
+// Assignment Form" (2013): each block records the current value of each variable, and reading a
+// variable not defined in a block looks it up in its predecessors, placing phis where they join.
+// A block is sealed (i.e. its phis are completed) once all its predecessors have been filled.
+// Variables are keyed by their declaration (for local variables), ir.Temp or ir.Result.
+
+// builder is the state of the construction of a function.
+type builder struct {
+	f *Func
+	u *ir.Unit
+	// promoted are the local variables turned into values.
+	promoted map[semantic.Node]bool
+	// types are the types of the variables.
+	types map[interface{}]semantic.PrimitiveType
+	// defs are the current values of the variables, by block.
+	defs map[interface{}]map[*Block]*Value
+	// filled and sealed are the blocks filled and sealed so far.
+	filled, sealed map[*Block]bool
+	// incomplete are the phis of each unsealed block, in order of creation.
+	incomplete map[*Block][]incompletePhi
+	// pars are the arguments of the next call, along with their modes.
+	pars  []*Value
+	modes []ir.Mode
+	// ret is the variable the result of the next call is to be stored in (nil if none).
+	ret interface{}
+}
+
+// incompletePhi is a phi of an unsealed block, for variable key.
+type incompletePhi struct {
+	key interface{}
+	phi *Value
+}
+
+// Build lowers u, the IR of a function compiled for target, to SSA form. captured are the variables
+// captured by functions nested in it (see semantic.AnalyzeClosures), which can't be turned into
+// values.
+func Build(u *ir.Unit, target semantic.Target, captured map[semantic.Node]bool) *Func {
+	b := &builder{
+		f: &Func{
+			Def:    u.Def,
+			Target: target,
+			consts: map[ir.Const]*Value{},
+		},
+		u:          u,
+		promoted:   map[semantic.Node]bool{},
+		types:      map[interface{}]semantic.PrimitiveType{},
+		defs:       map[interface{}]map[*Block]*Value{},
+		filled:     map[*Block]bool{},
+		sealed:     map[*Block]bool{},
+		incomplete: map[*Block][]incompletePhi{},
+	}
+	b.findPromoted(captured)
+	for i, t := range u.Temps {
+		b.types[ir.Temp(i)] = t
+	}
+	if u.Def.RType != nil {
+		b.types[ir.Result{}] = *u.Def.RType
+	}
+
+	blocks, ranges := b.split()
+	order := reversePostorder(blocks[0])
+	b.sealReady(order)
+	for _, blk := range order {
+		b.fill(blk, ranges[blk][0], ranges[blk][1])
+		b.filled[blk] = true
+		b.sealReady(order)
+	}
+	// Keep the (reachable) blocks in their original order.
+	for _, blk := range blocks {
+		if b.filled[blk] {
+			b.f.Blocks = append(b.f.Blocks, blk)
+		}
+	}
+	return b.f
+}
+
+// findPromoted finds the local primitive variables of the function that can be turned into values.
+func (b *builder) findPromoted(captured map[semantic.Node]bool) {
+	def := b.u.Def
+	for i := range def.Parameters {
+		p := &def.Parameters[i]
+		if t, ok := p.Type.DType.(semantic.PrimitiveType); ok && !p.Type.IsRef {
+			b.promoted[p] = true
+			b.types[semantic.Node(p)] = t
+		}
+	}
+	for _, ld := range def.LDefs {
+		if pv, ok := ld.(*semantic.PrimVarDef); ok {
+			b.promoted[pv] = true
+			b.types[semantic.Node(pv)] = pv.Type
+		}
+	}
+	for v := range captured {
+		delete(b.promoted, v)
+	}
+	for _, q := range b.u.Quads {
+		if v, ok := q.X.(ir.Var); ok && q.Op == ir.OpPar && q.Y == ir.ModeR {
+			delete(b.promoted, v.Decl)
+		}
+	}
+}
+
+// split splits the quadruples of the function into blocks, returning them in order (an empty entry
+// block first and the exit block, i.e. the one at endu, last) along with the range of the
+// quadruples of each. Successors and predecessors are connected, but the kind of blocks is set when
+// filling them.
+func (b *builder) split() ([]*Block, map[*Block][2]int) {
+	quads := b.u.Quads
+	end := len(quads) - 1
+	leaders := map[int]bool{1: true, end: true}
+	for i := 1; i < end; i++ {
+		switch quads[i].Op {
+		case ir.OpJump, ir.OpEQ, ir.OpNE, ir.OpLT, ir.OpGT, ir.OpLE, ir.OpGE:
+			leaders[int(quads[i].Z.(ir.Label))] = true
+			leaders[i+1] = true
+		case ir.OpRet:
+			leaders[i+1] = true
+		}
+	}
+	// The entry block has no predecessors, even if the first quadruple is the target of a jump
+	// (e.g. the condition of a loop), so that reading a variable there yields its initial value.
+	entry := b.f.newBlock()
+	blocks := []*Block{entry}
+	ranges := map[*Block][2]int{entry: {1, 1}}
+	at := map[int]*Block{}
+	for i := 1; i <= end; i++ {
+		if leaders[i] {
+			blk := b.f.newBlock()
+			if n := len(blocks); n > 1 {
+				ranges[blocks[n-1]] = [2]int{ranges[blocks[n-1]][0], i}
+			}
+			blocks = append(blocks, blk)
+			ranges[blk] = [2]int{i, i + 1}
+			at[i] = blk
+		}
+	}
+	link := func(from *Block, to int) {
+		from.Succs = append(from.Succs, at[to])
+		at[to].Preds = append(at[to].Preds, from)
+	}
+	link(entry, 1)
+	for _, blk := range blocks[1 : len(blocks)-1] {
+		last := ranges[blk][1] - 1
+		switch q := quads[last]; q.Op {
+		case ir.OpJump:
+			link(blk, int(q.Z.(ir.Label)))
+		case ir.OpEQ, ir.OpNE, ir.OpLT, ir.OpGT, ir.OpLE, ir.OpGE:
+			link(blk, int(q.Z.(ir.Label)))
+			link(blk, last+1)
+		case ir.OpRet:
+		default:
+			link(blk, last+1)
+		}
+	}
+	// Unreachable blocks still count as predecessors until removed.
+	reachable := map[*Block]bool{}
+	for _, blk := range reversePostorder(blocks[0]) {
+		reachable[blk] = true
+	}
+	for _, blk := range blocks {
+		if !reachable[blk] {
+			for _, s := range blk.Succs {
+				s.removePred(s.predIndex(blk))
+			}
+		}
+	}
+	return blocks, ranges
+}
+
+// reversePostorder returns the blocks reachable from entry in reverse postorder.
+func reversePostorder(entry *Block) []*Block {
+	var post []*Block
+	seen := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		for _, s := range b.Succs {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(entry)
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// sealReady seals all blocks whose predecessors have all been filled.
+func (b *builder) sealReady(blocks []*Block) {
+	for _, blk := range blocks {
+		if b.sealed[blk] {
+			continue
+		}
+		ready := true
+		for _, p := range blk.Preds {
+			ready = ready && b.filled[p]
+		}
+		if ready {
+			b.seal(blk)
+		}
+	}
+}
+
+// seal seals blk, completing its phis.
+func (b *builder) seal(blk *Block) {
+	b.sealed[blk] = true
+	for _, ip := range b.incomplete[blk] {
+		b.addPhiArgs(ip.key, ip.phi)
+	}
+	delete(b.incomplete, blk)
+}
+
+// write records v as the current value of variable key in blk.
+func (b *builder) write(key interface{}, blk *Block, v *Value) {
+	if b.defs[key] == nil {
+		b.defs[key] = map[*Block]*Value{}
+	}
+	b.defs[key][blk] = v
+}
+
+// read returns the current value of variable key in blk.
+func (b *builder) read(key interface{}, blk *Block) *Value {
+	if v, ok := b.defs[key][blk]; ok {
+		return v
+	}
+	var v *Value
+	switch {
+	case !b.sealed[blk]:
+		v = b.newPhi(key, blk)
+		b.incomplete[blk] = append(b.incomplete[blk], incompletePhi{key: key, phi: v})
+	case len(blk.Preds) == 0:
+		v = b.initial(key)
+	case len(blk.Preds) == 1:
+		v = b.read(key, blk.Preds[0])
+	default:
+		v = b.newPhi(key, blk)
+		// Record the phi first, to break cycles.
+		b.write(key, blk, v)
+		b.addPhiArgs(key, v)
+	}
+	b.write(key, blk, v)
+	return v
+}
+
+// newPhi returns a new phi (without arguments) for variable key, at the beginning of blk.
+func (b *builder) newPhi(key interface{}, blk *Block) *Value {
+	v := b.f.newValue(OpPhi, b.types[key], nil)
+	v.Block = blk
+	blk.Values = append([]*Value{v}, blk.Values...)
+	return v
+}
+
+// addPhiArgs adds the values of variable key in each predecessor of its block to phi.
+func (b *builder) addPhiArgs(key interface{}, phi *Value) {
+	for _, p := range phi.Block.Preds {
+		phi.Args = append(phi.Args, b.read(key, p))
+	}
+}
+
+// initial returns the value of variable key on entry: the argument for parameters, 0 for anything
+// else (uninitialized).
+func (b *builder) initial(key interface{}) *Value {
+	if p, ok := key.(*semantic.ParDef); ok {
+		return b.f.newValue(OpParam, b.types[key], p)
+	}
+	return b.f.constant(0, b.types[key])
+}
+
+// add appends a new value to blk and returns it.
+func (b *builder) add(blk *Block, op Op, t semantic.PrimitiveType, aux interface{},
+	args ...*Value) *Value {
+	v := b.f.newValue(op, t, aux, args...)
+	v.Block = blk
+	blk.Values = append(blk.Values, v)
+	return v
+}
+
+// key returns the variable operand o stands for, if it's turned into values.
+func (b *builder) key(o ir.Operand) (interface{}, bool) {
+	switch o := o.(type) {
+	case ir.Var:
+		return o.Decl, b.promoted[o.Decl]
+	case ir.Temp, ir.Result:
+		return o, true
+	default:
+		return nil, false
+	}
+}
+
+// varType returns the (primitive) type of variable decl (of its elements, for arrays).
+func varType(decl semantic.Node) semantic.PrimitiveType {
+	var dt semantic.DType
+	switch decl := decl.(type) {
+	case *semantic.ParDef:
+		dt = decl.Type.DType
+	case *semantic.PrimVarDef:
+		dt = decl.Type
+	case *semantic.ArrayDef:
+		dt = decl.Type
+	default:
+		panic(fmt.Sprintf("variable declaration of invalid type %T", decl))
+	}
+	if at, ok := dt.(semantic.ArrayType); ok {
+		return at.PrimitiveType
+	}
+	return dt.(semantic.PrimitiveType)
+}
+
+// use returns the value of operand o in blk.
+func (b *builder) use(o ir.Operand, blk *Block) *Value {
+	if key, ok := b.key(o); ok {
+		return b.read(key, blk)
+	}
+	switch o := o.(type) {
+	case ir.Const:
+		return b.f.constant(o.Val, o.Type)
+	case ir.Var, ir.Deref:
+		a := b.addr(o, blk)
+		return b.add(blk, OpLoad, a.Type, nil, a)
+	default:
+		panic(fmt.Sprintf("operand %v has no value", o))
+	}
+}
+
+// addr returns the address of operand o (which must not be turned into values) in blk.
+func (b *builder) addr(o ir.Operand, blk *Block) *Value {
+	switch o := o.(type) {
+	case ir.Var:
+		return b.add(blk, OpAddr, varType(o.Decl), o)
+	case ir.Deref:
+		return b.read(ir.Temp(o), blk)
+	case ir.Str:
+		return b.add(blk, OpStr, semantic.PrimitiveTypeByte, string(o))
+	default:
+		panic(fmt.Sprintf("operand %v has no address", o))
+	}
+}
+
+// assign assigns v to operand o in blk.
+func (b *builder) assign(o ir.Operand, v *Value, blk *Block) {
+	if key, ok := b.key(o); ok {
+		b.write(key, blk, v)
+		return
+	}
+	b.add(blk, OpStore, v.Type, nil, b.addr(o, blk), v)
+}
+
+// ops are the operations for each arithmetic operator.
+var ops = map[ir.Op]Op{
+	ir.OpPlus:  OpAdd,
+	ir.OpMinus: OpSub,
+	ir.OpMult:  OpMul,
+	ir.OpDiv:   OpDiv,
+	ir.OpMod:   OpMod,
+}
+
+// fill fills blk with the values of its quadruples, from start up to end.
+func (b *builder) fill(blk *Block, start, end int) {
+	blk.Kind = KindJump
+	for i := start; i < end; i++ {
+		q := b.u.Quads[i]
+		switch q.Op {
+		case ir.OpAssign:
+			v := b.use(q.X, blk)
+			if _, ok := b.key(q.Z); ok {
+				v = b.add(blk, OpCopy, v.Type, nil, v)
+			}
+			b.assign(q.Z, v, blk)
+		case ir.OpPlus, ir.OpMinus, ir.OpMult, ir.OpDiv, ir.OpMod:
+			x, y := b.use(q.X, blk), b.use(q.Y, blk)
+			t := b.u.Temps[q.Z.(ir.Temp)]
+			b.assign(q.Z, b.add(blk, ops[q.Op], t, nil, x, y), blk)
+		case ir.OpArray:
+			base := b.addr(q.X, blk)
+			e := b.add(blk, OpElem, base.Type, nil, base, b.use(q.Y, blk))
+			b.write(q.Z, blk, e)
+		case ir.OpBound:
+			b.add(blk, OpBound, 0, q.Z, b.use(q.X, blk), b.use(q.Y, blk))
+		case ir.OpEQ, ir.OpNE, ir.OpLT, ir.OpGT, ir.OpLE, ir.OpGE:
+			blk.Kind = KindIf
+			blk.Cmp = q.Op
+			blk.Ctrl = []*Value{b.use(q.X, blk), b.use(q.Y, blk)}
+			return
+		case ir.OpPar:
+			switch q.Y.(ir.Mode) {
+			case ir.ModeV:
+				b.pars = append(b.pars, b.use(q.X, blk))
+			case ir.ModeR:
+				b.pars = append(b.pars, b.addr(q.X, blk))
+			case ir.ModeRet:
+				b.ret, _ = b.key(q.X)
+				continue
+			}
+			b.modes = append(b.modes, q.Y.(ir.Mode))
+		case ir.OpCall:
+			def := q.Z.(ir.Func).Def
+			var t semantic.PrimitiveType
+			if def.RType != nil {
+				t = *def.RType
+			}
+			c := b.add(blk, OpCall, t, &Call{Def: def, Modes: b.modes}, b.pars...)
+			if b.ret != nil {
+				b.write(b.ret, blk, c)
+			}
+			b.pars, b.modes, b.ret = nil, nil, nil
+		case ir.OpRet, ir.OpEndu:
+			blk.Kind = KindRet
+			if b.u.Def.RType != nil {
+				blk.Ctrl = []*Value{b.read(ir.Result{}, blk)}
+			}
+			return
+		case ir.OpJump:
+			return
+		default:
+			panic(fmt.Sprintf("unexpected quadruple %v", q))
+		}
+	}
+}
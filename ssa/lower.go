@@ -0,0 +1,377 @@
+package ssa
+
+import (
+	"fmt"
+
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Lowering out of SSA gives each value (that needs one) a temporary of its own, so phis
+// become copies to their temporaries at the end of the predecessors. Critical edges into blocks
+// with phis are split first, so that these copies only happen along their edge, and the copies
+// into the phis of a block are sequentialized as a parallel copy (each reads the values from
+// before any of them). Where possible, the argument of a phi is computed right into the temporary
+// of the phi, making the copy unnecessary. Loads only used once, later in the same block with no
+// stores or calls in between, are turned back into memory operands of their use, as the IR
+// generator produces them.
+
+// lowerer is the state of the lowering of a function.
+type lowerer struct {
+	f *Func
+	u *ir.Unit
+	// temps are the temporaries holding the values.
+	temps map[*Value]ir.Temp
+	// uses are the numbers of uses of the values.
+	uses map[*Value]int
+	// inlined are the loads turned into operands of their single use.
+	inlined map[*Value]bool
+	// labels are the indices of the first quadruples of the blocks.
+	labels map[*Block]int
+	// patches are the jumps to blocks, by the index of their quadruple.
+	patches map[int]*Block
+}
+
+// Lower translates f back to IR.
+func Lower(f *Func) *ir.Unit {
+	l := &lowerer{
+		f:       f,
+		u:       &ir.Unit{Def: f.Def},
+		temps:   map[*Value]ir.Temp{},
+		uses:    map[*Value]int{},
+		inlined: map[*Value]bool{},
+		labels:  map[*Block]int{},
+		patches: map[int]*Block{},
+	}
+	l.splitCriticalEdges()
+	l.findInlined()
+	l.coalesce()
+
+	l.emit(ir.OpUnit, ir.Func{Def: f.Def}, nil, nil)
+	for i, b := range f.Blocks {
+		var next *Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
+		l.labels[b] = len(l.u.Quads)
+		l.block(b, next)
+	}
+	l.emit(ir.OpEndu, ir.Func{Def: f.Def}, nil, nil)
+	for i, b := range l.patches {
+		l.u.Quads[i].Z = ir.Label(l.labels[b])
+	}
+	return l.u
+}
+
+// splitCriticalEdges splits the edges from blocks with many successors to blocks with phis, placing
+// the new blocks right after their predecessors.
+func (l *lowerer) splitCriticalEdges() {
+	var blocks []*Block
+	for _, b := range l.f.Blocks {
+		blocks = append(blocks, b)
+		if len(b.Succs) < 2 {
+			continue
+		}
+		for i, s := range b.Succs {
+			if !s.hasPhis() {
+				continue
+			}
+			n := l.f.newBlock()
+			n.Kind = KindJump
+			n.Succs = []*Block{s}
+			n.Preds = []*Block{b}
+			b.Succs[i] = n
+			// With both successors the same, split the edges in turn.
+			for j, p := range s.Preds {
+				if p == b {
+					s.Preds[j] = n
+					break
+				}
+			}
+			blocks = append(blocks, n)
+		}
+	}
+	l.f.Blocks = blocks
+}
+
+// findInlined finds the loads that can be turned into operands of their use.
+func (l *lowerer) findInlined() {
+	for _, b := range l.f.Blocks {
+		for _, v := range b.Values {
+			for _, a := range v.Args {
+				l.uses[a]++
+			}
+		}
+		for _, c := range b.Ctrl {
+			l.uses[c]++
+		}
+	}
+	for _, b := range l.f.Blocks {
+		// pending are the candidate loads since the last store or call.
+		pending := map[*Value]bool{}
+		for _, v := range b.Values {
+			if v.Op != OpPhi {
+				for _, a := range v.Args {
+					if pending[a] {
+						l.inlined[a] = true
+					}
+				}
+			}
+			switch v.Op {
+			case OpStore, OpCall:
+				pending = map[*Value]bool{}
+			case OpLoad:
+				if l.uses[v] == 1 {
+					pending[v] = true
+				}
+			}
+		}
+		for _, c := range b.Ctrl {
+			if pending[c] {
+				l.inlined[c] = true
+			}
+		}
+	}
+}
+
+// coalesce gives the arguments of phis the temporary of the phi, where it can be overwritten that
+// early: the argument must be computed in the corresponding predecessor, after any other use of
+// the phi there, and only used by the phi.
+func (l *lowerer) coalesce() {
+	for _, b := range l.f.Blocks {
+		for _, phi := range b.Values {
+			if phi.Op != OpPhi {
+				break
+			}
+			for i, a := range phi.Args {
+				switch a.Op {
+				case OpCopy, OpAdd, OpSub, OpMul, OpDiv, OpMod, OpLoad, OpCall:
+				default:
+					continue
+				}
+				if p := b.Preds[i]; a.Block == p && l.uses[a] == 1 && !l.usedAfter(phi, a) {
+					l.temps[a] = l.temp(phi)
+				}
+			}
+		}
+	}
+}
+
+// usedAfter returns whether phi is used after a in the block of a (including by the copies into
+// the phis of its successor).
+func (l *lowerer) usedAfter(phi, a *Value) bool {
+	b := a.Block
+	after := false
+	for _, v := range b.Values {
+		if after {
+			for _, x := range v.Args {
+				if x == phi {
+					return true
+				}
+			}
+		}
+		after = after || v == a
+	}
+	for _, c := range b.Ctrl {
+		if c == phi {
+			return true
+		}
+	}
+	for _, s := range b.Succs {
+		i := s.predIndex(b)
+		for _, v := range s.Values {
+			if v.Op == OpPhi && v != phi && v.Args[i] == phi {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emit appends a new quadruple and returns its index.
+func (l *lowerer) emit(op ir.Op, x, y, z ir.Operand) int {
+	l.u.Quads = append(l.u.Quads, ir.Quad{Op: op, X: x, Y: y, Z: z})
+	return len(l.u.Quads) - 1
+}
+
+// jump appends a new quadruple jumping to b.
+func (l *lowerer) jump(op ir.Op, x, y ir.Operand, b *Block) {
+	l.patches[l.emit(op, x, y, nil)] = b
+}
+
+// temp returns the temporary holding v.
+func (l *lowerer) temp(v *Value) ir.Temp {
+	if t, ok := l.temps[v]; ok {
+		return t
+	}
+	t := l.newTemp(v.Type)
+	l.temps[v] = t
+	return t
+}
+
+// newTemp returns a new temporary of type t.
+func (l *lowerer) newTemp(t semantic.PrimitiveType) ir.Temp {
+	l.u.Temps = append(l.u.Temps, t)
+	return ir.Temp(len(l.u.Temps) - 1)
+}
+
+// val returns the operand standing for the value of v.
+func (l *lowerer) val(v *Value) ir.Operand {
+	switch v.Op {
+	case OpConst:
+		return ir.Const{Val: v.Aux.(int32), Type: v.Type}
+	case OpParam:
+		p := v.Aux.(*semantic.ParDef)
+		return ir.Var{Decl: p, ID: p.ID}
+	case OpLoad:
+		if l.inlined[v] {
+			return l.addr(v.Args[0])
+		}
+	}
+	return l.temp(v)
+}
+
+// addr returns the operand standing for what address v points to.
+func (l *lowerer) addr(v *Value) ir.Operand {
+	switch v.Op {
+	case OpAddr:
+		return v.Aux.(ir.Var)
+	case OpStr:
+		return ir.Str(v.Aux.(string))
+	case OpElem:
+		return ir.Deref(l.temp(v))
+	default:
+		panic(fmt.Sprintf("value %v is not an address", v))
+	}
+}
+
+// irOps are the arithmetic operators for each operation.
+var irOps = map[Op]ir.Op{
+	OpAdd: ir.OpPlus,
+	OpSub: ir.OpMinus,
+	OpMul: ir.OpMult,
+	OpDiv: ir.OpDiv,
+	OpMod: ir.OpMod,
+}
+
+// negations are the comparison operators with the opposite result.
+var negations = map[ir.Op]ir.Op{
+	ir.OpEQ: ir.OpNE,
+	ir.OpNE: ir.OpEQ,
+	ir.OpLT: ir.OpGE,
+	ir.OpGE: ir.OpLT,
+	ir.OpGT: ir.OpLE,
+	ir.OpLE: ir.OpGT,
+}
+
+// block emits the quadruples of b, which is followed by next (nil if last).
+func (l *lowerer) block(b, next *Block) {
+	for _, v := range b.Values {
+		switch v.Op {
+		case OpPhi, OpAddr, OpStr:
+		case OpCopy:
+			l.emit(ir.OpAssign, l.val(v.Args[0]), nil, l.temp(v))
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod:
+			l.emit(irOps[v.Op], l.val(v.Args[0]), l.val(v.Args[1]), l.temp(v))
+		case OpElem:
+			l.emit(ir.OpArray, l.addr(v.Args[0]), l.val(v.Args[1]), l.temp(v))
+		case OpBound:
+			l.emit(ir.OpBound, l.val(v.Args[0]), l.val(v.Args[1]), v.Aux.(ir.Var))
+		case OpLoad:
+			if !l.inlined[v] {
+				l.emit(ir.OpAssign, l.addr(v.Args[0]), nil, l.temp(v))
+			}
+		case OpStore:
+			l.emit(ir.OpAssign, l.val(v.Args[1]), nil, l.addr(v.Args[0]))
+		case OpCall:
+			c := v.Aux.(*Call)
+			for i, a := range v.Args {
+				if c.Modes[i] == ir.ModeV {
+					l.emit(ir.OpPar, l.val(a), ir.ModeV, nil)
+				} else {
+					l.emit(ir.OpPar, l.addr(a), ir.ModeR, nil)
+				}
+			}
+			if c.Def.RType != nil {
+				l.emit(ir.OpPar, l.temp(v), ir.ModeRet, nil)
+			}
+			l.emit(ir.OpCall, nil, nil, ir.Func{Def: c.Def})
+		default:
+			panic(fmt.Sprintf("unexpected value %s", v.LongString()))
+		}
+	}
+
+	switch b.Kind {
+	case KindJump:
+		s := b.Succs[0]
+		l.copyPhis(s, s.predIndex(b))
+		if s != next {
+			l.jump(ir.OpJump, nil, nil, s)
+		}
+	case KindIf:
+		x, y := l.val(b.Ctrl[0]), l.val(b.Ctrl[1])
+		then, els := b.Succs[0], b.Succs[1]
+		switch {
+		case then == next:
+			l.jump(negations[b.Cmp], x, y, els)
+		default:
+			l.jump(b.Cmp, x, y, then)
+			if els != next {
+				l.jump(ir.OpJump, nil, nil, els)
+			}
+		}
+	case KindRet:
+		if len(b.Ctrl) > 0 {
+			l.emit(ir.OpAssign, l.val(b.Ctrl[0]), nil, ir.Result{})
+		}
+		if next != nil {
+			l.emit(ir.OpRet, nil, nil, nil)
+		}
+	}
+}
+
+// copyPhis emits the copies of the i-th arguments of the phis of b into their temporaries, as a
+// parallel copy.
+func (l *lowerer) copyPhis(b *Block, i int) {
+	type copy struct {
+		dst ir.Temp
+		src ir.Operand
+	}
+	var copies []copy
+	for _, v := range b.Values {
+		if v.Op != OpPhi {
+			break
+		}
+		if src := l.val(v.Args[i]); src != l.temp(v) {
+			copies = append(copies, copy{dst: l.temp(v), src: src})
+		}
+	}
+	for len(copies) > 0 {
+		// Emit a copy whose destination no other copy reads. If there is none, they form cycles:
+		// save the destination of one to a new temporary, for the others to read instead.
+		ready := -1
+		for j, c := range copies {
+			read := false
+			for k, d := range copies {
+				read = read || k != j && d.src == c.dst
+			}
+			if !read {
+				ready = j
+				break
+			}
+		}
+		if ready < 0 {
+			ready = 0
+			dst := copies[0].dst
+			t := l.newTemp(l.u.Temps[dst])
+			l.emit(ir.OpAssign, dst, nil, t)
+			for k := range copies {
+				if copies[k].src == dst {
+					copies[k].src = t
+				}
+			}
+		}
+		l.emit(ir.OpAssign, copies[ready].src, nil, copies[ready].dst)
+		copies = append(copies[:ready], copies[ready+1:]...)
+	}
+}
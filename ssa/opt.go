@@ -0,0 +1,465 @@
+package ssa
+
+import (
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// Optimize optimizes f in place, according to level: 0 does nothing, 1 performs constant
+// propagation (including folding branches on constants), copy propagation, dead code elimination
+// and removal of empty blocks, and 2 common subexpression elimination as well. Passes are repeated
+// for as long as any of them changes something.
+func Optimize(f *Func, level int) {
+	if level < 1 {
+		return
+	}
+	for changed := true; changed; {
+		changed = f.propagateConstants()
+		changed = f.propagateCopies() || changed
+		if level >= 2 {
+			changed = f.eliminateCommon() || changed
+		}
+		changed = f.eliminateDead() || changed
+		changed = f.simplifyBlocks() || changed
+	}
+}
+
+// Captured returns the variables of ast captured by nested functions (see Build).
+func Captured(ast *semantic.Ast) map[semantic.Node]bool {
+	captured := map[semantic.Node]bool{}
+	for _, c := range semantic.AnalyzeClosures(ast) {
+		for _, v := range c.Captures() {
+			captured[v] = true
+		}
+	}
+	return captured
+}
+
+// OptimizeProgram optimizes p, the IR of ast, according to level (see Optimize), replacing each of
+// its units by the optimized one.
+func OptimizeProgram(ast *semantic.Ast, p *ir.Program, level int) {
+	if level < 1 {
+		return
+	}
+	captured := Captured(ast)
+	for i, u := range p.Units {
+		f := Build(u, p.Target, captured)
+		Optimize(f, level)
+		p.Units[i] = Lower(f)
+	}
+}
+
+// find returns the value v stands for, according to the replacements in m.
+func find(m map[*Value]*Value, v *Value) *Value {
+	for {
+		w, ok := m[v]
+		if !ok {
+			return v
+		}
+		v = w
+	}
+}
+
+// replace replaces every value of f in m by the value it stands for, removing it from its block.
+func (f *Func) replace(m map[*Value]*Value) {
+	for _, b := range f.Blocks {
+		vs := b.Values[:0]
+		for _, v := range b.Values {
+			if _, ok := m[v]; ok {
+				continue
+			}
+			for i, a := range v.Args {
+				v.Args[i] = find(m, a)
+			}
+			vs = append(vs, v)
+		}
+		b.Values = vs
+		for i, c := range b.Ctrl {
+			b.Ctrl[i] = find(m, c)
+		}
+	}
+}
+
+// removeUnreachable removes the blocks of f no longer reachable from its entry.
+func (f *Func) removeUnreachable() {
+	reachable := map[*Block]bool{}
+	for _, b := range reversePostorder(f.Blocks[0]) {
+		reachable[b] = true
+	}
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			blocks = append(blocks, b)
+			continue
+		}
+		for _, s := range b.Succs {
+			if reachable[s] {
+				s.removePred(s.predIndex(b))
+			}
+		}
+	}
+	f.Blocks = blocks
+}
+
+// simplifyBlocks removes the (non-entry) blocks that do nothing but jump to another block, and
+// merges blocks into their single predecessor when it has no other successor. It returns whether
+// anything changed.
+func (f *Func) simplifyBlocks() bool {
+	changed := false
+	blocks := f.Blocks[:1]
+	for _, b := range f.Blocks[1:] {
+		if b.bypass() || b.merge() {
+			changed = true
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	f.Blocks = blocks
+	return changed
+}
+
+// hasPhis returns whether b has any phis.
+func (b *Block) hasPhis() bool {
+	return len(b.Values) > 0 && b.Values[0].Op == OpPhi
+}
+
+// bypass redirects the predecessors of b to its successor, if b is empty and that doesn't make
+// any of them a predecessor of a block with phis twice. It returns whether it did.
+func (b *Block) bypass() bool {
+	if b.Kind != KindJump || len(b.Values) > 0 || b.Succs[0] == b {
+		return false
+	}
+	s := b.Succs[0]
+	if s.hasPhis() {
+		for _, p := range b.Preds {
+			for _, q := range s.Preds {
+				if p == q {
+					return false
+				}
+			}
+		}
+	}
+	i := s.predIndex(b)
+	for k, p := range b.Preds {
+		for j, t := range p.Succs {
+			if t == b {
+				p.Succs[j] = s
+				break
+			}
+		}
+		if k == 0 {
+			s.Preds[i] = p
+			continue
+		}
+		s.Preds = append(s.Preds, p)
+		for _, v := range s.Values {
+			if v.Op == OpPhi {
+				v.Args = append(v.Args, v.Args[i])
+			}
+		}
+	}
+	return true
+}
+
+// merge appends b to its predecessor, if it's the only one and b its only successor. It returns
+// whether it did.
+func (b *Block) merge() bool {
+	if len(b.Preds) != 1 || b.hasPhis() {
+		return false
+	}
+	p := b.Preds[0]
+	if p == b || p.Kind != KindJump {
+		return false
+	}
+	for _, v := range b.Values {
+		v.Block = p
+	}
+	p.Values = append(p.Values, b.Values...)
+	p.Kind, p.Cmp, p.Ctrl, p.Succs = b.Kind, b.Cmp, b.Ctrl, b.Succs
+	for _, s := range b.Succs {
+		for j, q := range s.Preds {
+			if q == b {
+				s.Preds[j] = p
+				break
+			}
+		}
+	}
+	return true
+}
+
+// propagateConstants folds operations on constants (as well as a few algebraic identities) and
+// branches on comparisons of constants, removing any blocks that become unreachable. It returns
+// whether anything changed.
+func (f *Func) propagateConstants() bool {
+	m := map[*Value]*Value{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if c := f.fold(v, find(m, argOrNil(v, 0)), find(m, argOrNil(v, 1))); c != nil {
+				m[v] = c
+			}
+		}
+	}
+	f.replace(m)
+
+	folded := false
+	for _, b := range f.Blocks {
+		if b.Kind != KindIf || b.Ctrl[0].Op != OpConst || b.Ctrl[1].Op != OpConst {
+			continue
+		}
+		keep, drop := b.Succs[0], b.Succs[1]
+		if !semantic.Compare(compOps[b.Cmp], b.Ctrl[0].Aux.(int32), b.Ctrl[1].Aux.(int32)) {
+			keep, drop = drop, keep
+		}
+		drop.removePred(drop.predIndex(b))
+		b.Kind = KindJump
+		b.Ctrl = nil
+		b.Succs = []*Block{keep}
+		folded = true
+	}
+	if folded {
+		f.removeUnreachable()
+	}
+	return len(m) > 0 || folded
+}
+
+// argOrNil returns the i-th argument of v, or nil if it has none.
+func argOrNil(v *Value, i int) *Value {
+	if i < len(v.Args) {
+		return v.Args[i]
+	}
+	return nil
+}
+
+// fold returns the value arithmetic operation v, on x and y, can be replaced by, or nil if it can't
+// be simplified. Arithmetic wraps around, as at run time. Divisions by zero are left alone.
+func (f *Func) fold(v, x, y *Value) *Value {
+	switch v.Op {
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod:
+	default:
+		return nil
+	}
+	isConst := func(a *Value, c int32) bool {
+		return a.Op == OpConst && a.Aux.(int32) == c
+	}
+	if x.Op != OpConst || y.Op != OpConst {
+		switch {
+		case (v.Op == OpAdd || v.Op == OpSub) && isConst(y, 0), v.Op == OpMul && isConst(y, 1),
+			v.Op == OpDiv && isConst(y, 1):
+			return x
+		case v.Op == OpAdd && isConst(x, 0), v.Op == OpMul && isConst(x, 1):
+			return y
+		case v.Op == OpMul && (isConst(x, 0) || isConst(y, 0)):
+			return f.constant(0, v.Type)
+		}
+		return nil
+	}
+	a, b := x.Aux.(int32), y.Aux.(int32)
+	if b == 0 && (v.Op == OpDiv || v.Op == OpMod) {
+		return nil
+	}
+	return f.constant(f.Target.Arith(arithOps[v.Op], a, b, v.Type), v.Type)
+}
+
+// arithOps are the semantic arithmetic operators for each arithmetic operation.
+var arithOps = map[Op]semantic.ArithOp{
+	OpAdd: semantic.ArithOpPlus,
+	OpSub: semantic.ArithOpMinus,
+	OpMul: semantic.ArithOpMult,
+	OpDiv: semantic.ArithOpDiv,
+	OpMod: semantic.ArithOpMod,
+}
+
+// compOps are the semantic comparison operators for each comparison operator of blocks.
+var compOps = map[ir.Op]semantic.CompOp{
+	ir.OpEQ: semantic.CompOpEQ,
+	ir.OpNE: semantic.CompOpNE,
+	ir.OpLT: semantic.CompOpLT,
+	ir.OpGT: semantic.CompOpGT,
+	ir.OpLE: semantic.CompOpLE,
+	ir.OpGE: semantic.CompOpGE,
+}
+
+// propagateCopies replaces copies by the values they copy, and phis whose arguments are all the
+// same value (other than the phi itself) by that value. It returns whether anything changed.
+func (f *Func) propagateCopies() bool {
+	m := map[*Value]*Value{}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for _, v := range b.Values {
+				if _, ok := m[v]; ok {
+					continue
+				}
+				switch v.Op {
+				case OpCopy:
+					m[v] = find(m, v.Args[0])
+					changed = true
+				case OpPhi:
+					var same *Value
+					trivial := true
+					for _, a := range v.Args {
+						a = find(m, a)
+						if a == v || a == same {
+							continue
+						}
+						if same != nil {
+							trivial = false
+							break
+						}
+						same = a
+					}
+					if trivial && same != nil {
+						m[v] = same
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	f.replace(m)
+	return len(m) > 0
+}
+
+// eliminateDead removes the values whose results are unused and have no side effects. It returns
+// whether anything changed.
+func (f *Func) eliminateDead() bool {
+	live := map[*Value]bool{}
+	var work []*Value
+	mark := func(v *Value) {
+		if !live[v] {
+			live[v] = true
+			work = append(work, v)
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if v.hasSideEffects() {
+				mark(v)
+			}
+		}
+		for _, c := range b.Ctrl {
+			mark(c)
+		}
+	}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, a := range v.Args {
+			mark(a)
+		}
+	}
+
+	changed := false
+	for _, b := range f.Blocks {
+		vs := b.Values[:0]
+		for _, v := range b.Values {
+			if live[v] {
+				vs = append(vs, v)
+			} else {
+				changed = true
+			}
+		}
+		b.Values = vs
+	}
+	return changed
+}
+
+// cseKey identifies the result of a pure operation.
+type cseKey struct {
+	op     Op
+	typ    semantic.PrimitiveType
+	aux    interface{}
+	a0, a1 *Value
+}
+
+// eliminateCommon replaces each pure operation (arithmetic and addresses of variables and array
+// elements) by an identical one dominating it, if there is one. It returns whether anything
+// changed.
+func (f *Func) eliminateCommon() bool {
+	idom := dominators(f)
+	children := map[*Block][]*Block{}
+	for _, b := range f.Blocks[1:] {
+		children[idom[b]] = append(children[idom[b]], b)
+	}
+
+	m := map[*Value]*Value{}
+	avail := map[cseKey]*Value{}
+	var walk func(b *Block)
+	walk = func(b *Block) {
+		var added []cseKey
+		for _, v := range b.Values {
+			switch v.Op {
+			case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpAddr, OpElem:
+			default:
+				continue
+			}
+			k := cseKey{op: v.Op, typ: v.Type, aux: v.Aux}
+			if len(v.Args) > 0 {
+				k.a0, k.a1 = find(m, v.Args[0]), find(m, v.Args[1])
+				if (v.Op == OpAdd || v.Op == OpMul) && k.a0.ID > k.a1.ID {
+					k.a0, k.a1 = k.a1, k.a0
+				}
+			}
+			if w, ok := avail[k]; ok {
+				m[v] = w
+				continue
+			}
+			avail[k] = v
+			added = append(added, k)
+		}
+		for _, c := range children[b] {
+			walk(c)
+		}
+		for _, k := range added {
+			delete(avail, k)
+		}
+	}
+	walk(f.Blocks[0])
+	f.replace(m)
+	return len(m) > 0
+}
+
+// dominators returns the immediate dominator of each block of f (nil for the entry), following
+// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm" (2001). All blocks must be
+// reachable.
+func dominators(f *Func) map[*Block]*Block {
+	order := reversePostorder(f.Blocks[0])
+	index := map[*Block]int{}
+	for i, b := range order {
+		index[b] = i
+	}
+	idom := map[*Block]*Block{order[0]: order[0]}
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for index[a] > index[b] {
+				a = idom[a]
+			}
+			for index[b] > index[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var d *Block
+			for _, p := range b.Preds {
+				if idom[p] == nil {
+					continue
+				}
+				if d == nil {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if idom[b] != d {
+				idom[b] = d
+				changed = true
+			}
+		}
+	}
+	idom[order[0]] = nil
+	return idom
+}
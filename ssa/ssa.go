@@ -0,0 +1,275 @@
+// Package ssa implements the optimizer for Alan: the IR of each function is lowered to a
+// control-flow graph in static single assignment form, optimized and translated back to IR.
+package ssa
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+)
+
+// NOTE: Only the local primitive variables of a function that nothing else can access (i.e. that
+// are neither captured by nested functions nor passed by reference) are turned into SSA values,
+// along with the IR temporaries. All other variables (arrays, parameters passed by reference and
+// variables of enclosing functions) stay in memory, accessed through loads and stores, which (like
+// calls) are never reordered.
+
+// Op is the operation of a value.
+type Op int
+
+const (
+	// OpConst is a constant (Aux holds its int32 value). Constants belong to no block.
+	OpConst Op = iota
+	// OpParam is the value of a parameter passed by value on entry (Aux holds its
+	// *semantic.ParDef). Parameters belong to no block.
+	OpParam
+	// OpPhi selects the argument corresponding to the predecessor control came from.
+	OpPhi
+	// OpCopy is a copy of its argument.
+	OpCopy
+	// OpAdd is the sum of its arguments (OpSub, OpMul, OpDiv and OpMod similarly).
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	// OpAddr is the address of the storage of a variable (Aux holds it as an ir.Var).
+	OpAddr
+	// OpElem is the address of element Args[1] of the array at address Args[0].
+	OpElem
+	// OpBound fails unless 0 <= Args[0] < Args[1], Args[0] being an index into an array of size
+	// Args[1] (Aux holds the array as an ir.Var).
+	OpBound
+	// OpStr is the address of a string literal (Aux holds it as a string).
+	OpStr
+	// OpLoad is the value at address Args[0].
+	OpLoad
+	// OpStore stores Args[1] to address Args[0].
+	OpStore
+	// OpCall calls a function with arguments Args (Aux holds a *Call) and is its result.
+	OpCall
+)
+
+// opNames are the names of the operations, for printing.
+var opNames = map[Op]string{
+	OpConst: "const",
+	OpParam: "param",
+	OpPhi:   "phi",
+	OpCopy:  "copy",
+	OpAdd:   "add",
+	OpSub:   "sub",
+	OpMul:   "mul",
+	OpDiv:   "div",
+	OpMod:   "mod",
+	OpAddr:  "addr",
+	OpElem:  "elem",
+	OpBound: "bound",
+	OpStr:   "str",
+	OpLoad:  "load",
+	OpStore: "store",
+	OpCall:  "call",
+}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+
+// Call is the callee of an OpCall, along with the mode each argument is passed in (by value or by
+// reference, in which case the argument is an address).
+type Call struct {
+	Def   *semantic.FuncDef
+	Modes []ir.Mode
+}
+
+// Value is a value of a function, i.e. the result of an operation (or just its effect).
+type Value struct {
+	// ID is the value's unique number in its function.
+	ID int
+	Op Op
+	// Type is the type of the value (for addresses, that of what they point to).
+	Type semantic.PrimitiveType
+	// Args are the value's arguments (for phis, one per predecessor of its block, in order).
+	Args []*Value
+	// Aux is extra information, depending on Op.
+	Aux interface{}
+	// Block is the block the value belongs to (nil for constants and parameters).
+	Block *Block
+}
+
+func (v *Value) String() string {
+	switch v.Op {
+	case OpConst:
+		return ir.Const{Val: v.Aux.(int32), Type: v.Type}.String()
+	case OpParam:
+		return string(v.Aux.(*semantic.ParDef).ID)
+	default:
+		return "v" + strconv.Itoa(v.ID)
+	}
+}
+
+// LongString returns the definition of v.
+func (v *Value) LongString() string {
+	var b strings.Builder
+	if v.Op != OpStore && v.Op != OpBound && (v.Op != OpCall || v.Aux.(*Call).Def.RType != nil) {
+		fmt.Fprintf(&b, "%v = ", v)
+	}
+	b.WriteString(v.Op.String())
+	switch aux := v.Aux.(type) {
+	case ir.Var:
+		fmt.Fprintf(&b, " %s", aux)
+	case string:
+		fmt.Fprintf(&b, " %s", ir.Str(aux))
+	case *Call:
+		fmt.Fprintf(&b, " %s", aux.Def.ID)
+	}
+	for _, a := range v.Args {
+		fmt.Fprintf(&b, " %v", a)
+	}
+	return b.String()
+}
+
+// hasSideEffects returns whether v has effects other than its result, so that it may not be
+// removed even if its result is unused. Divisions may trap, unless by a (non-zero) constant, and
+// bounds checks too, unless on a constant index within a constant size.
+func (v *Value) hasSideEffects() bool {
+	switch v.Op {
+	case OpStore, OpCall:
+		return true
+	case OpDiv, OpMod:
+		d := v.Args[1]
+		return d.Op != OpConst || d.Aux.(int32) == 0
+	case OpBound:
+		i, n := v.Args[0], v.Args[1]
+		return i.Op != OpConst || n.Op != OpConst || i.Aux.(int32) < 0 ||
+			i.Aux.(int32) >= n.Aux.(int32)
+	default:
+		return false
+	}
+}
+
+// Kind is the kind of control flow at the end of a block.
+type Kind int
+
+const (
+	// KindJump continues to the single successor.
+	KindJump Kind = iota
+	// KindIf continues to the first successor if the comparison Cmp of the control values holds,
+	// to the second otherwise.
+	KindIf
+	// KindRet returns from the function, with the control value as the result (if any).
+	KindRet
+)
+
+// Block is a basic block.
+type Block struct {
+	// ID is the block's unique number in its function.
+	ID int
+	// Values are the block's values, in order, phis first.
+	Values []*Value
+	Kind   Kind
+	// Cmp is the comparison operator of KindIf blocks.
+	Cmp ir.Op
+	// Ctrl are the control values (two for KindIf, up to one for KindRet).
+	Ctrl []*Value
+	// Succs and Preds are the successors and predecessors of the block.
+	Succs, Preds []*Block
+}
+
+func (b *Block) String() string {
+	return "b" + strconv.Itoa(b.ID)
+}
+
+// predIndex returns the index of p in the predecessors of b.
+func (b *Block) predIndex(p *Block) int {
+	for i, q := range b.Preds {
+		if q == p {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("%v is not a predecessor of %v", p, b))
+}
+
+// removePred removes the i-th predecessor of b, along with the corresponding phi arguments.
+func (b *Block) removePred(i int) {
+	b.Preds = append(b.Preds[:i:i], b.Preds[i+1:]...)
+	for _, v := range b.Values {
+		if v.Op == OpPhi {
+			v.Args = append(v.Args[:i:i], v.Args[i+1:]...)
+		}
+	}
+}
+
+// Func is a function in SSA form.
+type Func struct {
+	// Def is the function's definition.
+	Def *semantic.FuncDef
+	// Target is the target the function is compiled for.
+	Target semantic.Target
+	// Blocks are the function's blocks, the entry one first.
+	Blocks []*Block
+	// consts are the function's constants, by value and type.
+	consts map[ir.Const]*Value
+	// nvalue and nblock are the numbers of values and blocks created so far.
+	nvalue, nblock int
+}
+
+// newBlock returns a new block of f (not yet added to its blocks).
+func (f *Func) newBlock() *Block {
+	f.nblock++
+	return &Block{ID: f.nblock}
+}
+
+// newValue returns a new value of f (not yet added to any block).
+func (f *Func) newValue(op Op, t semantic.PrimitiveType, aux interface{}, args ...*Value) *Value {
+	f.nvalue++
+	return &Value{ID: f.nvalue, Op: op, Type: t, Args: args, Aux: aux}
+}
+
+// constant returns the constant c of type t.
+func (f *Func) constant(c int32, t semantic.PrimitiveType) *Value {
+	k := ir.Const{Val: c, Type: t}
+	if v, ok := f.consts[k]; ok {
+		return v
+	}
+	v := f.newValue(OpConst, t, c)
+	f.consts[k] = v
+	return v
+}
+
+// Fprint writes f to w, in a human readable form.
+func Fprint(w io.Writer, f *Func) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s:\n", f.Def.ID)
+	for _, b := range f.Blocks {
+		fmt.Fprintf(bw, "%v:", b)
+		if len(b.Preds) > 0 {
+			bw.WriteString(" <-")
+			for _, p := range b.Preds {
+				fmt.Fprintf(bw, " %v", p)
+			}
+		}
+		bw.WriteString("\n")
+		for _, v := range b.Values {
+			fmt.Fprintf(bw, "\t%s\n", v.LongString())
+		}
+		switch b.Kind {
+		case KindJump:
+			fmt.Fprintf(bw, "\tjump %v\n", b.Succs[0])
+		case KindIf:
+			fmt.Fprintf(bw, "\tif %v %s %v -> %v %v\n", b.Ctrl[0], b.Cmp, b.Ctrl[1], b.Succs[0],
+				b.Succs[1])
+		case KindRet:
+			bw.WriteString("\tret")
+			if len(b.Ctrl) > 0 {
+				fmt.Fprintf(bw, " %v", b.Ctrl[0])
+			}
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,137 @@
+package ssa_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foxeng/alanc/internal/alantest"
+	"github.com/foxeng/alanc/internal/irexec"
+	"github.com/foxeng/alanc/interp"
+	"github.com/foxeng/alanc/ir"
+	"github.com/foxeng/alanc/semantic"
+	"github.com/foxeng/alanc/ssa"
+)
+
+// optimize returns the IR of src, compiled for target and optimized according to level.
+func optimize(t *testing.T, name string, src []byte, target semantic.Target,
+	level int) *ir.Program {
+	t.Helper()
+	ast := alantest.Parse(t, name, src, target)
+	p := ir.Generate(ast)
+	ssa.OptimizeProgram(ast, p, level)
+	return p
+}
+
+// TestOptimizeRun checks that running the optimized IR of programs behaves like interpreting them.
+func TestOptimizeRun(t *testing.T) {
+	tests := []struct {
+		file   string
+		target string
+		input  string
+	}{
+		{file: "hello.alan"},
+		{file: "hanoi.alan", input: "3\n"},
+		{file: "primes.alan", input: "50\n"},
+		{file: "bubblesort.alan"},
+		{file: "reverse.alan"},
+		{file: "cryptography.alan", input: "abc xyz\n"},
+		{file: "wrap.alan", input: "-2147483648\n70000\n"},
+		{file: "wrap.alan", target: "int16", input: "-32768\n70000\n"},
+		{file: "loopentry.alan"},
+	}
+	for _, test := range tests {
+		src := alantest.Example(t, test.file)
+		target := semantic.Targets[test.target]
+		var want strings.Builder
+		if err := interp.Run(alantest.Parse(t, test.file, src, target), strings.NewReader(test.input),
+			&want); err != nil {
+			t.Fatalf("Run(%q) failed: %v", test.file, err)
+		}
+		for level := 1; level <= 2; level++ {
+			var got strings.Builder
+			p := optimize(t, test.file, src, target, level)
+			if err := irexec.Run(p, strings.NewReader(test.input), &got); err != nil {
+				t.Fatalf("executing the IR of %q at level %d failed: %v", test.file, level, err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("executing the IR of %q at level %d output = %q, want %q", test.file,
+					level, got.String(), want.String())
+			}
+		}
+	}
+}
+
+// size returns the number of quadruples of p.
+func size(p *ir.Program) int {
+	n := 0
+	for _, u := range p.Units {
+		n += len(u.Quads)
+	}
+	return n
+}
+
+// TestOptimizeSize checks that optimizing makes programs smaller.
+func TestOptimizeSize(t *testing.T) {
+	for _, file := range []string{"primes.alan", "bubblesort.alan"} {
+		src := alantest.Example(t, file)
+		n0, n2 := size(optimize(t, file, src, semantic.Target{}, 0)),
+			size(optimize(t, file, src, semantic.Target{}, 2))
+		if n2 >= n0 {
+			t.Errorf("%q has %d quadruples at level 2, want less than the %d at level 0", file, n2,
+				n0)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	src := `main() : proc
+	i : int;
+	s : int;
+	a : int [2];
+{
+	s = 2 * 3;
+	i = 0;
+	while (i < s) {
+		if (1 > 2) s = s + 1;
+		a[i % 2] = a[i % 2] + i;
+		i = i + 1;
+	}
+	writeInteger(a[0]);
+}
+`
+	want := `main:
+b1:
+	jump b3
+b3: <- b1 b8
+	v7 = phi 0 v26
+	if v7 < 6 -> b8 b9
+b8: <- b3
+	v17 = mod v7 2
+	v18 = addr a
+	v19 = elem v18 v17
+	v20 = load v19
+	v21 = add v20 v7
+	store v19 v21
+	v26 = add v7 1
+	jump b3
+b9: <- b3
+	v9 = addr a
+	v10 = elem v9 0
+	v11 = load v10
+	call writeInteger v11
+	ret
+`
+	ast := alantest.Parse(t, "test.alan", []byte(src), semantic.Target{})
+	var b strings.Builder
+	p := ir.Generate(ast)
+	for _, u := range p.Units {
+		f := ssa.Build(u, p.Target, ssa.Captured(ast))
+		ssa.Optimize(f, 2)
+		if err := ssa.Fprint(&b, f); err != nil {
+			t.Fatalf("Fprint() failed: %v", err)
+		}
+	}
+	if got := b.String(); got != want {
+		t.Errorf("SSA =\n%s\nwant\n%s", got, want)
+	}
+}